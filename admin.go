@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"github.com/salesforce/rmux/protocol"
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+var (
+	RMUX_COMMAND = []byte("rmux")
+
+	ADMIN_NOPERM_RESPONSE      = []byte("-NOPERM this RMUX subcommand needs RMUX AUTH <admin password> first")
+	NO_ADMIN_PASSWORD_RESPONSE = []byte("-ERR RMUX AUTH called without any admin password configured")
+)
+
+//A subcommand of RMUX, rmux's own command for looking at (and controlling) a running server
+type adminSubcommand struct {
+	//The number of arguments that the subcommand takes, after its name
+	arity int
+	//Whether only clients that have sent RMUX AUTH can run it.  Every other subcommand is read-only
+	adminOnly bool
+	run       func(this *RedisMultiplexer, client *Client, args [][]byte) ([]byte, error)
+	help      string
+}
+
+var adminSubcommands map[string]adminSubcommand
+
+func init() {
+	// Set up in init, since HELP lists the table it is a part of
+	adminSubcommands = map[string]adminSubcommand{
+		"help":       {0, false, (*RedisMultiplexer).adminHelp, "HELP -- Lists the RMUX subcommands."},
+		"version":    {0, false, (*RedisMultiplexer).adminVersion, "VERSION -- The version of rmux."},
+		"pools":      {0, false, (*RedisMultiplexer).adminPools, "POOLS -- Each connection pool's endpoint, status, and connections in use and idle."},
+		"locate":     {1, false, (*RedisMultiplexer).adminLocate, "LOCATE <key> -- The slot and pool that a key hashes to, and the pool it fails over to."},
+		"ring":       {0, false, (*RedisMultiplexer).adminRing, "RING -- The hash ring's slots, as ranges of slots owned by each pool."},
+		"auth":       {1, false, (*RedisMultiplexer).adminAuth, "AUTH <password> -- Allows the subcommands that change the server."},
+		"resetstats": {0, true, (*RedisMultiplexer).adminResetStats, "RESETSTATS -- Resets the per-command stats of INFO commandstats."},
+		"drain":      {1, true, (*RedisMultiplexer).adminDrain, "DRAIN <endpoint> -- Puts a pool in maintenance. Its keys fail over, and its running commands finish."},
+		"enable":     {1, true, (*RedisMultiplexer).adminEnable, "ENABLE <endpoint> -- Takes a pool out of maintenance."},
+	}
+}
+
+//Whether or not the command is RMUX, which is always answered by rmux itself
+func (this *RedisMultiplexer) IsAdminCommand(command protocol.Command) bool {
+	return bytes.Equal(command.GetCommand(), RMUX_COMMAND)
+}
+
+//Runs an RMUX subcommand.  Replies are buffered on the client's writer, like every other locally answered command
+func (this *RedisMultiplexer) HandleAdminCommand(client *Client, command protocol.Command) error {
+	args := command.GetArgs()
+	if len(args) == 0 {
+		return client.WriteError(protocol.ERR_BAD_ARGUMENTS, false)
+	}
+
+	name := string(bytes.ToLower(args[0]))
+	subcommand, ok := adminSubcommands[name]
+	if !ok {
+		return client.WriteError(protocol.NewRecoverableError("unknown subcommand '"+string(args[0])+"'. Try RMUX HELP."), false)
+	} else if len(args)-1 != subcommand.arity {
+		return client.WriteError(protocol.NewRecoverableError("wrong number of arguments for 'rmux|"+name+"' command"), false)
+	} else if subcommand.adminOnly && !client.isAdmin {
+		return client.WriteLine(ADMIN_NOPERM_RESPONSE)
+	}
+
+	reply, err := subcommand.run(this, client, args[1:])
+	if err != nil {
+		return client.WriteError(err, false)
+	}
+	_, err = client.Writer.Write(reply)
+	return err
+}
+
+func (this *RedisMultiplexer) adminHelp(client *Client, args [][]byte) ([]byte, error) {
+	names := make([]string, 0, len(adminSubcommands))
+	for name := range adminSubcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([][]byte, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, []byte("+"+adminSubcommands[name].help+"\r\n"))
+	}
+	return protocol.JoinArrayResponse(lines), nil
+}
+
+func (this *RedisMultiplexer) adminVersion(client *Client, args [][]byte) ([]byte, error) {
+	return bulkString([]byte(version)), nil
+}
+
+func (this *RedisMultiplexer) adminPools(client *Client, args [][]byte) ([]byte, error) {
+	pools := make([][]byte, 0, len(this.ConnectionCluster))
+	for _, connectionPool := range this.ConnectionCluster {
+		pools = append(pools, protocol.JoinArrayResponse([][]byte{
+			bulkString([]byte("endpoint")), bulkString([]byte(connectionPool.Endpoint)),
+			bulkString([]byte("status")), bulkString([]byte(connectionPool.Status())),
+			bulkString([]byte("in_use")), integerReply(int64(atomic.LoadInt32(&connectionPool.Count))),
+			bulkString([]byte("idle")), integerReply(int64(connectionPool.IdleCount())),
+			bulkString([]byte("waiting")), integerReply(int64(atomic.LoadInt32(&connectionPool.Waiters))),
+		}))
+	}
+	return protocol.JoinArrayResponse(pools), nil
+}
+
+func (this *RedisMultiplexer) adminLocate(client *Client, args [][]byte) ([]byte, error) {
+	slot := this.HashRing.GetSlot(args[0])
+	owner := this.HashRing.ConnectionPools[slot]
+
+	// Where the key's commands go right now, which is the failover pool if the owner is down
+	var target []byte
+	if connectionPool, err := this.HashRing.GetConnectionPoolForSlot(slot); err == nil {
+		target = []byte(connectionPool.Endpoint)
+	}
+	var failover []byte
+	if connectionPool := this.HashRing.GetFailoverPool(slot); connectionPool != nil {
+		failover = []byte(connectionPool.Endpoint)
+	}
+
+	return protocol.JoinArrayResponse([][]byte{
+		bulkString([]byte("slot")), integerReply(int64(slot)),
+		bulkString([]byte("pool")), bulkString([]byte(owner.Endpoint)),
+		bulkString([]byte("status")), bulkString([]byte(owner.Status())),
+		bulkString([]byte("target")), bulkString(target),
+		bulkString([]byte("failover")), bulkString(failover),
+	}), nil
+}
+
+//Lists the ring's slots as [first slot, last slot, endpoint] ranges, the way CLUSTER SLOTS does
+func (this *RedisMultiplexer) adminRing(client *Client, args [][]byte) ([]byte, error) {
+	var ranges [][]byte
+	slots := this.HashRing.ConnectionPools
+	for start := 0; start < len(slots); {
+		end := start
+		for end+1 < len(slots) && slots[end+1] == slots[start] {
+			end++
+		}
+		ranges = append(ranges, protocol.JoinArrayResponse([][]byte{
+			integerReply(int64(start)), integerReply(int64(end)), bulkString([]byte(slots[start].Endpoint)),
+		}))
+		start = end + 1
+	}
+	return protocol.JoinArrayResponse(ranges), nil
+}
+
+func (this *RedisMultiplexer) adminAuth(client *Client, args [][]byte) ([]byte, error) {
+	if this.AdminPassword == "" {
+		return lineReply(NO_ADMIN_PASSWORD_RESPONSE), nil
+	} else if subtle.ConstantTimeCompare(args[0], []byte(this.AdminPassword)) != 1 {
+		return lineReply(WRONGPASS_RESPONSE), nil
+	}
+
+	client.isAdmin = true
+	return lineReply(protocol.OK_RESPONSE), nil
+}
+
+func (this *RedisMultiplexer) adminResetStats(client *Client, args [][]byte) ([]byte, error) {
+	this.commandStats.reset()
+	return lineReply(protocol.OK_RESPONSE), nil
+}
+
+func (this *RedisMultiplexer) adminDrain(client *Client, args [][]byte) ([]byte, error) {
+	if err := this.SetPoolMaintenance(string(args[0]), true); err != nil {
+		return nil, err
+	}
+	return lineReply(protocol.OK_RESPONSE), nil
+}
+
+func (this *RedisMultiplexer) adminEnable(client *Client, args [][]byte) ([]byte, error) {
+	if err := this.SetPoolMaintenance(string(args[0]), false); err != nil {
+		return nil, err
+	}
+	return lineReply(protocol.OK_RESPONSE), nil
+}
+
+//Ends a status or error line, such as +OK, with a newline
+func lineReply(line []byte) []byte {
+	return append(append([]byte{}, line...), protocol.REDIS_NEWLINE...)
+}
+
+func integerReply(value int64) []byte {
+	return []byte(":" + strconv.FormatInt(value, 10) + "\r\n")
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestAdminCommand(t *testing.T) {
+	recorder := &transactionRecorder{}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxAdminTest1.sock", recorder.handle)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxAdminTest2.sock", recorder.handle)
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxAdminTest.sock", "/tmp/rmuxAdminTest1.sock", "/tmp/rmuxAdminTest2.sock")
+	defer server.Listener.Close()
+	server.HashRing.Failover = true
+	server.AdminPassword = "admin"
+
+	key, _ := keysOnDifferentPools(t, server)
+	slot := strconv.Itoa(int(server.HashRing.GetSlot([]byte(key))))
+
+	client, output := newTestClient(server)
+	pool := func(endpoint string) string {
+		return "*10\r\n" + bulkReply("endpoint") + bulkReply(endpoint) + bulkReply("status") + bulkReply("up") +
+			bulkReply("in_use") + ":0\r\n" + bulkReply("idle") + ":2\r\n" + bulkReply("waiting") + ":0\r\n"
+	}
+
+	testData := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"rmux", "version"}, bulkReply(version)},
+		{[]string{"RMUX", "POOLS"}, "*2\r\n" + pool("/tmp/rmuxAdminTest1.sock") + pool("/tmp/rmuxAdminTest2.sock")},
+		{[]string{"rmux", "locate", key}, "*10\r\n" + bulkReply("slot") + ":" + slot + "\r\n" +
+			bulkReply("pool") + bulkReply("/tmp/rmuxAdminTest1.sock") + bulkReply("status") + bulkReply("up") +
+			bulkReply("target") + bulkReply("/tmp/rmuxAdminTest1.sock") + bulkReply("failover") + bulkReply("/tmp/rmuxAdminTest2.sock")},
+		{[]string{"rmux", "ring"}, "*2\r\n*3\r\n:0\r\n:0\r\n" + bulkReply("/tmp/rmuxAdminTest1.sock") +
+			"*3\r\n:1\r\n:1\r\n" + bulkReply("/tmp/rmuxAdminTest2.sock")},
+		{[]string{"rmux", "locate"}, "-ERR wrong number of arguments for 'rmux|locate' command\r\n"},
+		{[]string{"rmux", "bogus"}, "-ERR unknown subcommand 'bogus'. Try RMUX HELP.\r\n"},
+		{[]string{"rmux", "resetstats"}, string(ADMIN_NOPERM_RESPONSE) + "\r\n"},
+		{[]string{"rmux", "auth", "wrong"}, string(WRONGPASS_RESPONSE) + "\r\n"},
+		{[]string{"rmux", "auth", "admin"}, "+OK\r\n"},
+		{[]string{"rmux", "resetstats"}, "+OK\r\n"},
+	}
+	for _, data := range testData {
+		output.Reset()
+		runClientCommand(t, server, client, data.args...)
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+	}
+
+	if recorded := recorder.recorded(); recorded != "" {
+		t.Errorf("RMUX should not have reached redis, got %q", recorded)
+	}
+}
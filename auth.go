@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"github.com/salesforce/rmux/connection"
+	"github.com/salesforce/rmux/protocol"
+)
+
+var (
+	NOAUTH_RESPONSE      = []byte("-NOAUTH Authentication required.")
+	WRONGPASS_RESPONSE   = []byte("-WRONGPASS invalid username-password pair or user is disabled.")
+	NO_PASSWORD_RESPONSE = []byte("-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+
+	//The only user that clients can authenticate as, the same as redis without ACLs
+	DEFAULT_USER = []byte("default")
+)
+
+//Whether or not the client has sent the password that the server requires, if it requires one
+func (this *Client) IsAuthenticated() bool {
+	return this.Password == "" || this.authenticated
+}
+
+//Whether or not the command needs to be handled by HandleAuthCommand
+//AUTH is always answered by rmux itself, since rmux authenticates to redis on its own. Until a client authenticates,
+//every command other than QUIT (and HELLO, which can authenticate as well) is answered with NOAUTH
+func (this *Client) IsAuthCommand(command protocol.Command) bool {
+	name := command.GetCommand()
+	if bytes.Equal(name, connection.AUTH_COMMAND) {
+		return true
+	}
+	return !this.IsAuthenticated() && !bytes.Equal(name, protocol.QUIT_COMMAND) && !bytes.Equal(name, HELLO_COMMAND)
+}
+
+//Handles AUTH, or rejects a command from a client that has not authenticated yet
+//Replies are buffered on the client's writer, and get flushed along with the rest of the client's pipeline
+func (this *Client) HandleAuthCommand(command protocol.Command) error {
+	if !bytes.Equal(command.GetCommand(), connection.AUTH_COMMAND) {
+		return this.WriteLine(NOAUTH_RESPONSE)
+	}
+
+	if err := protocol.CheckCommand(command, this.Multiplexing); err != nil {
+		return this.WriteError(err, false)
+	} else if this.Password == "" {
+		return this.WriteLine(NO_PASSWORD_RESPONSE)
+	}
+
+	if !this.checkPassword(command.GetArgs()) {
+		return this.WriteLine(WRONGPASS_RESPONSE)
+	}
+
+	this.authenticated = true
+	return this.WriteLine(protocol.OK_RESPONSE)
+}
+
+//Checks the arguments of AUTH, which are either a password, or the default user and a password
+func (this *Client) checkPassword(args [][]byte) bool {
+	switch len(args) {
+	case 1:
+	case 2:
+		if !bytes.Equal(args[0], DEFAULT_USER) {
+			return false
+		}
+		args = args[1:]
+	default:
+		return false
+	}
+
+	return subtle.ConstantTimeCompare(args[0], []byte(this.Password)) == 1
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"testing"
+)
+
+func TestClientAuth(t *testing.T) {
+	listener := StartMockRedisServer(t, "/tmp/rmuxAuthTest1.sock", func(command protocol.Command) string {
+		return bulkReply("value")
+	})
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxAuthTest.sock", "/tmp/rmuxAuthTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	client.Password = "secret"
+
+	testData := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"get", "key"}, string(NOAUTH_RESPONSE) + "\r\n"},
+		{[]string{"auth", "wrong"}, string(WRONGPASS_RESPONSE) + "\r\n"},
+		{[]string{"auth", "someone", "secret"}, string(WRONGPASS_RESPONSE) + "\r\n"},
+		{[]string{"get", "key"}, string(NOAUTH_RESPONSE) + "\r\n"},
+		{[]string{"auth", "default", "secret"}, "+OK\r\n"},
+		{[]string{"get", "key"}, bulkReply("value")},
+		//AUTH never reaches redis, since rmux authenticates to redis on its own
+		{[]string{"auth", "secret"}, "+OK\r\n"},
+	}
+	for _, data := range testData {
+		output.Reset()
+		runClientCommand(t, server, client, data.args...)
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+	}
+
+	//Without a password, there is nothing to authenticate with
+	client, output = newTestClient(server)
+	runClientCommand(t, server, client, "auth", "secret")
+	if expected := string(NO_PASSWORD_RESPONSE) + "\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply to AUTH without a password.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/connection"
+	. "github.com/salesforce/rmux/log"
+	"github.com/salesforce/rmux/protocol"
+	"time"
+)
+
+//Runs a blocking command (BLPOP, XREAD BLOCK, ...) on a connection of its own, and replies with whatever redis sends
+//back.  The connection's reads wait for the command's own timeout, rather than the pool's read timeout, and are cut
+//short if the client goes away while it is blocked
+func (this *Client) RunBlocking(command protocol.Command, timeout time.Duration) error {
+	connectionPool, err := this.blockingPool(command)
+	if err == ERR_CROSS_POOL {
+		return this.WriteLine(CROSS_POOL_RESPONSE)
+	} else if err != nil {
+		return this.WriteError(err, false)
+	}
+
+	redisConn, err := connectionPool.GetBlockingConnection(timeout)
+	if err == connection.ERR_BLOCKING_POOL_FULL {
+		return this.WriteError(err, false)
+	} else if err != nil {
+		Error("Failed to retrieve a blocking connection from the provided connection pool")
+		return this.WriteError(ERR_CONNECTION_DOWN, false)
+	}
+	defer connectionPool.RecycleBlockingConnection(redisConn)
+
+	if err := sendCommands(redisConn, this.DatabaseId, []protocol.Command{command}); err != nil {
+		return this.WriteError(ERR_CONNECTION_DOWN, false)
+	}
+
+	// Redis only notices that a blocked client went away once it tries to reply, so hang up on it ourselves
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-this.closed:
+			redisConn.Interrupt()
+		case <-stop:
+		}
+	}()
+
+	replies, err := protocol.ReadServerResponses(redisConn.Reader, 1)
+	close(stop)
+	<-stopped
+
+	if err != nil {
+		Error("Error when reading the reply to a blocking command: %s. Disconnecting the connection.", err)
+		redisConn.Disconnect()
+		return this.WriteError(ERR_CONNECTION_DOWN, false)
+	}
+
+	_, err = this.Writer.Write(this.convertReply(command, replies[0]))
+	return err
+}
+
+//Gets the connection pool that a blocking command's keys hash to
+func (this *Client) blockingPool(command protocol.Command) (*connection.ConnectionPool, error) {
+	if !this.Multiplexing {
+		return this.HashRing.DefaultConnectionPool, nil
+	}
+
+	pools, _, downKeys := groupKeysByPool(this.HashRing, protocol.GetKeys(command))
+	if len(downKeys) > 0 || len(pools) == 0 {
+		return nil, ERR_CONNECTION_DOWN
+	} else if len(pools) > 1 {
+		return nil, ERR_CROSS_POOL
+	}
+	return pools[0], nil
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"testing"
+	"time"
+)
+
+//Answers blocking commands after the given delay, and everything else right away
+//Commands on the "forever" list never get an answer, until release is closed
+func blockingHandler(pool string, delay time.Duration, release chan struct{}) func(protocol.Command) string {
+	return func(command protocol.Command) string {
+		if string(command.GetFirstArg()) == "forever" {
+			<-release
+		}
+		if _, isBlocking := protocol.GetBlockingTimeout(command); isBlocking {
+			time.Sleep(delay)
+		}
+		return "*2\r\n" + bulkReply(string(command.GetFirstArg())) + bulkReply(pool)
+	}
+}
+
+func TestBlockingCommandOutlastsReadTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxBlockingTest1.sock", blockingHandler("pool1", 300*time.Millisecond, release))
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxBlockingTest2.sock", blockingHandler("pool2", 300*time.Millisecond, release))
+	defer listener2.Close()
+
+	//Every pool has a 100ms read timeout, which the blocking commands outlast
+	server := newTestMultiplexer(t, "/tmp/rmuxBlockingTest.sock", "/tmp/rmuxBlockingTest1.sock", "/tmp/rmuxBlockingTest2.sock")
+	defer server.Listener.Close()
+	key1, key2 := keysOnDifferentPools(t, server)
+
+	client, output := newTestClient(server)
+	runClientCommand(t, server, client, "blpop", key2, "1")
+	if expected := "*2\r\n" + bulkReply(key2) + bulkReply("pool2"); output.String() != expected {
+		t.Errorf("Unexpected reply to a blocking command.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "blpop", key1, key2, "1")
+	if expected := string(CROSS_POOL_RESPONSE) + "\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply to keys on different pools.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+
+	//Blocked clients don't take connections from the pool itself
+	for _, pool := range server.ConnectionCluster {
+		pool.SetBlockingCapacity(0)
+	}
+	output.Reset()
+	runClientCommand(t, server, client, "blpop", key1, "1")
+	if expected := "-ERR Too many clients are blocked on this server\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply with no blocking connections left.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	output.Reset()
+	runClientCommand(t, server, client, "lpop", key1)
+	if expected := "*2\r\n" + bulkReply(key1) + bulkReply("pool1"); output.String() != expected {
+		t.Errorf("Unexpected reply from the connection pool.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
+
+func TestBlockingConnectionReusedWithoutTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	listener := StartMockRedisServer(t, "/tmp/rmuxBlockingTest1.sock", blockingHandler("pool1", 20*time.Millisecond, release))
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxBlockingTest.sock", "/tmp/rmuxBlockingTest1.sock")
+	defer server.Listener.Close()
+	server.ConnectionCluster[0].SetBlockingCapacity(1)
+
+	//Both commands run on the one blocking connection, and neither should be cut short
+	client, output := newTestClient(server)
+	for i := 0; i < 2; i++ {
+		output.Reset()
+		runClientCommand(t, server, client, "blpop", "key", "0")
+		if expected := "*2\r\n" + bulkReply("key") + bulkReply("pool1"); output.String() != expected {
+			t.Errorf("Unexpected reply to blocking command %d.\r\nExpected %q\r\nGot      %q", i+1, expected, output.String())
+		}
+	}
+}
+
+func TestBlockingCommandInterruptedByClient(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	listener := StartMockRedisServer(t, "/tmp/rmuxBlockingTest1.sock", blockingHandler("pool1", 0, release))
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxBlockingTest.sock", "/tmp/rmuxBlockingTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	time.AfterFunc(50*time.Millisecond, func() { close(client.closed) })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runClientCommand(t, server, client, "blpop", "forever", "0")
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("The blocking command should have been cut short once the client went away")
+	}
+	if expected := "-ERR " + ERR_CONNECTION_DOWN.Error() + "\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply to an interrupted command.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
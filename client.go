@@ -34,8 +34,8 @@ import (
 	. "github.com/salesforce/rmux/writer"
 	"io"
 	"net"
+	"sync"
 	"time"
-	"github.com/salesforce/rmux/graphite"
 )
 
 type readItem struct {
@@ -45,6 +45,8 @@ type readItem struct {
 
 //Represents a redis client that is connected to our rmux server
 type Client struct {
+	//When the client last ran a command, in unix nanoseconds.  Kept first, so that it is aligned for atomic access
+	lastActive int64
 	//The underlying ReadWriter for this connection
 	Writer *FlexibleWriter
 	//Whether or not this client needs to consider multiplexing
@@ -58,13 +60,58 @@ type Client struct {
 	ReadChannel chan readItem
 	HashRing    *connection.HashRing
 	queued      []protocol.Command
+	//When each of the queued commands was queued
+	queuedAt []time.Time
 	Scanner     *protocol.RespScanner
+	//Whether keys on a down connection pool come back as nil from a split-up MGET, instead of failing the whole command
+	NilOnPoolDown bool
+	//Whether FLUSHDB and FLUSHALL are sent to every connection pool when multiplexing
+	AllowFlush bool
+	//Messages pushed by redis to a subscribed client
+	PushChannel chan pushItem
+	//Set while the client is subscribed to any channels or patterns
+	subscriber *subscriber
+	//The server's upstream subscriptions, shared with its other clients
+	subscriptionHub *subscriptionHub
+	//Set while the client is pinned to a connection for a transaction
+	transaction *transaction
+	//Closed once the client's connection has been read to the end
+	closed chan struct{}
+	//The password that the client must AUTH with before running any other command. Empty if none is required
+	Password string
+	//Set once the client has sent the right password
+	authenticated bool
+	//Set once the client has sent the server's admin password with RMUX AUTH
+	isAdmin bool
+	//Identifies the client, the same way that redis's CLIENT ID does
+	Id uint64
+	//Set by CLIENT SETNAME (or HELLO SETNAME), and returned by CLIENT GETNAME
+	Name string
+	//Set by CLIENT SETINFO
+	LibName    string
+	LibVersion string
+	//The RESP version that the client asked for with HELLO. Replies from redis are converted to it
+	Protocol int
+	//Every client of the server, for CLIENT LIST and CLIENT KILL
+	registry *clientRegistry
+	//When the client connected
+	created time.Time
+	//The client's state as of its last command, and the lock that guards it
+	info     clientInfo
+	infoLock sync.Mutex
+	//Set once another client has killed this one's connection
+	killed int32
+	//The server's call counts and latency for each command, for INFO commandstats
+	commandStats *commandStats
 }
 
 var (
 	ERR_QUIT            = errors.New("Client asked to quit")
 	ERR_CONNECTION_DOWN = errors.New(string(CONNECTION_DOWN_RESPONSE))
 	ERR_TIMEOUT         = errors.New("Proxy timeout")
+	ERR_POOLS_DISAGREE  = errors.New("Connection pools returned different replies")
+	ERR_CURSOR_OVERFLOW = errors.New("Scan cursor is too large to hold a connection pool index")
+	ERR_CROSS_POOL      = errors.New(string(CROSS_POOL_RESPONSE))
 )
 
 //Initializes a new client, for the given established net connection, with the specified read/write timeouts
@@ -75,18 +122,22 @@ func NewClient(connection net.Conn, readTimeout, writeTimeout time.Duration, isM
 	newClient.Active = true
 	newClient.Multiplexing = isMuliplexing
 	newClient.ReadChannel = make(chan readItem, 10000)
+	newClient.PushChannel = make(chan pushItem, 1000)
+	newClient.closed = make(chan struct{})
 	newClient.queued = make([]protocol.Command, 0, 4)
 	newClient.HashRing = hashRing
 	newClient.DatabaseId = 0
+	newClient.Protocol = 2
+	newClient.created = time.Now()
 	newClient.Scanner = protocol.NewRespScanner(connection)
 	return
 }
 
 //Parses the given command
 func (this *Client) ParseCommand(command protocol.Command) ([]byte, error) {
-	//block all unsafe commands
-	if !protocol.IsSupportedFunction(command.GetCommand(), this.Multiplexing, command.GetArgCount() > 2) {
-		return nil, protocol.ERR_COMMAND_UNSUPPORTED
+	//block all unsafe commands, and commands with the wrong number of arguments
+	if err := protocol.CheckCommand(command, this.Multiplexing); err != nil {
+		return nil, err
 	}
 
 	if bytes.Equal(command.GetCommand(), protocol.PING_COMMAND) {
@@ -107,6 +158,14 @@ func (this *Client) ParseCommand(command protocol.Command) ([]byte, error) {
 		return protocol.OK_RESPONSE, nil
 	}
 
+	if bytes.Equal(command.GetCommand(), HELLO_COMMAND) {
+		return this.hello(command)
+	}
+
+	if bytes.Equal(command.GetCommand(), CLIENT_COMMAND) {
+		return this.clientCommand(command)
+	}
+
 	return nil, nil
 }
 
@@ -128,68 +187,47 @@ func (this *Client) FlushLine(line []byte) (err error) {
 
 // Performs the query against the redis server and responds to the connected client with the response from redis.
 func (this *Client) FlushRedisAndRespond() error {
-	var err error
-
 	if !this.HasQueued() {
 		return this.Writer.Flush()
 	}
 
-	var connectionPool *connection.ConnectionPool
-	if !this.Multiplexing {
-		connectionPool = this.HashRing.DefaultConnectionPool
-	} else {
-		if len(this.queued) != 1 {
-			panic("Should not have multiple commands to flush when multiplexing")
-		}
-		connectionPool, err = this.HashRing.GetConnectionPool(this.queued[0])
-		if err != nil {
-			Error("Failed to retrieve a connection pool from the hashring")
-			this.ReadChannel <- readItem{nil, err}
-			return err
-		}
+	defer this.recordQueuedStats(this.queued, this.queuedAt)
+
+	if this.Multiplexing {
+		return this.flushMultiplexedAndRespond()
 	}
 
+	connectionPool := this.HashRing.DefaultConnectionPool
+
 	redisConn, err := connectionPool.GetConnection()
-	if err != nil {
+	if err == connection.ERR_ACQUIRE_TIMEOUT || err == connection.ERR_TOO_MANY_WAITERS {
+		// Redis is only busy, so every queued command gets the error, and the client can carry on
+		for range this.queued {
+			this.WriteError(err, false)
+		}
+		this.resetQueued()
+		return this.Writer.Flush()
+	} else if err != nil {
 		Error("Failed to retrieve an active connection from the provided connection pool")
 		this.ReadChannel <- readItem{nil, ERR_CONNECTION_DOWN}
 		return ERR_CONNECTION_DOWN
 	}
 	defer connectionPool.RecycleRemoteConnection(redisConn)
 
-	if redisConn.DatabaseId != this.DatabaseId {
-		if err := redisConn.SelectDatabase(this.DatabaseId); err != nil {
-			// Disconnect the current connection if selecting failed, will auto-reconnect this connection holder when queried later
-			redisConn.Disconnect()
-			return err
-		}
-	}
+	commands := this.queued
 
-	numCommands := len(this.queued)
-
-	startWrite := time.Now()
-
-	for _, command := range this.queued {
-		_, err := redisConn.Writer.Write(command.GetBuffer())
-		if err != nil {
-			Error("Error when writing to server: %s. Disconnecting the connection.", err)
-			redisConn.Disconnect()
-			return err
-		}
-	}
+	err = sendCommands(redisConn, this.DatabaseId, commands)
 	this.resetQueued()
-	for redisConn.Writer.Buffered() > 0 {
-		err := redisConn.Writer.Flush()
-		if err != nil {
-			Error("Error when flushing to server: %s. Disconnecting the connection.", err)
-			redisConn.Disconnect()
-			return err
-		}
+	if err != nil {
+		return err
 	}
 
-	graphite.Timing("redis_write", time.Now().Sub(startWrite))
-
-	if err := protocol.CopyServerResponses(redisConn.Reader, this.Writer, numCommands); err != nil {
+	if this.Protocol == 3 {
+		err = this.copyConvertedResponses(redisConn, commands)
+	} else {
+		err = protocol.CopyServerResponses(redisConn.Reader, this.Writer, len(commands))
+	}
+	if err != nil {
 		Error("Error when copying redis responses to client: %s. Disconnecting the connection.", err)
 		redisConn.Disconnect()
 		this.ReadChannel <- readItem{nil, err}
@@ -201,6 +239,51 @@ func (this *Client) FlushRedisAndRespond() error {
 	return nil
 }
 
+//Reads a reply from redis for each of the commands, and writes it out to the client in the client's RESP version
+func (this *Client) copyConvertedResponses(redisConn *connection.Connection, commands []protocol.Command) error {
+	replies, err := protocol.ReadServerResponses(redisConn.Reader, len(commands))
+	for i, reply := range replies {
+		if _, err := this.Writer.Write(this.convertReply(commands[i], reply)); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+//Converts a RESP2 reply from redis to the RESP version that the client speaks
+func (this *Client) convertReply(command protocol.Command, reply []byte) []byte {
+	if this.Protocol != 3 {
+		return reply
+	}
+	return protocol.ConvertToResp3(command, reply)
+}
+
+// Splits the queued pipeline up by connection pool, sends each part to its pool at the same time,
+// and responds to the client in the order the commands were queued.
+func (this *Client) flushMultiplexedAndRespond() error {
+	commands := this.queued
+	replies := make([]*pendingReply, len(commands))
+	requests := make([]*poolRequest, 0, len(commands))
+	for i, command := range commands {
+		replies[i] = this.prepareReply(command)
+		requests = append(requests, replies[i].requests...)
+	}
+	this.resetQueued()
+
+	executeBatches(batchRequests(this.HashRing, requests), this.DatabaseId)
+
+	for i, pending := range replies {
+		reply, err := pending.build()
+		if err != nil {
+			this.WriteError(err, false)
+		} else {
+			this.Writer.Write(this.convertReply(commands[i], reply))
+		}
+	}
+
+	return this.Writer.Flush()
+}
+
 func (this *Client) HasBufferedOutput() bool {
 	return this.Writer.Buffered() > 0
 }
@@ -213,22 +296,33 @@ func (this *Client) ReadLoop(rmux *RedisMultiplexer) {
 		this.ReadChannel <- readItem{command, err}
 	}
 
-	if err := this.Scanner.Err(); err != nil {
+	if err := this.Scanner.Err(); err != nil && !this.isKilled() {
 		this.ReadChannel <- readItem{nil, err}
 	} else {
 		this.ReadChannel <- readItem{nil, io.EOF}
 	}
+	close(this.closed)
 }
 
 func (this *Client) resetQueued() {
 	// We make a new one instead of using this.queued=this.queued[:0] so that the command arrays are eligible for GC
 	this.queued = make([]protocol.Command, 0, 4)
+	this.queuedAt = nil
 }
 
 func (this *Client) HasQueued() bool {
 	return len(this.queued) > 0
 }
 
+//Responds with anything that is queued, before a command is answered without being queued, so that replies stay in
+//the order that the commands were sent
+func (this *Client) respondToQueued() {
+	if this.HasQueued() {
+		this.FlushRedisAndRespond()
+	}
+}
+
 func (this *Client) Queue(command protocol.Command) {
 	this.queued = append(this.queued, command)
+	this.queuedAt = append(this.queuedAt, time.Now())
 }
@@ -116,11 +116,11 @@ func TestParseCommand(test *testing.T) {
 		//select in a bad format should err
 		{[]byte("*2\r\n$6\r\nselect\r\n$1\r\na\r\n"), nil, protocol.ERR_BAD_ARGUMENTS},
 		//random command on our blacklist should respond appropriately
-		{[]byte("*1\r\n$4\r\nauth\r\n"), nil, protocol.ERR_COMMAND_UNSUPPORTED},
+		{[]byte("*1\r\n$8\r\nshutdown\r\n"), nil, protocol.ERR_COMMAND_UNSUPPORTED},
 		//random command on our pubsub list should respond appropriately
 		{[]byte("*1\r\n$6\r\npubsub\r\n"), nil, protocol.ERR_COMMAND_UNSUPPORTED},
-		//multi should fail
-		{[]byte("*1\r\n$5\r\nmulti\r\n"), nil, protocol.ERR_COMMAND_UNSUPPORTED},
+		//monitor should fail
+		{[]byte("*1\r\n$7\r\nmonitor\r\n"), nil, protocol.ERR_COMMAND_UNSUPPORTED},
 	}
 
 	listenSock, err := net.Listen("unix", "/tmp/rmuxTest1.sock")
@@ -0,0 +1,302 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"github.com/salesforce/rmux/protocol"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ERR_NO_SUCH_CLIENT = protocol.NewRecoverableError("No such client")
+)
+
+//A snapshot of a client's state, taken by the client's own goroutine after each of its commands
+//CLIENT LIST is answered from these, since other clients can't safely look at each other's state
+type clientInfo struct {
+	name       string
+	libName    string
+	libVersion string
+	databaseId int
+	protocol   int
+	channels   int
+	patterns   int
+	//The number of commands queued inside MULTI, or -1 outside of it
+	multi        int
+	outputLength int
+	//The name of the last command that redis knows of, taken from the command table so that it isn't copied
+	lastCommand string
+}
+
+//Every client that is connected to an rmux server
+type clientRegistry struct {
+	lock    sync.RWMutex
+	clients map[*Client]bool
+}
+
+func newClientRegistry() *clientRegistry {
+	return &clientRegistry{clients: make(map[*Client]bool)}
+}
+
+func (this *clientRegistry) add(client *Client) {
+	this.lock.Lock()
+	this.clients[client] = true
+	this.lock.Unlock()
+	client.registry = this
+}
+
+func (this *clientRegistry) remove(client *Client) {
+	this.lock.Lock()
+	delete(this.clients, client)
+	this.lock.Unlock()
+}
+
+//Gets every registered client, ordered by id
+func (this *clientRegistry) list() []*Client {
+	this.lock.RLock()
+	clients := make([]*Client, 0, len(this.clients))
+	for client := range this.clients {
+		clients = append(clients, client)
+	}
+	this.lock.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Id < clients[j].Id })
+	return clients
+}
+
+//Records the client's state after it ran the given command, which it started running at the given time, for CLIENT LIST
+//This runs after every command, so the snapshot is only replaced when something in it has changed
+func (this *Client) recordInfo(command protocol.Command, ranAt time.Time) {
+	atomic.StoreInt64(&this.lastActive, ranAt.UnixNano())
+
+	// Only the client's own goroutine writes its snapshot, so it can read it without the lock
+	info := this.info
+	info.name = this.Name
+	info.libName = this.LibName
+	info.libVersion = this.LibVersion
+	info.databaseId = this.DatabaseId
+	info.protocol = this.Protocol
+	info.outputLength = this.Writer.Buffered()
+	if commandInfo := protocol.LookupCommand(command.GetCommand()); commandInfo != nil {
+		info.lastCommand = commandInfo.Name
+	}
+	info.channels, info.patterns = 0, 0
+	if this.subscriber != nil {
+		info.channels = len(this.subscriber.channels)
+		info.patterns = len(this.subscriber.patterns)
+	}
+	info.multi = -1
+	if this.transaction != nil && this.transaction.inMulti {
+		info.multi = len(this.transaction.commands)
+	}
+
+	if info != this.info {
+		this.infoLock.Lock()
+		this.info = info
+		this.infoLock.Unlock()
+	}
+}
+
+//Gets the client's state as of its last command
+func (this *Client) snapshot() clientInfo {
+	this.infoLock.Lock()
+	defer this.infoLock.Unlock()
+	return this.info
+}
+
+func (this clientInfo) isPubsub() bool {
+	return this.channels+this.patterns > 0
+}
+
+//Describes the client in the format of a CLIENT LIST line
+func (this *Client) describe(info clientInfo) string {
+	lastActive := this.created
+	if nanos := atomic.LoadInt64(&this.lastActive); nanos != 0 {
+		lastActive = time.Unix(0, nanos)
+	}
+	flags := "N"
+	if info.isPubsub() {
+		flags = "P"
+	} else if info.multi >= 0 {
+		flags = "x"
+	}
+
+	return "id=" + strconv.FormatUint(this.Id, 10) +
+		" addr=" + this.remoteAddr() +
+		" laddr=" + this.localAddr() +
+		" name=" + info.name +
+		" age=" + strconv.Itoa(int(time.Since(this.created).Seconds())) +
+		" idle=" + strconv.Itoa(int(time.Since(lastActive).Seconds())) +
+		" flags=" + flags +
+		" db=" + strconv.Itoa(info.databaseId) +
+		" sub=" + strconv.Itoa(info.channels) +
+		" psub=" + strconv.Itoa(info.patterns) +
+		" multi=" + strconv.Itoa(info.multi) +
+		" qbuf=" + strconv.Itoa(len(this.ReadChannel)) +
+		" obl=" + strconv.Itoa(info.outputLength) +
+		" cmd=" + info.lastCommand +
+		" user=" + string(DEFAULT_USER) +
+		" lib-name=" + info.libName +
+		" lib-ver=" + info.libVersion +
+		" resp=" + strconv.Itoa(info.protocol) + "\n"
+}
+
+func (this *Client) remoteAddr() string {
+	if addr := this.Connection.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+func (this *Client) localAddr() string {
+	if addr := this.Connection.LocalAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+//Closes another client's connection.  Its read loop then ends as if the client had hung up
+func (this *Client) kill() {
+	atomic.StoreInt32(&this.killed, 1)
+	this.Connection.Close()
+}
+
+func (this *Client) isKilled() bool {
+	return atomic.LoadInt32(&this.killed) == 1
+}
+
+//Answers CLIENT LIST, optionally filtered by TYPE or by ID
+func (this *Client) clientList(args [][]byte) ([]byte, error) {
+	var ids map[uint64]bool
+	clientType := ""
+	if len(args) > 0 {
+		switch {
+		case bytes.EqualFold(args[0], []byte("type")) && len(args) == 2:
+			clientType = string(bytes.ToLower(args[1]))
+			if clientType != "normal" && clientType != "pubsub" {
+				return nil, protocol.NewRecoverableError("Unknown client type '" + string(args[1]) + "'")
+			}
+		case bytes.EqualFold(args[0], []byte("id")) && len(args) > 1:
+			ids = make(map[uint64]bool)
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseUint(string(arg), 10, 64)
+				if err != nil || id == 0 {
+					return nil, protocol.NewRecoverableError("Invalid client ID")
+				}
+				ids[id] = true
+			}
+		default:
+			return nil, protocol.ERR_BAD_ARGUMENTS
+		}
+	}
+
+	// This client's own snapshot is taken after the command, so bring it up to date first
+	this.recordInfo(protocol.NewMultibulkCommand(CLIENT_COMMAND), time.Now())
+
+	var list bytes.Buffer
+	for _, client := range this.registry.list() {
+		if ids != nil && !ids[client.Id] {
+			continue
+		}
+		info := client.snapshot()
+		if clientType == "normal" && info.isPubsub() || clientType == "pubsub" && !info.isPubsub() {
+			continue
+		}
+		list.WriteString(client.describe(info))
+	}
+	return bytes.TrimSuffix(bulkString([]byte(list.String())), protocol.REDIS_NEWLINE), nil
+}
+
+//Answers CLIENT KILL, in both its old form (CLIENT KILL addr) and its filter form (CLIENT KILL ID id ADDR addr ...)
+//Only connections to rmux are closed. The connections to redis are shared with other clients, so they are left alone
+func (this *Client) clientKill(args [][]byte) ([]byte, error) {
+	if len(args) == 1 {
+		for _, client := range this.registry.list() {
+			if client.remoteAddr() == string(args[0]) {
+				this.killClient(client)
+				return protocol.OK_RESPONSE, nil
+			}
+		}
+		return nil, ERR_NO_SUCH_CLIENT
+	}
+
+	if len(args)%2 != 0 {
+		return nil, protocol.ERR_BAD_ARGUMENTS
+	}
+
+	var id uint64
+	var addr, laddr string
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch {
+		case bytes.EqualFold(args[i], []byte("id")):
+			var err error
+			if id, err = strconv.ParseUint(value, 10, 64); err != nil || id == 0 {
+				return nil, protocol.NewRecoverableError("client-id should be greater than 0")
+			}
+		case bytes.EqualFold(args[i], []byte("addr")):
+			addr = value
+		case bytes.EqualFold(args[i], []byte("laddr")):
+			laddr = value
+		case bytes.EqualFold(args[i], []byte("skipme")):
+			if bytes.EqualFold(args[i+1], []byte("yes")) {
+				skipMe = true
+			} else if bytes.EqualFold(args[i+1], []byte("no")) {
+				skipMe = false
+			} else {
+				return nil, protocol.NewRecoverableError("syntax error")
+			}
+		default:
+			return nil, protocol.NewRecoverableError("syntax error")
+		}
+	}
+
+	killed := 0
+	for _, client := range this.registry.list() {
+		if id != 0 && client.Id != id || addr != "" && client.remoteAddr() != addr ||
+			laddr != "" && client.localAddr() != laddr || skipMe && client == this {
+			continue
+		}
+		this.killClient(client)
+		killed++
+	}
+	return []byte(":" + strconv.Itoa(killed)), nil
+}
+
+func (this *Client) killClient(client *Client) {
+	if client == this {
+		// The reply still has to reach the client, so its connection is closed once the command loop stops
+		this.Active = false
+		return
+	}
+	client.kill()
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClientListAndKill(t *testing.T) {
+	recorder := &transactionRecorder{}
+	listener := StartMockRedisServer(t, "/tmp/rmuxClientsTest1.sock", recorder.handle)
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxClientsTest.sock", "/tmp/rmuxClientsTest1.sock")
+	defer server.Listener.Close()
+
+	client1, output1 := newTestClient(server)
+	client2, _ := newTestClient(server)
+	runClientCommand(t, server, client2, "client", "setname", "worker")
+	runClientCommand(t, server, client2, "select", "3")
+	runClientCommand(t, server, client2, "get", "a")
+
+	runClientCommand(t, server, client1, "client", "list")
+	lines := strings.Split(strings.TrimSuffix(string(bulkContents(output1.Bytes())), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected CLIENT LIST to show 2 clients, got %q", output1.String())
+	}
+	expected := []string{"id=" + strconv.FormatUint(client2.Id, 10) + " ", " name=worker ", " db=3 ", " cmd=get ", " multi=-1 ", " resp=2"}
+	for _, field := range expected {
+		if !strings.Contains(lines[1], field) {
+			t.Errorf("Expected %q in the second client's CLIENT LIST line, got %q", field, lines[1])
+		}
+	}
+	if !strings.Contains(lines[0], " cmd=client ") {
+		t.Errorf("Expected the listing client's own line to show CLIENT, got %q", lines[0])
+	}
+
+	output1.Reset()
+	runClientCommand(t, server, client1, "client", "list", "id", strconv.FormatUint(client2.Id, 10))
+	if contents := string(bulkContents(output1.Bytes())); strings.Count(contents, "\n") != 1 || !strings.HasPrefix(contents, expected[0]) {
+		t.Errorf("Expected CLIENT LIST ID to only show the second client, got %q", output1.String())
+	}
+
+	testData := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"client", "kill", "nowhere:1"}, "-ERR " + ERR_NO_SUCH_CLIENT.Error() + "\r\n"},
+		{[]string{"client", "kill", "id", "0"}, "-ERR client-id should be greater than 0\r\n"},
+		{[]string{"client", "kill", "id", strconv.FormatUint(client1.Id, 10)}, ":0\r\n"},
+		{[]string{"client", "kill", "id", strconv.FormatUint(client2.Id, 10)}, ":1\r\n"},
+	}
+	for _, data := range testData {
+		output1.Reset()
+		runClientCommand(t, server, client1, data.args...)
+		if output1.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output1.String())
+		}
+	}
+	if !client2.isKilled() {
+		t.Errorf("The second client should have been killed")
+	}
+
+	//A client can kill itself, once SKIPME is turned off
+	output1.Reset()
+	runClientCommand(t, server, client1, "client", "kill", "id", strconv.FormatUint(client1.Id, 10), "skipme", "no")
+	if output1.String() != ":1\r\n" || client1.Active {
+		t.Errorf("Expected the client to kill itself after replying, got %q", output1.String())
+	}
+
+	if recorded := recorder.recorded(); recorded != "get a" {
+		t.Errorf("CLIENT LIST and KILL should not have reached redis, got %q", recorded)
+	}
+}
+
+func TestRecordInfoDoesNotAllocate(t *testing.T) {
+	server := newTestMultiplexer(t, "/tmp/rmuxClientsTest.sock", "/tmp/rmuxClientsTest1.sock")
+	defer server.Listener.Close()
+
+	client, _ := newTestClient(server)
+	command, _ := protocol.ParseCommand([]byte(makeTestCommand("GET", "a")))
+	now := time.Now()
+	client.recordInfo(command, now)
+
+	if allocs := testing.AllocsPerRun(100, func() { client.recordInfo(command, now) }); allocs != 0 {
+		t.Errorf("Expected recording a command to not allocate, got %.1f allocations", allocs)
+	}
+	if info := client.snapshot(); info.lastCommand != "get" {
+		t.Errorf("Expected the last command to be get, got %q", info.lastCommand)
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package connection
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	//Default time to wait before reconnecting, after a pool's first failed dial.  Doubles with every failure after it
+	DEFAULT_RECONNECT_BACKOFF = time.Millisecond * 50
+	//Default longest time to wait before reconnecting
+	DEFAULT_MAX_RECONNECT_BACKOFF = time.Second * 5
+)
+
+var (
+	ERR_RECONNECT_BACKOFF = errors.New("Waiting to reconnect to redis, after failing to connect")
+	ERR_TOO_MANY_DIALS    = errors.New("Timed out waiting for other connections to redis to finish connecting")
+)
+
+// Spaces out the reconnects of a pool's connections while its redis server is down, so that they don't all dial it
+// again the moment it comes back, and limits how many of them can dial at once
+type reconnectBackoff struct {
+	//How long to wait after the first failed dial.  Doubles with every failure after it.  Never waits if 0
+	base time.Duration
+	//The longest time to wait between dials
+	max time.Duration
+	//Holds a token for every dial that is running.  Nil if any number of them can run at once
+	dials chan bool
+	lock  sync.Mutex
+	//The number of dials that have failed since the last one that succeeded
+	failures uint
+	//No dials are tried until then
+	retryAt time.Time
+}
+
+// Whether the last failed dial was too recent for another one to be tried
+func (this *reconnectBackoff) waiting() bool {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return time.Now().Before(this.retryAt)
+}
+
+// Waits for its turn to dial, for up to the given timeout (or forever if 0)
+// Fails right away while the pool is waiting to reconnect
+func (this *reconnectBackoff) startDial(timeout time.Duration) error {
+	if this == nil {
+		return nil
+	} else if this.waiting() {
+		return ERR_RECONNECT_BACKOFF
+	} else if this.dials == nil {
+		return nil
+	}
+
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case this.dials <- true:
+		case <-timer.C:
+			return ERR_TOO_MANY_DIALS
+		}
+	} else {
+		this.dials <- true
+	}
+
+	// One of the dials that ran while this one was waiting may have failed
+	if this.waiting() {
+		<-this.dials
+		return ERR_RECONNECT_BACKOFF
+	}
+	return nil
+}
+
+// Gives up a dial's turn.  A failed dial makes the pool wait twice as long as the last time, before it reconnects
+func (this *reconnectBackoff) finishDial(err error) {
+	if this == nil {
+		return
+	}
+	if this.dials != nil {
+		<-this.dials
+	}
+
+	if err == nil {
+		this.reset()
+	} else if this.base > 0 {
+		this.lock.Lock()
+		defer this.lock.Unlock()
+		this.failures++
+
+		// Doubled one failure at a time, and no further than max, so that it can't overflow
+		backoff := this.base
+		for i := uint(1); i < this.failures && backoff < this.max; i++ {
+			backoff *= 2
+		}
+		if backoff > this.max {
+			backoff = this.max
+		}
+		// Somewhere between half of the backoff and all of it, so that many rmux servers don't all come back at once
+		backoff = backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		this.retryAt = time.Now().Add(backoff)
+	}
+}
+
+// Lets the pool reconnect right away
+func (this *reconnectBackoff) reset() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.failures = 0
+	this.retryAt = time.Time{}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package connection
+
+import (
+	"bufio"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoffDoubles(test *testing.T) {
+	backoff := &reconnectBackoff{base: 10 * time.Millisecond, max: 40 * time.Millisecond}
+
+	for _, expected := range []time.Duration{10, 20, 40, 40} {
+		expected *= time.Millisecond
+		backoff.finishDial(ERR_RECONNECT_BACKOFF)
+
+		wait := backoff.retryAt.Sub(time.Now())
+		if wait < expected/2-time.Millisecond || wait > expected {
+			test.Errorf("Expected to wait between %s and %s after %d failures, waiting %s", expected/2, expected, backoff.failures, wait)
+		}
+		if err := backoff.startDial(0); err != ERR_RECONNECT_BACKOFF {
+			test.Errorf("Expected dials to be refused while waiting, got %v", err)
+		}
+	}
+
+	backoff.finishDial(nil)
+	if backoff.waiting() {
+		test.Errorf("A successful dial should stop the wait")
+	}
+}
+
+func TestReconnectBackoffDoesNotOverflow(test *testing.T) {
+	backoff := &reconnectBackoff{base: 10 * time.Second, max: time.Minute}
+
+	for i := 0; i < 200; i++ {
+		backoff.finishDial(ERR_RECONNECT_BACKOFF)
+	}
+	if wait := backoff.retryAt.Sub(time.Now()); wait < 30*time.Second-time.Millisecond || wait > time.Minute {
+		test.Errorf("Expected to wait between 30s and 1m after %d failures, waiting %s", backoff.failures, wait)
+	}
+}
+
+func TestReconnectBackoffLimitsDials(test *testing.T) {
+	backoff := &reconnectBackoff{dials: make(chan bool, 1)}
+
+	if err := backoff.startDial(0); err != nil {
+		test.Fatalf("The first dial should have been let through: %s", err)
+	}
+	if err := backoff.startDial(10 * time.Millisecond); err != ERR_TOO_MANY_DIALS {
+		test.Errorf("Expected %s while another dial was running, got %v", ERR_TOO_MANY_DIALS, err)
+	}
+
+	backoff.finishDial(nil)
+	if err := backoff.startDial(10 * time.Millisecond); err != nil {
+		test.Errorf("A dial should have been let through once the other finished: %s", err)
+	}
+}
+
+func TestPoolBacksOffUntilHealthCheckPasses(test *testing.T) {
+	testSocket := "/tmp/rmuxBackoffTest"
+	os.Remove(testSocket)
+
+	timeout := 100 * time.Millisecond
+	connectionPool := NewConnectionPool("unix", testSocket, 2, timeout, timeout, timeout)
+	connectionPool.SetReconnectBackoff(time.Minute, time.Minute, 0)
+
+	// Nothing is listening yet, so the first dial fails, and the next isn't even tried
+	if _, err := connectionPool.GetConnection(); err == nil || err == ERR_RECONNECT_BACKOFF {
+		test.Errorf("Expected the first dial to fail, got %v", err)
+	}
+	if _, err := connectionPool.GetConnection(); err != ERR_RECONNECT_BACKOFF {
+		test.Errorf("Expected %s after a failed dial, got %v", ERR_RECONNECT_BACKOFF, err)
+	}
+	if failures, backoffs := atomic.LoadInt64(&connectionPool.Stats.ReconnectFailures), atomic.LoadInt64(&connectionPool.Stats.Backoffs); failures != 1 || backoffs != 1 {
+		test.Errorf("Expected 1 failed dial and 1 backoff, got %d and %d", failures, backoffs)
+	}
+
+	listenSock := _listenSocket(test, testSocket)
+	defer listenSock.Close()
+	go func() {
+		for {
+			fd, err := listenSock.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer fd.Close()
+				reader := bufio.NewReader(fd)
+				for {
+					if _, err := reader.ReadString('\n'); err != nil {
+						return
+					}
+					fd.Write([]byte("+PONG\r\n"))
+				}
+			}()
+		}
+	}()
+
+	// The health check doesn't wait, and once redis is back, neither does the rest of the pool
+	if !connectionPool.inBackoff() || !connectionPool.CheckConnectionState() {
+		test.Fatalf("The health check should have reached redis, while the pool was waiting to reconnect")
+	}
+	if connectionPool.inBackoff() {
+		test.Errorf("The pool should stop waiting once its health check passes")
+	}
+	if connection, err := connectionPool.GetConnection(); err != nil {
+		test.Errorf("Expected to reconnect once the health check passed, got %s", err)
+	} else {
+		connectionPool.RecycleRemoteConnection(connection)
+	}
+}
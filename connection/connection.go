@@ -34,10 +34,56 @@ import (
 	"github.com/salesforce/rmux/protocol"
 	. "github.com/salesforce/rmux/writer"
 	"net"
+	"sync/atomic"
 	"time"
 	"github.com/salesforce/rmux/graphite"
 )
 
+var AUTH_COMMAND = []byte("auth")
+
+//The credentials that a connection authenticates with, every time it connects
+type Credentials struct {
+	//The ACL user to authenticate as.  Left empty to authenticate with redis's requirepass
+	Username string
+	//Left empty to not authenticate at all
+	Password string
+}
+
+//Counts the times that connections (re)connected to a redis server, and the times that they failed to
+//along with how many of them are open, and how many were closed for going unused or for being open for too long
+type ConnectStats struct {
+	Reconnects        int64
+	ReconnectFailures int64
+	Open              int64
+	Reaped            int64
+	Expired           int64
+	//The number of reconnects that were not tried, because the pool was waiting after failing to connect
+	Backoffs int64
+}
+
+func (this *ConnectStats) countReconnect(succeeded bool) {
+	if this == nil {
+		return
+	} else if succeeded {
+		atomic.AddInt64(&this.Reconnects, 1)
+		atomic.AddInt64(&this.Open, 1)
+	} else {
+		atomic.AddInt64(&this.ReconnectFailures, 1)
+	}
+}
+
+func (this *ConnectStats) countBackoff() {
+	if this != nil {
+		atomic.AddInt64(&this.Backoffs, 1)
+	}
+}
+
+func (this *ConnectStats) countDisconnect() {
+	if this != nil {
+		atomic.AddInt64(&this.Open, -1)
+	}
+}
+
 //An outbound connection to a redis server
 //Maintains its own underlying TimedNetReadWriter, and keeps track of its DatabaseId for select() changes
 type Connection struct {
@@ -48,12 +94,22 @@ type Connection struct {
 	Reader *bufio.Reader
 	// The writer to the redis server
 	Writer *FlexibleWriter
+	// The timed reader/writer that Reader and Writer wrap, so that the read timeout can be changed on the fly
+	readWriter *protocol.TimedNetReadWriter
 
 	protocol string
 	endpoint string
+	credentials *Credentials
+	stats *ConnectStats
+	backoff *reconnectBackoff
 	connectTimeout time.Duration
 	readTimeout time.Duration
 	writeTimeout time.Duration
+	// When the connection last connected, and when it was last given back to its pool
+	connectedAt time.Time
+	lastUsed time.Time
+	// Whether the connection holds one of its pool's tokens for its MaxActive open connections
+	active bool
 }
 
 //Initializes a new connection, of the given protocol and endpoint, with the given connection timeout
@@ -71,6 +127,7 @@ func NewConnection(Protocol, Endpoint string, ConnectTimeout, ReadTimeout, Write
 func (c *Connection) Disconnect() {
 	if c.connection != nil {
 		c.connection.Close()
+		c.stats.countDisconnect()
 		Info("Disconnected a connection")
 		graphite.Increment("disconnect")
 	}
@@ -78,6 +135,7 @@ func (c *Connection) Disconnect() {
 	c.DatabaseId = 0
 	c.Reader = nil
 	c.Writer = nil
+	c.readWriter = nil
 }
 
 func (c *Connection) ReconnectIfNecessary() (err error) {
@@ -88,21 +146,92 @@ func (c *Connection) ReconnectIfNecessary() (err error) {
 	// If it's not connected, manually disconnect the connection for sanity's sake
 	c.Disconnect()
 
+	if err = c.backoff.startDial(c.connectTimeout); err != nil {
+		c.stats.countBackoff()
+		graphite.Increment("reconnect_backoff")
+		return err
+	}
 	c.connection, err = net.DialTimeout(c.protocol, c.endpoint, c.connectTimeout)
+	c.backoff.finishDial(err)
 	if err != nil {
 		Error("NewConnection: Error received from dial: %s", err)
 		c.connection = nil
+		c.stats.countReconnect(false)
 		return err
 	}
+	c.stats.countReconnect(true)
+	c.connectedAt = time.Now()
 
-	netReadWriter := protocol.NewTimedNetReadWriter(c.connection, c.readTimeout, c.writeTimeout)
+	c.readWriter = protocol.NewTimedNetReadWriter(c.connection, c.readTimeout, c.writeTimeout)
 	c.DatabaseId = 0
-	c.Writer = NewFlexibleWriter(netReadWriter)
-	c.Reader = bufio.NewReader(netReadWriter)
+	c.Writer = NewFlexibleWriter(c.readWriter)
+	c.Reader = bufio.NewReader(c.readWriter)
+
+	if c.credentials != nil && c.credentials.Password != "" {
+		if err = c.authenticate(); err != nil {
+			c.Disconnect()
+			return err
+		}
+	}
 
 	return nil
 }
 
+//Sets the credentials that the connection authenticates with, the next time it connects
+func (c *Connection) SetCredentials(credentials *Credentials) {
+	c.credentials = credentials
+}
+
+//Sets the counters that the connection's (re)connects are added to
+func (c *Connection) SetStats(stats *ConnectStats) {
+	c.stats = stats
+}
+
+//Sends AUTH, as an ACL user if the credentials have a username
+func (this *Connection) authenticate() error {
+	args := [][]byte{[]byte(this.credentials.Password)}
+	if this.credentials.Username != "" {
+		args = append([][]byte{[]byte(this.credentials.Username)}, args...)
+	}
+
+	if _, err := this.Writer.Write(protocol.NewMultibulkCommand(AUTH_COMMAND, args...).GetBuffer()); err != nil {
+		Error("authenticate: Error received from writing AUTH: %s", err)
+		return err
+	}
+	if err := this.Writer.Flush(); err != nil {
+		Error("authenticate: Error received from flushing AUTH: %s", err)
+		return err
+	}
+
+	if line, isPrefix, err := this.Reader.ReadLine(); err != nil || isPrefix || !bytes.Equal(line, protocol.OK_RESPONSE) {
+		if err == nil {
+			err = fmt.Errorf("Authentication failed: %s", line)
+		}
+
+		Error("authenticate: Error while authenticating to %s: %s", this.endpoint, err)
+		return err
+	}
+
+	return nil
+}
+
+//Changes the read timeout of the connection, including the current underlying connection if there is one
+//A timeout of 0 lets reads wait forever
+func (c *Connection) SetReadTimeout(readTimeout time.Duration) {
+	c.readTimeout = readTimeout
+	if c.readWriter != nil {
+		c.readWriter.ReadTimeout = readTimeout
+	}
+}
+
+//Closes the underlying connection, so that a read that is blocked on it in another goroutine returns an error
+//The connection still needs to be disconnected by whoever is reading from it
+func (c *Connection) Interrupt() {
+	if c.connection != nil {
+		c.connection.Close()
+	}
+}
+
 //Selects the given database, for the connection
 //If an error is returned, or if an invalid response is returned from the select, then this will return an error
 //If not, the connections internal database will be updated accordingly
@@ -177,6 +306,8 @@ func (c *Connection) IsConnected() bool {
 
 	// Adds a hundredth a milli...
 	c.connection.SetReadDeadline(time.Now().Add(time.Microsecond * 10))
+	// Reads without a read timeout don't set a deadline of their own, so this one mustn't be left behind
+	defer c.connection.SetReadDeadline(time.Time{})
 	var b [4]byte
 	n, err := c.connection.Read(b[:])
 
@@ -26,6 +26,7 @@
 package connection
 
 import (
+	"errors"
 	. "github.com/salesforce/rmux/log"
 	"time"
 	"sync/atomic"
@@ -43,8 +44,30 @@ const (
 	EXTERN_WRITE_TIMEOUT = time.Millisecond * 500
 )
 
+var (
+	ERR_BLOCKING_POOL_FULL = errors.New("Too many clients are blocked on this server")
+	ERR_ACQUIRE_TIMEOUT    = errors.New("Timed out waiting for a free connection to redis")
+	ERR_TOO_MANY_WAITERS   = errors.New("Too many clients are waiting for a free connection to redis")
+)
+
+//Counts how clients got on waiting for a free connection in the pool
+type AcquireStats struct {
+	//The number of connections that were handed out after the client had to wait for one
+	Waits int64
+	//The total time spent waiting, in microseconds
+	WaitMicroseconds int64
+	//The number of clients that gave up waiting after the pool's AcquireTimeout
+	Timeouts int64
+	//The number of clients that were turned away because MaxWaiters were already waiting
+	Rejections int64
+}
+
 //A pool of connections to a single outbound redis server
 type ConnectionPool struct {
+	//How often the pool's connections have (re)connected.  Kept first, so that it is aligned for atomic access
+	Stats ConnectStats
+	//How long clients have waited for connections.  Also kept aligned for atomic access
+	AcquireStats AcquireStats
 	//The protocol to use for our connections (unix/tcp/udp)
 	Protocol string
 	//The endpoint to connect to
@@ -55,8 +78,37 @@ type ConnectionPool struct {
 	ReadTimeout time.Duration
 	//An overridable write timeout.  Defaults to EXTERN_WRITE_TIMEOUT
 	WriteTimeout time.Duration
-	//channel of recycled connections, for re-use
-	connectionPool chan *Connection
+	//The credentials that every connection in the pool authenticates with.  Should be set before the pool is used
+	Credentials Credentials
+	//How long GetConnection waits for a free connection before giving up.  Waits forever if 0
+	AcquireTimeout time.Duration
+	//How many clients can wait for a free connection at once, before others are turned away.  Unlimited if 0
+	MaxWaiters int32
+	//Number of clients waiting for a free connection
+	Waiters int32
+	//The fewest idle connections that are kept open, even once they have gone unused for IdleTimeout
+	MinIdle int
+	//How long an idle connection goes unused before it is closed.  Never closed if 0
+	IdleTimeout time.Duration
+	//How long a connection stays open before it is closed and opened again, the next time it is free.  Forever if 0
+	MaxLifetime time.Duration
+	//Holds one token for every connection that the pool has open, blocking or not, so that no more than MaxActive of
+	//them are open at once.  Nil if there is no limit
+	activeConnections chan bool
+	//Number of clients waiting for a token from activeConnections
+	activeWaiters int32
+	//Spaces out the reconnects of the pool's connections while redis is down
+	backoff reconnectBackoff
+	//Holds one token for every connection in idle, so that clients can wait for a free connection
+	freeConnections chan bool
+	//The connections that are free to be used.  The most recently used are last, and are handed out first, so that
+	//the rest of them can go unused for long enough to be closed
+	idle []*Connection
+	idleLock sync.Mutex
+	//channel of connections for blocking commands, kept apart so that blocked clients can't drain the pool
+	blockingPool chan *Connection
+	// Number of connections held by blocked clients
+	BlockingCount int32
 	// The connection used for diagnostics (like checking that the pool is up)
 	diagnosticConnection *Connection
 	diagnosticConnectionLock sync.Mutex
@@ -65,6 +117,8 @@ type ConnectionPool struct {
 	connectedLock sync.RWMutex
 	// Whether or not the connction pool is up or down
 	isConnected bool
+	// Set while the pool is taken out of the hash ring for maintenance
+	maintenance int32
 }
 
 //Initialize a new connection pool, for the given protocol/endpoint, with a given pool capacity
@@ -74,50 +128,487 @@ func NewConnectionPool(Protocol, Endpoint string, poolCapacity int, connectTimeo
 	newConnectionPool = &ConnectionPool{}
 	newConnectionPool.Protocol = Protocol
 	newConnectionPool.Endpoint = Endpoint
-	newConnectionPool.connectionPool = make(chan *Connection, poolCapacity)
+	newConnectionPool.freeConnections = make(chan bool, poolCapacity)
 	newConnectionPool.ConnectTimeout = connectTimeout
 	newConnectionPool.ReadTimeout = readTimeout
 	newConnectionPool.WriteTimeout = writeTimeout
 	newConnectionPool.Count = 0
 
-	// Fill the pool with as many handlers as it asks for
+	// Fill the pool with as many handlers as it asks for.  They only connect once they are first used
 	for i := 0; i < poolCapacity; i++ {
-		newConnectionPool.connectionPool <- newConnectionPool.CreateConnection()
+		newConnectionPool.pushIdle(newConnectionPool.CreateConnection())
 	}
 
+	newConnectionPool.SetReconnectBackoff(DEFAULT_RECONNECT_BACKOFF, DEFAULT_MAX_RECONNECT_BACKOFF, 0)
+	newConnectionPool.SetBlockingCapacity(poolCapacity)
 	newConnectionPool.diagnosticConnection = newConnectionPool.CreateConnection()
+	// The health check always dials, so that it can tell when redis comes back
+	newConnectionPool.diagnosticConnection.backoff = nil
 
 	return
 }
 
 //Gets a connection from the connection pool
+//If every connection is in use, waits for one for up to AcquireTimeout, unless MaxWaiters are already waiting
+//If the pool already has MaxActive connections open, waits for up to AcquireTimeout for one of them to close as well
 func (cp *ConnectionPool) GetConnection() (connection *Connection, err error) {
 	select {
-	case connection = <-cp.connectionPool:
-		atomic.AddInt32(&cp.Count, 1)
+	case <-cp.freeConnections:
+	default:
+		if err = cp.waitForConnection(); err != nil {
+			return nil, err
+		}
+	}
+	connection = cp.popIdle()
+	atomic.AddInt32(&cp.Count, 1)
 
-		if err := connection.ReconnectIfNecessary(); err != nil {
-			// Recycle the holder, return an error
-			cp.RecycleRemoteConnection(connection)
+	if err = cp.activate(connection); err == nil {
+		err = connection.ReconnectIfNecessary()
+	}
+	if err != nil {
+		// Recycle the holder, return an error
+		cp.RecycleRemoteConnection(connection)
+		// Clients are turned away quietly while the pool waits to reconnect
+		if err != ERR_RECONNECT_BACKOFF && err != ERR_ACQUIRE_TIMEOUT {
 			Error("Received a nil connection in pool.GetConnection: %s", err)
 			graphite.Increment("reconnect_error");
-			return nil, err
 		}
+		return nil, err
+	}
+
+	return connection, nil
+}
+
+//Waits for a connection to be recycled into the pool
+func (cp *ConnectionPool) waitForConnection() error {
+	waiters := atomic.AddInt32(&cp.Waiters, 1)
+	defer atomic.AddInt32(&cp.Waiters, -1)
+	if cp.MaxWaiters > 0 && waiters > cp.MaxWaiters {
+		atomic.AddInt64(&cp.AcquireStats.Rejections, 1)
+		graphite.Increment("pool_waiters_full")
+		return ERR_TOO_MANY_WAITERS
+	}
+
+	var timeout <-chan time.Time
+	if cp.AcquireTimeout > 0 {
+		timer := time.NewTimer(cp.AcquireTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	startWait := time.Now()
+	select {
+	case <-cp.freeConnections:
+		wait := time.Now().Sub(startWait)
+		atomic.AddInt64(&cp.AcquireStats.Waits, 1)
+		atomic.AddInt64(&cp.AcquireStats.WaitMicroseconds, int64(wait/time.Microsecond))
+		graphite.Timing("pool_acquire_wait", wait)
+		return nil
+	case <-timeout:
+		atomic.AddInt64(&cp.AcquireStats.Timeouts, 1)
+		graphite.Increment("pool_acquire_timeout")
+		return ERR_ACQUIRE_TIMEOUT
+	}
+}
+
+//Takes the most recently used connection out of idle.  The caller must have taken a token from freeConnections
+//When the pool can only have MaxActive connections open, the most recently used of those that are open is preferred
+func (cp *ConnectionPool) popIdle() *Connection {
+	cp.idleLock.Lock()
+	defer cp.idleLock.Unlock()
+	last := len(cp.idle) - 1
+	if cp.activeConnections != nil {
+		for i := last; i >= 0; i-- {
+			if cp.idle[i].connection != nil {
+				last = i
+				break
+			}
+		}
+	}
+	connection := cp.idle[last]
+	cp.idle = append(cp.idle[:last], cp.idle[last+1:]...)
+	return connection
+}
+
+//Puts a connection back into idle, as the most recently used, and hands out a token for it
+func (cp *ConnectionPool) pushIdle(connection *Connection) {
+	cp.release(connection)
+	connection.lastUsed = time.Now()
+	cp.idleLock.Lock()
+	cp.idle = append(cp.idle, connection)
+	cp.idleLock.Unlock()
+	cp.freeConnections <- true
+}
+
+//Puts a connection that was taken out of idle without being used back, as the least recently used, and hands out a
+//token for it
+func (cp *ConnectionPool) pushIdleFirst(connection *Connection) {
+	cp.idleLock.Lock()
+	cp.idle = append([]*Connection{connection}, cp.idle...)
+	cp.idleLock.Unlock()
+	cp.freeConnections <- true
+}
+
+//Whether the connection has been open for longer than MaxLifetime
+func (cp *ConnectionPool) expired(connection *Connection, now time.Time) bool {
+	return cp.MaxLifetime > 0 && connection.connection != nil && now.Sub(connection.connectedAt) > cp.MaxLifetime
+}
+
+//Whether the connection has gone unused for longer than IdleTimeout
+func (cp *ConnectionPool) unused(connection *Connection, now time.Time) bool {
+	return cp.IdleTimeout > 0 && connection.connection != nil && now.Sub(connection.lastUsed) > cp.IdleTimeout
+}
+
+//Takes one of the pool's tokens for its MaxActive open connections, for a connection that is about to connect
+//If every token is taken, closes the least recently used of the pool's free connections to make room for it, and
+//otherwise waits for one of the connections in use to be given back, and closed
+func (cp *ConnectionPool) activate(connection *Connection) error {
+	for !cp.tryActivate(connection) {
+		if !cp.closeFreeConnection() {
+			return cp.waitToActivate(connection)
+		}
+	}
+	return nil
+}
+
+//Waits for up to AcquireTimeout for a token for one of the pool's MaxActive open connections
+func (cp *ConnectionPool) waitToActivate(connection *Connection) error {
+	atomic.AddInt32(&cp.activeWaiters, 1)
+	defer atomic.AddInt32(&cp.activeWaiters, -1)
+
+	var timeout <-chan time.Time
+	if cp.AcquireTimeout > 0 {
+		timer := time.NewTimer(cp.AcquireTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case cp.activeConnections <- true:
+		connection.active = true
+		return nil
+	case <-timeout:
+		atomic.AddInt64(&cp.AcquireStats.Timeouts, 1)
+		graphite.Increment("pool_acquire_timeout")
+		return ERR_ACQUIRE_TIMEOUT
+	}
+}
+
+//Takes one of the pool's tokens for its MaxActive open connections, if the connection needs one and one is free
+//Returns false if every token is taken
+func (cp *ConnectionPool) tryActivate(connection *Connection) bool {
+	if cp.activeConnections == nil || connection.active {
+		return true
+	}
+
+	select {
+	case cp.activeConnections <- true:
+		connection.active = true
+		return true
+	default:
+		return false
+	}
+}
+
+//Gives back the connection's token for one of the pool's MaxActive open connections, once it is closed
+//While clients are waiting for a token, the connection is closed first, so that one of them can connect in its place
+func (cp *ConnectionPool) release(connection *Connection) {
+	if !connection.active {
+		return
+	}
+	if connection.connection != nil {
+		if atomic.LoadInt32(&cp.activeWaiters) == 0 {
+			return
+		}
+		connection.Disconnect()
+	}
+	connection.active = false
+	<-cp.activeConnections
+}
 
-		return connection, nil
-	// TODO: Maybe a while/timeout/graphiteping loop?
+//Closes the least recently used of the pool's free connections that are open, blocking or not, so that another
+//connection can open in its place.  Returns false if every open connection is in use
+func (cp *ConnectionPool) closeFreeConnection() bool {
+	select {
+	case <-cp.freeConnections:
+		var connection *Connection
+		cp.idleLock.Lock()
+		for i, idleConnection := range cp.idle {
+			if idleConnection.connection != nil {
+				connection = idleConnection
+				cp.idle = append(cp.idle[:i], cp.idle[i+1:]...)
+				break
+			}
+		}
+		cp.idleLock.Unlock()
+
+		if connection == nil {
+			cp.freeConnections <- true
+			break
+		}
+		connection.Disconnect()
+		cp.release(connection)
+		cp.pushIdleFirst(connection)
+		return true
+	default:
+	}
+
+	for free := len(cp.blockingPool); free > 0; free-- {
+		var connection *Connection
+		select {
+		case connection = <-cp.blockingPool:
+		default:
+			return false
+		}
+
+		open := connection.connection != nil
+		if open {
+			connection.Disconnect()
+			cp.release(connection)
+		}
+		cp.blockingPool <- connection
+		if open {
+			return true
+		}
 	}
+	return false
+}
+
+//Closes a connection that nobody is using, counting it as expired or as reaped.  It reconnects when it is next used
+func (cp *ConnectionPool) closeUnusedConnection(connection *Connection, expired bool) {
+	connection.Disconnect()
+	cp.release(connection)
+	if expired {
+		atomic.AddInt64(&cp.Stats.Expired, 1)
+		graphite.Increment("pool_connection_expired")
+	} else {
+		atomic.AddInt64(&cp.Stats.Reaped, 1)
+		graphite.Increment("pool_connection_reaped")
+	}
+}
+
+//Closes the idle connections (and the free blocking connections) that have gone unused for IdleTimeout, or have been
+//open for MaxLifetime, while keeping MinIdle of the idle connections open.  If the pool is up, then opens idle
+//connections until MinIdle of them are open
+func (cp *ConnectionPool) ReapIdleConnections() {
+	for cp.reapIdleConnection(time.Now()) {
+	}
+	cp.reapBlockingConnections(time.Now())
+
+	open := 0
+	cp.idleLock.Lock()
+	for _, connection := range cp.idle {
+		if connection.connection != nil {
+			open++
+		}
+	}
+	cp.idleLock.Unlock()
+
+	for ; open < cp.MinIdle && cp.IsConnected(); open++ {
+		if !cp.connectIdleConnection() {
+			return
+		}
+	}
+}
+
+//Closes one of the idle connections that should be, if there are any.  It is taken out of idle like any other while it
+//closes, so that clients aren't held up by idleLock, and is then put back as the least recently used
+//Returns false once there is nothing left to close
+func (cp *ConnectionPool) reapIdleConnection(now time.Time) bool {
+	select {
+	case <-cp.freeConnections:
+	default:
+		return false
+	}
+
+	var connection *Connection
+	expired := false
+	open := 0
+	cp.idleLock.Lock()
+	// The most recently used connections are last, and are the ones that are kept open
+	for i := len(cp.idle) - 1; i >= 0 && connection == nil; i-- {
+		idleConnection := cp.idle[i]
+		if idleConnection.connection == nil {
+			continue
+		}
+
+		expired = cp.expired(idleConnection, now)
+		if !expired && (open < cp.MinIdle || !cp.unused(idleConnection, now)) {
+			open++
+			continue
+		}
+		connection = idleConnection
+		cp.idle = append(cp.idle[:i], cp.idle[i+1:]...)
+	}
+	cp.idleLock.Unlock()
+
+	if connection == nil {
+		cp.freeConnections <- true
+		return false
+	}
+
+	cp.closeUnusedConnection(connection, expired)
+	cp.pushIdleFirst(connection)
+	return true
+}
+
+//Closes the free blocking connections that have gone unused for IdleTimeout, or have been open for MaxLifetime
+//Each one is taken out of the blocking pool to be looked at, and put back behind the others, so their order is kept
+func (cp *ConnectionPool) reapBlockingConnections(now time.Time) {
+	for free := len(cp.blockingPool); free > 0; free-- {
+		var connection *Connection
+		select {
+		case connection = <-cp.blockingPool:
+		default:
+			return
+		}
+
+		if cp.expired(connection, now) {
+			cp.closeUnusedConnection(connection, true)
+		} else if cp.unused(connection, now) {
+			cp.closeUnusedConnection(connection, false)
+		}
+		cp.blockingPool <- connection
+	}
+}
+
+//Connects the least recently used of the idle connections that aren't connected
+//Returns false if there are none, or if it failed to connect
+func (cp *ConnectionPool) connectIdleConnection() bool {
+	// The connection is taken out of idle like any other, so that no client can be handed it while it connects
+	select {
+	case <-cp.freeConnections:
+	default:
+		return false
+	}
+
+	var connection *Connection
+	cp.idleLock.Lock()
+	for i, idleConnection := range cp.idle {
+		if idleConnection.connection == nil {
+			connection = idleConnection
+			cp.idle = append(cp.idle[:i], cp.idle[i+1:]...)
+			break
+		}
+	}
+	cp.idleLock.Unlock()
+
+	if connection == nil {
+		cp.freeConnections <- true
+		return false
+	}
+
+	// Idle connections are only opened ahead of time while the pool has fewer than MaxActive open
+	if !cp.tryActivate(connection) {
+		cp.pushIdleFirst(connection)
+		return false
+	}
+	err := connection.ReconnectIfNecessary()
+	cp.pushIdle(connection)
+	return err == nil
+}
+
+//Sets how long the pool waits to reconnect after its first failed dial, which doubles with every failure after it up to
+//maxBackoff, and how many of its connections can dial at once (any number if 0).  Must be called before the pool is used
+func (cp *ConnectionPool) SetReconnectBackoff(backoff, maxBackoff time.Duration, maxDials int) {
+	cp.backoff.base = backoff
+	cp.backoff.max = maxBackoff
+	cp.backoff.dials = nil
+	if maxDials > 0 {
+		cp.backoff.dials = make(chan bool, maxDials)
+	}
+}
+
+//Sets the most connections that the pool has open at once, blocking or not (any number if 0)
+//Must be called before the pool is used
+func (cp *ConnectionPool) SetMaxActive(maxActive int) {
+	cp.activeConnections = nil
+	if maxActive > 0 {
+		cp.activeConnections = make(chan bool, maxActive)
+	}
+}
+
+//Whether the pool is waiting to reconnect, after failing to connect
+func (cp *ConnectionPool) inBackoff() bool {
+	return cp.backoff.waiting()
+}
+
+//Sets how many clients can be blocked on the pool at once. Defaults to the pool's capacity
+//Must be called before the pool is used
+func (cp *ConnectionPool) SetBlockingCapacity(capacity int) {
+	cp.blockingPool = make(chan *Connection, capacity)
+	for i := 0; i < capacity; i++ {
+		cp.blockingPool <- cp.CreateConnection()
+	}
+}
+
+//Gets a connection for a blocking command, whose reads wait for up to the given timeout, plus the pool's own read
+//timeout.  A timeout of 0 waits forever.  Fails right away if too many clients are already blocked on the pool
+//If the pool already has MaxActive connections open, waits for up to AcquireTimeout for one of them to close
+func (cp *ConnectionPool) GetBlockingConnection(timeout time.Duration) (connection *Connection, err error) {
+	select {
+	case connection = <-cp.blockingPool:
+	default:
+		graphite.Increment("blocking_pool_full")
+		return nil, ERR_BLOCKING_POOL_FULL
+	}
+	atomic.AddInt32(&cp.BlockingCount, 1)
+
+	if err = cp.activate(connection); err == nil {
+		err = connection.ReconnectIfNecessary()
+	}
+	if err != nil {
+		cp.RecycleBlockingConnection(connection)
+		if err != ERR_RECONNECT_BACKOFF && err != ERR_ACQUIRE_TIMEOUT {
+			Error("Received a nil connection in pool.GetBlockingConnection: %s", err)
+			graphite.Increment("reconnect_error")
+		}
+		return nil, err
+	}
+
+	if timeout > 0 {
+		timeout += cp.ReadTimeout
+	}
+	connection.SetReadTimeout(timeout)
+	return connection, nil
+}
+
+//The number of connections that are waiting in the pool to be used, whether they are connected or not
+func (cp *ConnectionPool) IdleCount() int {
+	return len(cp.freeConnections)
+}
+
+//The most connections that the pool can have open at once, in use or not
+func (cp *ConnectionPool) Capacity() int {
+	return cap(cp.freeConnections)
+}
+
+//Recycles a connection back into the pool's blocking connections
+//Connections that have been open for longer than MaxLifetime are closed, and reconnect when they are next used
+func (cp *ConnectionPool) RecycleBlockingConnection(remoteConnection *Connection) {
+	now := time.Now()
+	if cp.expired(remoteConnection, now) {
+		cp.closeUnusedConnection(remoteConnection, true)
+	}
+	cp.release(remoteConnection)
+	remoteConnection.lastUsed = now
+	cp.blockingPool <- remoteConnection
+	atomic.AddInt32(&cp.BlockingCount, -1)
 }
 
 // Creates a new Connection basead on the pool's configuration
 func (cp *ConnectionPool) CreateConnection() *Connection {
-	return NewConnection(
+	connection := NewConnection(
 		cp.Protocol,
 		cp.Endpoint,
 		cp.ConnectTimeout,
 		cp.ReadTimeout,
 		cp.WriteTimeout,
 	)
+	connection.SetCredentials(&cp.Credentials)
+	connection.SetStats(&cp.Stats)
+	connection.backoff = &cp.backoff
+	return connection
 }
 
 func (cp *ConnectionPool) getDiagnosticConnection() (connection *Connection, err error) {
@@ -137,9 +628,12 @@ func (cp *ConnectionPool) releaseDiagnosticConnection() {
 }
 
 //Recycles a connection back into our connection pool
-//If the pool is full, throws it away
+//Connections that have been open for longer than MaxLifetime are closed, and reconnect when they are next used
 func (myConnectionPool *ConnectionPool) RecycleRemoteConnection(remoteConnection *Connection) {
-	myConnectionPool.connectionPool <- remoteConnection
+	if myConnectionPool.expired(remoteConnection, time.Now()) {
+		myConnectionPool.closeUnusedConnection(remoteConnection, true)
+	}
+	myConnectionPool.pushIdle(remoteConnection)
 	atomic.AddInt32(&myConnectionPool.Count, -1)
 }
 
@@ -149,18 +643,58 @@ func (cp *ConnectionPool) SetIsConnected(isConnected bool) {
 	cp.isConnected = isConnected
 }
 
+//Whether or not new commands can be sent to the pool.  Pools in maintenance are never connected, even when they are up
 func (cp *ConnectionPool) IsConnected() bool {
+	return !cp.InMaintenance() && cp.IsReachable()
+}
+
+//Whether or not the pool's redis server was up at the last health check, even if the pool is in maintenance
+//Commands that have to reach every redis server, such as KEYS and SCAN, can still be sent to a pool in maintenance
+func (cp *ConnectionPool) IsReachable() bool {
 	cp.connectedLock.RLock()
 	defer cp.connectedLock.RUnlock()
 	return cp.isConnected
 }
 
+//Takes the pool out of the hash ring (or puts it back), without touching its connections
+//Commands that are already running on the pool's connections are left to finish
+func (cp *ConnectionPool) SetMaintenance(maintenance bool) {
+	if maintenance {
+		atomic.StoreInt32(&cp.maintenance, 1)
+	} else {
+		atomic.StoreInt32(&cp.maintenance, 0)
+	}
+}
+
+func (cp *ConnectionPool) InMaintenance() bool {
+	return atomic.LoadInt32(&cp.maintenance) == 1
+}
+
+//Describes the state of the pool: up or down, or, once it has been put in maintenance, draining until its last
+//connection is given back, and then in maintenance
+func (cp *ConnectionPool) Status() string {
+	if cp.InMaintenance() {
+		if atomic.LoadInt32(&cp.Count) > 0 || atomic.LoadInt32(&cp.BlockingCount) > 0 {
+			return "draining"
+		}
+		return "maintenance"
+	}
+
+	if cp.IsReachable() {
+		return "up"
+	}
+	return "down"
+}
+
 //Checks the state of connections in this connection pool
 //If a remote server has severe lag, mysteriously goes away, or stops responding all-together, returns false
 func (cp *ConnectionPool) CheckConnectionState() (isUp bool) {
 	isUp = true
 	defer func() {
 		cp.SetIsConnected(isUp)
+		if isUp {
+			cp.backoff.reset()
+		}
 	}()
 
 	connection, err := cp.getDiagnosticConnection()
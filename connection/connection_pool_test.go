@@ -32,6 +32,7 @@ import (
 	"regexp"
 	"os"
 	"sync"
+	"sync/atomic"
 	"github.com/salesforce/rmux/protocol"
 	"bytes"
 )
@@ -80,6 +81,127 @@ func TestRecycleConnection(test *testing.T) {
 	}
 }
 
+func TestGetConnectionWaitsForAFreeConnection(test *testing.T) {
+	listenSock := _listenSocket(test, "/tmp/rmuxConnectionTest")
+	defer listenSock.Close()
+
+	timeout := 500 * time.Millisecond
+	connectionPool := NewConnectionPool("unix", "/tmp/rmuxConnectionTest", 1, timeout, timeout, timeout)
+	connectionPool.AcquireTimeout = 50 * time.Millisecond
+	connectionPool.MaxWaiters = 1
+
+	connection, err := connectionPool.GetConnection()
+	if err != nil {
+		test.Fatalf("Failed to get the only connection: %s", err)
+	}
+
+	// The pool is empty, so this one waits, and gives up when nothing is given back
+	timedOut := make(chan error)
+	go func() {
+		_, err := connectionPool.GetConnection()
+		timedOut <- err
+	}()
+	for atomic.LoadInt32(&connectionPool.Waiters) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// And with one client already waiting, the next is turned away
+	if _, err := connectionPool.GetConnection(); err != ERR_TOO_MANY_WAITERS {
+		test.Errorf("Expected %s once MaxWaiters were waiting, got %v", ERR_TOO_MANY_WAITERS, err)
+	}
+	if err := <-timedOut; err != ERR_ACQUIRE_TIMEOUT {
+		test.Errorf("Expected %s after waiting for AcquireTimeout, got %v", ERR_ACQUIRE_TIMEOUT, err)
+	}
+
+	// A waiting client gets the connection as soon as it's given back
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		connectionPool.RecycleRemoteConnection(connection)
+	}()
+	if connection, err = connectionPool.GetConnection(); err != nil {
+		test.Errorf("Should have been given the recycled connection: %s", err)
+	} else {
+		connectionPool.RecycleRemoteConnection(connection)
+	}
+
+	stats := AcquireStats{
+		Waits:      atomic.LoadInt64(&connectionPool.AcquireStats.Waits),
+		Timeouts:   atomic.LoadInt64(&connectionPool.AcquireStats.Timeouts),
+		Rejections: atomic.LoadInt64(&connectionPool.AcquireStats.Rejections),
+	}
+	if stats != (AcquireStats{Waits: 1, Timeouts: 1, Rejections: 1}) {
+		test.Errorf("Unexpected acquire stats: %+v", stats)
+	}
+	if atomic.LoadInt64(&connectionPool.AcquireStats.WaitMicroseconds) <= 0 {
+		test.Errorf("The time spent waiting should have been recorded")
+	}
+	if waiters := atomic.LoadInt32(&connectionPool.Waiters); waiters != 0 {
+		test.Errorf("Expected no clients to still be waiting, got %d", waiters)
+	}
+}
+
+func TestReapIdleConnections(test *testing.T) {
+	listenSock := _listenSocket(test, "/tmp/rmuxConnectionTest")
+	defer listenSock.Close()
+
+	timeout := 500 * time.Millisecond
+	connectionPool := NewConnectionPool("unix", "/tmp/rmuxConnectionTest", 3, timeout, timeout, timeout)
+	connectionPool.MinIdle = 1
+	connectionPool.IdleTimeout = 20 * time.Millisecond
+
+	expectStats := func(description string, expected ConnectStats) {
+		stats := ConnectStats{
+			Open:    atomic.LoadInt64(&connectionPool.Stats.Open),
+			Reaped:  atomic.LoadInt64(&connectionPool.Stats.Reaped),
+			Expired: atomic.LoadInt64(&connectionPool.Stats.Expired),
+		}
+		if stats != expected {
+			test.Errorf("Expected %+v %s, got %+v", expected, description, stats)
+		}
+	}
+
+	connections := make([]*Connection, 3)
+	for i := range connections {
+		connection, err := connectionPool.GetConnection()
+		if err != nil {
+			test.Fatalf("Failed to get a connection: %s", err)
+		}
+		connections[i] = connection
+	}
+	for _, connection := range connections {
+		connectionPool.RecycleRemoteConnection(connection)
+	}
+	expectStats("once every connection has been used", ConnectStats{Open: 3})
+
+	connectionPool.ReapIdleConnections()
+	expectStats("before the connections have been idle for long", ConnectStats{Open: 3})
+
+	time.Sleep(30 * time.Millisecond)
+	connectionPool.ReapIdleConnections()
+	expectStats("after the idle timeout, with one kept open", ConnectStats{Open: 1, Reaped: 2})
+
+	// The one that was kept open is the one that was used last, and it's handed out first
+	connection, _ := connectionPool.GetConnection()
+	if connection != connections[2] || connection.connection == nil {
+		test.Errorf("Expected the most recently used connection to be handed out, still connected")
+	}
+	connectionPool.RecycleRemoteConnection(connection)
+
+	connectionPool.MaxLifetime = 10 * time.Millisecond
+	time.Sleep(20 * time.Millisecond)
+	connectionPool.ReapIdleConnections()
+	expectStats("once the connection has been open for too long", ConnectStats{Open: 0, Reaped: 2, Expired: 1})
+
+	// Pools that are up open their idle connections ahead of time
+	connectionPool.MaxLifetime = 0
+	connectionPool.SetIsConnected(true)
+	connectionPool.ReapIdleConnections()
+	expectStats("once the pool's idle connections are opened", ConnectStats{Open: 1, Reaped: 2, Expired: 1})
+	if connectionPool.IdleCount() != 3 {
+		test.Errorf("Expected every connection to be idle, got %d", connectionPool.IdleCount())
+	}
+}
+
 func _listenSocket(t *testing.T, socketPath string) net.Listener {
 	listener, err := net.Listen("unix", socketPath)
 
@@ -101,6 +223,114 @@ func _listenSocket(t *testing.T, socketPath string) net.Listener {
 	return listener
 }
 
+func TestReapBlockingConnections(test *testing.T) {
+	listenSock := _listenSocket(test, "/tmp/rmuxConnectionTest")
+	defer listenSock.Close()
+
+	timeout := 500 * time.Millisecond
+	connectionPool := NewConnectionPool("unix", "/tmp/rmuxConnectionTest", 1, timeout, timeout, timeout)
+	connectionPool.SetBlockingCapacity(2)
+	connectionPool.MaxLifetime = 10 * time.Millisecond
+
+	// Connections that have been open for too long are closed as they are given back
+	first, err := connectionPool.GetBlockingConnection(0)
+	if err != nil {
+		test.Fatalf("Failed to get a blocking connection: %s", err)
+	}
+	second, _ := connectionPool.GetBlockingConnection(0)
+	time.Sleep(20 * time.Millisecond)
+	connectionPool.RecycleBlockingConnection(first)
+	if first.connection != nil || atomic.LoadInt64(&connectionPool.Stats.Expired) != 1 {
+		test.Errorf("Expected the blocking connection to be closed once it had been open for too long")
+	}
+
+	// And so are the ones that go unused for too long
+	connectionPool.MaxLifetime = 0
+	connectionPool.IdleTimeout = 20 * time.Millisecond
+	connectionPool.RecycleBlockingConnection(second)
+	connectionPool.ReapIdleConnections()
+	if second.connection == nil {
+		test.Errorf("The blocking connection shouldn't be closed before it has gone unused for long")
+	}
+	time.Sleep(30 * time.Millisecond)
+	connectionPool.ReapIdleConnections()
+	if second.connection != nil || atomic.LoadInt64(&connectionPool.Stats.Reaped) != 1 {
+		test.Errorf("Expected the blocking connection to be closed once it had gone unused for too long")
+	}
+	if atomic.LoadInt64(&connectionPool.Stats.Open) != 0 || len(connectionPool.blockingPool) != 2 {
+		test.Errorf("Expected both blocking connections to be closed, and back in the pool")
+	}
+}
+
+func TestMaxActiveConnections(test *testing.T) {
+	listenSock := _listenSocket(test, "/tmp/rmuxConnectionTest")
+	defer listenSock.Close()
+
+	timeout := 500 * time.Millisecond
+	connectionPool := NewConnectionPool("unix", "/tmp/rmuxConnectionTest", 2, timeout, timeout, timeout)
+	connectionPool.SetBlockingCapacity(1)
+	connectionPool.SetMaxActive(2)
+	connectionPool.AcquireTimeout = 20 * time.Millisecond
+
+	expectOpen := func(description string, expected int64) {
+		if open := atomic.LoadInt64(&connectionPool.Stats.Open); open != expected {
+			test.Errorf("Expected %d open connections %s, got %d", expected, description, open)
+		}
+	}
+
+	first, err := connectionPool.GetConnection()
+	if err != nil {
+		test.Fatalf("Failed to get a connection: %s", err)
+	}
+	second, err := connectionPool.GetConnection()
+	if err != nil {
+		test.Fatalf("Failed to get a connection: %s", err)
+	}
+	expectOpen("once both connections are in use", 2)
+
+	// Every open connection is in use, so a blocking client can't open another
+	if _, err = connectionPool.GetBlockingConnection(0); err != ERR_ACQUIRE_TIMEOUT {
+		test.Errorf("Expected the blocking connection to time out, got %v", err)
+	}
+
+	// Once one is given back, it's closed to make room for the blocking connection
+	connectionPool.RecycleRemoteConnection(first)
+	blocking, err := connectionPool.GetBlockingConnection(0)
+	if err != nil {
+		test.Fatalf("Failed to get a blocking connection: %s", err)
+	}
+	if first.connection != nil {
+		test.Errorf("Expected the free connection to be closed to make room for the blocking connection")
+	}
+	expectOpen("once the blocking connection took the free connection's place", 2)
+
+	// A client that is waiting to open a connection has the next one that is given back closed for it
+	connectionPool.AcquireTimeout = timeout
+	got := make(chan error)
+	go func() {
+		connection, err := connectionPool.GetConnection()
+		if err == nil {
+			connectionPool.RecycleRemoteConnection(connection)
+		}
+		got <- err
+	}()
+	for atomic.LoadInt32(&connectionPool.activeWaiters) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	connectionPool.RecycleBlockingConnection(blocking)
+	if err := <-got; err != nil {
+		test.Errorf("Expected the waiting client to be given a connection, got %s", err)
+	}
+	if blocking.connection != nil {
+		test.Errorf("Expected the blocking connection to be closed for the waiting client")
+	}
+	connectionPool.RecycleRemoteConnection(second)
+	expectOpen("once every connection is given back", 2)
+	if len(connectionPool.activeConnections) != 2 {
+		test.Errorf("Expected every open connection to hold a token, got %d", len(connectionPool.activeConnections))
+	}
+}
+
 func TestCheckConnectionState(test *testing.T) {
 	var wg sync.WaitGroup
 
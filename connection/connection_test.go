@@ -222,3 +222,53 @@ func TestCheckConnection(test *testing.T) {
 		test.Fatal("Timing-out connection's check connection succeeded")
 	}
 }
+
+func TestReconnectAuthenticates(test *testing.T) {
+	testSocket := "/tmp/rmuxConnectionTest"
+	listenSock, err := net.Listen("unix", testSocket)
+	if err != nil {
+		test.Fatal("Failed to listen on test socket ", testSocket)
+	}
+	defer listenSock.Close()
+
+	//Accepts the password "secret" for the user "rmux", and records every AUTH it is sent
+	received := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listenSock.Accept()
+			if err != nil {
+				return
+			}
+
+			buffer := make([]byte, 1024)
+			n, _ := conn.Read(buffer)
+			received <- string(buffer[:n])
+			if string(buffer[:n]) == "*3\r\n$4\r\nauth\r\n$4\r\nrmux\r\n$6\r\nsecret\r\n" {
+				conn.Write([]byte("+OK\r\n"))
+			} else {
+				conn.Write([]byte("-WRONGPASS invalid username-password pair or user is disabled.\r\n"))
+			}
+		}
+	}()
+
+	connection := NewConnection("unix", testSocket, 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond)
+	connection.SetCredentials(&Credentials{Username: "rmux", Password: "secret"})
+	if err := connection.ReconnectIfNecessary(); err != nil {
+		test.Fatalf("Should have authenticated, got %s", err)
+	}
+	if auth := <-received; auth != "*3\r\n$4\r\nauth\r\n$4\r\nrmux\r\n$6\r\nsecret\r\n" {
+		test.Errorf("Unexpected AUTH command %q", auth)
+	}
+
+	connection = NewConnection("unix", testSocket, 100*time.Millisecond, 100*time.Millisecond, 100*time.Millisecond)
+	connection.SetCredentials(&Credentials{Password: "wrong"})
+	if err := connection.ReconnectIfNecessary(); err == nil {
+		test.Errorf("Should have failed to authenticate with the wrong password")
+	}
+	if auth := <-received; auth != "*2\r\n$4\r\nauth\r\n$5\r\nwrong\r\n" {
+		test.Errorf("Unexpected AUTH command %q", auth)
+	}
+	if connection.connection != nil {
+		test.Errorf("A connection that failed to authenticate should have been disconnected")
+	}
+}
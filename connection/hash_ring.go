@@ -26,6 +26,7 @@
 package connection
 
 import (
+	"bytes"
 	"errors"
 //	. "github.com/salesforce/rmux/log"
 	"github.com/salesforce/rmux/protocol"
@@ -44,6 +45,8 @@ type HashRing struct {
 	DefaultConnectionPool *ConnectionPool
 	// Whether to failover to next pool when the desired one is down
 	Failover bool
+	// Whether only the {...} hash tag of a key is hashed, so that related keys can be placed on the same pool
+	HashTags bool
 }
 
 func NewHashRing(connectionPools []*ConnectionPool, failover bool) (newHashRing *HashRing, err error) {
@@ -105,23 +108,56 @@ func (myHashRing *HashRing) setBitMask(prime int) {
 	myHashRing.BitMask = myHashRing.BitMask - 1
 }
 
-//Gets the connectionKey, for a to-be-multiplexed command
+//Gets one connection pool per redis server in the ring, in the order they first appear
+//Pools that point at the same endpoint are only returned once, so that commands broadcast to them aren't run twice
+func (myHashRing *HashRing) GetConnectionPools() []*ConnectionPool {
+	seen := make(map[string]bool)
+	connectionPools := make([]*ConnectionPool, 0, 4)
+	for _, connectionPool := range myHashRing.ConnectionPools {
+		endpoint := connectionPool.Protocol + "://" + connectionPool.Endpoint
+		if !seen[endpoint] {
+			seen[endpoint] = true
+			connectionPools = append(connectionPools, connectionPool)
+		}
+	}
+	return connectionPools
+}
+
+//Gets the connectionKey, for a to-be-multiplexed command, from the command's first key
 //Uses the bernstein hash, which is one of the fastest key-distribution algorithms out there
 func (myHashRing *HashRing) GetConnectionPool(command protocol.Command) (connectionPool *ConnectionPool, err error) {
+	return myHashRing.GetConnectionPoolForKey(protocol.GetFirstKey(command))
+}
+
+//Gets the connection pool that the given key hashes to, failing over to the next pool that is up if allowed
+func (myHashRing *HashRing) GetConnectionPoolForKey(key []byte) (connectionPool *ConnectionPool, err error) {
+	return myHashRing.GetConnectionPoolForSlot(myHashRing.GetSlot(key))
+}
+
+//Gets the slot of the ring that the given key hashes to
+func (myHashRing *HashRing) GetSlot(key []byte) uint32 {
+	if myHashRing.HashTags {
+		key = GetHashTag(key)
+	}
+
 	var hash uint32 = 0
-	if command.GetArgCount() > 0 {
-		//The bernstein hash is one of the faster key-distribution algorithms out there, for small character keys
-		//An alternate (but slower) algorithm would be to use go's built-in hash/fnv, if this proves insufficient
-		for _, char := range command.GetFirstArg() {
-			hash = hash<<5 + hash + uint32(char)
-		}
+	//The bernstein hash is one of the faster key-distribution algorithms out there, for small character keys
+	//An alternate (but slower) algorithm would be to use go's built-in hash/fnv, if this proves insufficient
+	for _, char := range key {
+		hash = hash<<5 + hash + uint32(char)
 	}
 
-	hash = myHashRing.BitMask & hash
+	return myHashRing.BitMask & hash
+}
+
+//Gets the connection pool that owns the given slot, failing over to the next pool that is up if allowed
+//The keys of a pool in maintenance are always failed over, since the pool was taken out of the ring on purpose
+func (myHashRing *HashRing) GetConnectionPoolForSlot(hash uint32) (connectionPool *ConnectionPool, err error) {
 	targetHash := hash
 	connectionPool = myHashRing.ConnectionPools[hash]
+	failover := myHashRing.Failover || connectionPool.InMaintenance()
 
-	for myHashRing.Failover && !connectionPool.IsConnected() {
+	for failover && !connectionPool.IsConnected() {
 		if hash == myHashRing.BitMask {
 			hash = 0
 		} else {
@@ -142,3 +178,37 @@ func (myHashRing *HashRing) GetConnectionPool(command protocol.Command) (connect
 		return connectionPool, nil
 	}
 }
+
+//Gets the pool that the given slot's keys would fail over to if the slot's own pool went down
+//Returns nil if failover is turned off (and the slot's pool isn't in maintenance), or if no other pool is up
+func (myHashRing *HashRing) GetFailoverPool(hash uint32) *ConnectionPool {
+	owner := myHashRing.ConnectionPools[hash]
+	if !myHashRing.Failover && !owner.InMaintenance() {
+		return nil
+	}
+
+	for i := uint32(1); i <= myHashRing.BitMask; i++ {
+		connectionPool := myHashRing.ConnectionPools[(hash+i)&myHashRing.BitMask]
+		if connectionPool != owner && connectionPool.IsConnected() {
+			return connectionPool
+		}
+	}
+	return nil
+}
+
+//Gets the part of a key that is hashed when hash tags are enabled, following the redis cluster rules:
+//if the key holds a { followed by a } with at least one character between them, only those characters are hashed.
+//ex: user:{42}:profile and user:{42}:session both hash "42"
+func GetHashTag(key []byte) []byte {
+	start := bytes.IndexByte(key, '{')
+	if start < 0 {
+		return key
+	}
+
+	end := bytes.IndexByte(key[start+1:], '}')
+	if end <= 0 {
+		return key
+	}
+
+	return key[start+1 : start+1+end]
+}
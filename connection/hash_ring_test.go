@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package connection
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGetHashTag(test *testing.T) {
+	testData := []struct {
+		key string
+		tag string
+	}{
+		{"user:{42}:profile", "42"},
+		{"user:{42}:session", "42"},
+		{"{user}", "user"},
+		{"no tag", "no tag"},
+		{"empty{}tag", "empty{}tag"},
+		{"unclosed{tag", "unclosed{tag"},
+		{"first{a}{b}", "a"},
+		{"{}{b}", "{}{b}"},
+		{"nested{{a}}", "{a"},
+		{"", ""},
+	}
+
+	for _, data := range testData {
+		if tag := string(GetHashTag([]byte(data.key))); tag != data.tag {
+			test.Errorf("Expected the hash tag of %q to be %q, got %q", data.key, data.tag, tag)
+		}
+	}
+}
+
+func TestHashTagsColocateKeys(test *testing.T) {
+	pools := make([]*ConnectionPool, 5)
+	for i := range pools {
+		pools[i] = NewConnectionPool("unix", fmt.Sprintf("/tmp/rmuxHashRingTest%d.sock", i), 0, time.Millisecond, time.Millisecond, time.Millisecond)
+		pools[i].SetIsConnected(true)
+	}
+
+	hashRing, err := NewHashRing(pools, false)
+	if err != nil {
+		test.Fatalf("Error creating hash ring: %s", err)
+	}
+
+	spread := false
+	for i := 0; i < 100; i++ {
+		profile, _ := hashRing.GetConnectionPoolForKey([]byte(fmt.Sprintf("user:{%d}:profile", i)))
+		session, _ := hashRing.GetConnectionPoolForKey([]byte(fmt.Sprintf("user:{%d}:session", i)))
+		if profile != session {
+			spread = true
+		}
+	}
+	if !spread {
+		test.Errorf("Related keys should not all land on the same pool with hash tags disabled")
+	}
+
+	hashRing.HashTags = true
+	for i := 0; i < 100; i++ {
+		profile, _ := hashRing.GetConnectionPoolForKey([]byte(fmt.Sprintf("user:{%d}:profile", i)))
+		session, _ := hashRing.GetConnectionPoolForKey([]byte(fmt.Sprintf("user:{%d}:session", i)))
+		tag, _ := hashRing.GetConnectionPoolForKey([]byte(fmt.Sprintf("%d", i)))
+		if profile != session || profile != tag {
+			test.Errorf("Keys tagged with {%d} should have landed on the same pool", i)
+		}
+	}
+}
+
+func TestGetConnectionPools(test *testing.T) {
+	pools := make([]*ConnectionPool, 3)
+	for i := range pools {
+		pools[i] = NewConnectionPool("unix", fmt.Sprintf("/tmp/rmuxHashRingTest%d.sock", i), 0, time.Millisecond, time.Millisecond, time.Millisecond)
+	}
+	//The same server, configured twice
+	duplicate := NewConnectionPool("unix", "/tmp/rmuxHashRingTest0.sock", 0, time.Millisecond, time.Millisecond, time.Millisecond)
+
+	hashRing, err := NewHashRing(append(pools, duplicate), false)
+	if err != nil {
+		test.Fatalf("Error creating hash ring: %s", err)
+	}
+
+	ringPools := hashRing.GetConnectionPools()
+	if len(ringPools) != len(pools) {
+		test.Fatalf("Expected %d unique pools, got %d", len(pools), len(ringPools))
+	}
+	for _, pool := range pools {
+		found := false
+		for _, ringPool := range ringPools {
+			found = found || ringPool.Endpoint == pool.Endpoint
+		}
+		if !found {
+			test.Errorf("Pool %s is missing from the ring's pools", pool.Endpoint)
+		}
+	}
+}
+
+func TestMaintenanceFailsOver(test *testing.T) {
+	pools := make([]*ConnectionPool, 3)
+	for i := range pools {
+		pools[i] = NewConnectionPool("unix", fmt.Sprintf("/tmp/rmuxHashRingTest%d.sock", i), 0, time.Millisecond, time.Millisecond, time.Millisecond)
+		pools[i].SetIsConnected(true)
+	}
+
+	//Failover is off, but the keys of a pool in maintenance still move
+	hashRing, err := NewHashRing(pools, false)
+	if err != nil {
+		test.Fatalf("Error creating hash ring: %s", err)
+	}
+
+	pools[0].SetMaintenance(true)
+	if pools[0].IsConnected() || pools[0].Status() != "maintenance" {
+		test.Errorf("A pool in maintenance should not be connected, got status %s", pools[0].Status())
+	}
+	pools[0].Count = 1
+	if pools[0].Status() != "draining" {
+		test.Errorf("A pool in maintenance with connections in use should be draining, got %s", pools[0].Status())
+	}
+
+	moved := false
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		slot := hashRing.GetSlot(key)
+		pool, err := hashRing.GetConnectionPoolForKey(key)
+		if err != nil || pool == pools[0] {
+			test.Fatalf("Key %s should have failed over from the pool in maintenance, got %v", key, err)
+		}
+		if hashRing.ConnectionPools[slot] == pools[0] {
+			moved = true
+			if failover := hashRing.GetFailoverPool(slot); failover != pool {
+				test.Errorf("Expected key %s to fail over to %v, got %v", key, pool, failover)
+			}
+		}
+	}
+	if !moved {
+		test.Errorf("None of the keys belonged to the pool in maintenance")
+	}
+
+	pools[0].SetMaintenance(false)
+	if !pools[0].IsConnected() || pools[0].Status() != "up" {
+		test.Errorf("The pool should be back up once it is out of maintenance, got status %s", pools[0].Status())
+	}
+}
@@ -0,0 +1,412 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"github.com/salesforce/rmux/connection"
+	"github.com/salesforce/rmux/protocol"
+	"strconv"
+)
+
+//A reply that a client is waiting on, built out of the replies to one or more pool requests
+type pendingReply struct {
+	requests []*poolRequest
+	//Builds the client's reply once every request has finished.  If nil, the single request's reply is passed through
+	merge func(requests []*poolRequest) ([]byte, error)
+	//Set when the command failed before anything was sent to redis
+	err error
+}
+
+//Commands that are split up across connection pools when multiplexing, and the functions that split them
+var fanoutCommands = map[string]func(client *Client, command protocol.Command) *pendingReply{
+	"mget":   splitMget,
+	"mset":   splitMset,
+	"msetnx": checkMsetnx,
+	"del":    splitAndSum,
+	"exists": splitAndSum,
+	"unlink": splitAndSum,
+	"touch":  splitAndSum,
+	"script": broadcastScript,
+	"scan":   splitScan,
+
+	"keys":     broadcastKeys,
+	"dbsize":   broadcastDbsize,
+	"flushdb":  broadcastFlush,
+	"flushall": broadcastFlush,
+}
+
+//Returns the reply the client gets back, once the reply's requests have been executed
+func (this *pendingReply) build() ([]byte, error) {
+	if this.err != nil {
+		return nil, this.err
+	}
+
+	if this.merge == nil {
+		return this.requests[0].reply, this.requests[0].err
+	}
+
+	return this.merge(this.requests)
+}
+
+//Works out what needs to be sent to redis for the given command, and how its reply gets put together
+func (this *Client) prepareReply(command protocol.Command) *pendingReply {
+	if split, ok := fanoutCommands[string(command.GetCommand())]; ok {
+		return split(this, command)
+	}
+
+	if keys := protocol.GetKeys(command); len(keys) > 1 {
+		return routeToSinglePool(this, command, keys)
+	}
+
+	return passThrough(command)
+}
+
+//Sends a command with several keys to the connection pool that they all hash to
+//Commands whose keys hash to more than one pool are rejected, since redis can only run them against a single server
+func routeToSinglePool(client *Client, command protocol.Command, keys [][]byte) *pendingReply {
+	pools, _, downKeys := groupKeysByPool(client.HashRing, keys)
+	if len(downKeys) > 0 {
+		return &pendingReply{err: ERR_CONNECTION_DOWN}
+	} else if len(pools) > 1 {
+		return immediateReply(CROSS_POOL_RESPONSE)
+	}
+
+	return &pendingReply{requests: []*poolRequest{{command: command, pool: pools[0]}}}
+}
+
+//Groups the given keys by the connection pool they hash to, keeping track of each key's position
+//Keys whose pool is down are returned separately
+func groupKeysByPool(hashRing *connection.HashRing, keys [][]byte) (pools []*connection.ConnectionPool, positions [][]int, downKeys []int) {
+	poolIndexes := make(map[*connection.ConnectionPool]int)
+
+	for i, key := range keys {
+		connectionPool, err := hashRing.GetConnectionPoolForKey(key)
+		if err != nil {
+			downKeys = append(downKeys, i)
+			continue
+		}
+
+		poolIndex, ok := poolIndexes[connectionPool]
+		if !ok {
+			poolIndex = len(pools)
+			poolIndexes[connectionPool] = poolIndex
+			pools = append(pools, connectionPool)
+			positions = append(positions, nil)
+		}
+		positions[poolIndex] = append(positions[poolIndex], i)
+	}
+
+	return
+}
+
+//Builds one request per connection pool, each holding the arguments of the keys that hash to that pool
+//Every key owns argsPerKey arguments, starting at its own position
+func buildPoolRequests(commandName []byte, args [][]byte, argsPerKey int, pools []*connection.ConnectionPool, positions [][]int) []*poolRequest {
+	requests := make([]*poolRequest, len(pools))
+	for i, connectionPool := range pools {
+		poolArgs := make([][]byte, 0, len(positions[i])*argsPerKey)
+		for _, position := range positions[i] {
+			poolArgs = append(poolArgs, args[position*argsPerKey:(position+1)*argsPerKey]...)
+		}
+
+		requests[i] = &poolRequest{
+			command: protocol.NewMultibulkCommand(commandName, poolArgs...),
+			pool:    connectionPool,
+		}
+	}
+	return requests
+}
+
+//Replies to the client with the given line, without sending anything to redis
+func immediateReply(line []byte) *pendingReply {
+	return &pendingReply{merge: func(requests []*poolRequest) ([]byte, error) {
+		return append(append([]byte{}, line...), protocol.REDIS_NEWLINE...), nil
+	}}
+}
+
+//Passes the command through to a single pool, as-is
+func passThrough(command protocol.Command) *pendingReply {
+	return &pendingReply{requests: []*poolRequest{{command: command}}}
+}
+
+//Splits an MGET into one MGET per connection pool, and puts the values back together in the order of the keys
+func splitMget(client *Client, command protocol.Command) *pendingReply {
+	keys := command.GetArgs()
+	if len(keys) < 2 {
+		// Nothing to split up, let redis handle it
+		return passThrough(command)
+	}
+
+	nilOnPoolDown := client.NilOnPoolDown
+	pools, positions, downKeys := groupKeysByPool(client.HashRing, keys)
+	if len(downKeys) > 0 && !nilOnPoolDown {
+		return &pendingReply{err: ERR_CONNECTION_DOWN}
+	}
+
+	pending := &pendingReply{}
+	pending.requests = buildPoolRequests(protocol.MGET_COMMAND, keys, 1, pools, positions)
+	pending.merge = func(requests []*poolRequest) ([]byte, error) {
+		values := make([][]byte, len(keys))
+		for i := range values {
+			values[i] = protocol.NIL_BULK_RESPONSE
+		}
+
+		for i, request := range requests {
+			if request.err != nil {
+				if nilOnPoolDown {
+					continue
+				}
+				return nil, request.err
+			}
+
+			if len(request.reply) > 0 && request.reply[0] == '-' {
+				// Redis errored, let the client see why
+				return request.reply, nil
+			}
+
+			poolValues, err := protocol.SplitArrayResponse(request.reply)
+			if err != nil || len(poolValues) != len(positions[i]) {
+				return nil, protocol.ERROR_BAD_BULK_FORMAT
+			}
+
+			for j, position := range positions[i] {
+				values[position] = poolValues[j]
+			}
+		}
+
+		return protocol.JoinArrayResponse(values), nil
+	}
+
+	return pending
+}
+
+//Splits a command over a list of keys into one command per connection pool, and sums up their integer replies
+//Used for DEL, EXISTS, UNLINK and TOUCH
+func splitAndSum(client *Client, command protocol.Command) *pendingReply {
+	keys := command.GetArgs()
+	if len(keys) < 2 {
+		return passThrough(command)
+	}
+
+	pools, positions, downKeys := groupKeysByPool(client.HashRing, keys)
+	if len(downKeys) > 0 {
+		return &pendingReply{err: ERR_CONNECTION_DOWN}
+	}
+
+	pending := &pendingReply{}
+	pending.requests = buildPoolRequests(command.GetCommand(), keys, 1, pools, positions)
+	pending.merge = sumIntegerReplies
+	return pending
+}
+
+//Replies with the sum of every pool's integer reply
+func sumIntegerReplies(requests []*poolRequest) ([]byte, error) {
+	total := 0
+	for _, request := range requests {
+		if request.err != nil {
+			return nil, request.err
+		}
+
+		reply := request.reply
+		if len(reply) > 0 && reply[0] == '-' {
+			return reply, nil
+		} else if len(reply) < 3 || reply[0] != ':' {
+			return nil, protocol.ERROR_INVALID_INT
+		}
+
+		count, err := protocol.ParseInt(reply[1 : len(reply)-2])
+		if err != nil {
+			return nil, err
+		}
+		total += count
+	}
+
+	return []byte(":" + strconv.Itoa(total) + "\r\n"), nil
+}
+
+//Splits an MSET into one MSET per connection pool, which only replies +OK when every pool does
+func splitMset(client *Client, command protocol.Command) *pendingReply {
+	args := command.GetArgs()
+	if len(args) < 4 || len(args)%2 != 0 {
+		// A single pair, or a bad number of arguments that redis will complain about
+		return passThrough(command)
+	}
+
+	keys := make([][]byte, len(args)/2)
+	for i := range keys {
+		keys[i] = args[i*2]
+	}
+
+	pools, positions, downKeys := groupKeysByPool(client.HashRing, keys)
+	if len(downKeys) > 0 {
+		return &pendingReply{err: ERR_CONNECTION_DOWN}
+	}
+
+	pending := &pendingReply{}
+	pending.requests = buildPoolRequests(command.GetCommand(), args, 2, pools, positions)
+	pending.merge = func(requests []*poolRequest) ([]byte, error) {
+		for _, request := range requests {
+			if request.err != nil {
+				return nil, request.err
+			} else if !bytes.HasPrefix(request.reply, protocol.OK_RESPONSE) {
+				return request.reply, nil
+			}
+		}
+
+		return append(append([]byte{}, protocol.OK_RESPONSE...), protocol.REDIS_NEWLINE...), nil
+	}
+
+	return pending
+}
+
+//MSETNX can only stay all-or-nothing if every key lives on the same connection pool, so it is never split up
+func checkMsetnx(client *Client, command protocol.Command) *pendingReply {
+	args := command.GetArgs()
+	if len(args) < 4 || len(args)%2 != 0 {
+		return passThrough(command)
+	}
+
+	keys := make([][]byte, len(args)/2)
+	for i := range keys {
+		keys[i] = args[i*2]
+	}
+
+	return routeToSinglePool(client, command, keys)
+}
+
+//Sends SCRIPT LOAD, EXISTS and FLUSH to every connection pool, so that EVALSHA works on whichever pool its keys hash to
+func broadcastScript(client *Client, command protocol.Command) *pendingReply {
+	var merge func(requests []*poolRequest) ([]byte, error)
+	switch string(bytes.ToLower(command.GetFirstArg())) {
+	case "load", "flush":
+		merge = mergeMatchingReplies
+	case "exists":
+		merge = mergeScriptExists
+	default:
+		// The other subcommands act on whichever script is running on a single server
+		return &pendingReply{err: protocol.ERR_COMMAND_UNSUPPORTED}
+	}
+
+	return broadcast(client, command, merge)
+}
+
+//Sends the command to every connection pool, failing if any of them are down
+//Pools in maintenance still hold their keys, so they are sent the command too
+func broadcast(client *Client, command protocol.Command, merge func(requests []*poolRequest) ([]byte, error)) *pendingReply {
+	connectionPools := client.HashRing.GetConnectionPools()
+	requests := make([]*poolRequest, len(connectionPools))
+	for i, connectionPool := range connectionPools {
+		if !connectionPool.IsReachable() {
+			return &pendingReply{err: ERR_CONNECTION_DOWN}
+		}
+		requests[i] = &poolRequest{command: command, pool: connectionPool}
+	}
+
+	return &pendingReply{requests: requests, merge: merge}
+}
+
+//KEYS replies with the keys of every pool
+func broadcastKeys(client *Client, command protocol.Command) *pendingReply {
+	return broadcast(client, command, concatArrayReplies)
+}
+
+//DBSIZE replies with the number of keys across every pool
+func broadcastDbsize(client *Client, command protocol.Command) *pendingReply {
+	return broadcast(client, command, sumIntegerReplies)
+}
+
+//FLUSHDB and FLUSHALL only reply +OK once every pool has been flushed.  They are only run if the client allows flushes
+func broadcastFlush(client *Client, command protocol.Command) *pendingReply {
+	if !client.AllowFlush {
+		return &pendingReply{err: protocol.ERR_COMMAND_UNSUPPORTED}
+	}
+	return broadcast(client, command, mergeMatchingReplies)
+}
+
+//Replies with one array holding the elements of every pool's array
+func concatArrayReplies(requests []*poolRequest) ([]byte, error) {
+	var elements [][]byte
+	for _, request := range requests {
+		if request.err != nil {
+			return nil, request.err
+		} else if len(request.reply) > 0 && request.reply[0] == '-' {
+			return request.reply, nil
+		}
+
+		poolElements, err := protocol.SplitArrayResponse(request.reply)
+		if err != nil {
+			return nil, protocol.ERROR_BAD_BULK_FORMAT
+		}
+		elements = append(elements, poolElements...)
+	}
+
+	return protocol.JoinArrayResponse(elements), nil
+}
+
+//Replies with what every pool replied, or with the first error or mismatched reply
+func mergeMatchingReplies(requests []*poolRequest) ([]byte, error) {
+	for _, request := range requests {
+		if request.err != nil {
+			return nil, request.err
+		} else if len(request.reply) > 0 && request.reply[0] == '-' {
+			return request.reply, nil
+		} else if !bytes.Equal(request.reply, requests[0].reply) {
+			return nil, ERR_POOLS_DISAGREE
+		}
+	}
+
+	return requests[0].reply, nil
+}
+
+//A script only exists if it exists on every pool
+func mergeScriptExists(requests []*poolRequest) ([]byte, error) {
+	var exists [][]byte
+	for _, request := range requests {
+		if request.err != nil {
+			return nil, request.err
+		} else if len(request.reply) > 0 && request.reply[0] == '-' {
+			return request.reply, nil
+		}
+
+		poolExists, err := protocol.SplitArrayResponse(request.reply)
+		if err != nil || (exists != nil && len(poolExists) != len(exists)) {
+			return nil, protocol.ERROR_BAD_BULK_FORMAT
+		}
+
+		if exists == nil {
+			exists = poolExists
+			continue
+		}
+		for i, value := range poolExists {
+			if !bytes.Equal(value, exists[i]) {
+				exists[i] = []byte(":0\r\n")
+			}
+		}
+	}
+
+	return protocol.JoinArrayResponse(exists), nil
+}
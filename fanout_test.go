@@ -0,0 +1,406 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//Answers MGETs with "value-<key>" for each key, or nil for keys starting with "missing"
+func mgetHandler(command protocol.Command) string {
+	keys := command.GetArgs()
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		if strings.HasPrefix(string(key), "missing") {
+			values[i] = protocol.NIL_BULK_RESPONSE
+		} else {
+			values[i] = []byte(bulkReply("value-" + string(key)))
+		}
+	}
+	return string(protocol.JoinArrayResponse(values))
+}
+
+func runTestCommand(t *testing.T, client *Client, args ...string) {
+	command, err := protocol.ParseCommand([]byte(makeTestCommand(args...)))
+	if err != nil {
+		t.Fatalf("Error parsing command: %s", err)
+	}
+	client.Queue(command)
+	client.FlushRedisAndRespond()
+}
+
+func TestMgetAcrossPools(t *testing.T) {
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest1.sock", mgetHandler)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest2.sock", mgetHandler)
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxFanoutTest.sock", "/tmp/rmuxFanoutTest1.sock", "/tmp/rmuxFanoutTest2.sock")
+	defer server.Listener.Close()
+
+	keys := []string{"a", "missing1", "b", "c", "d", "e", "f"}
+	pools, _, _ := groupKeysByPool(server.HashRing, toByteSlices(keys))
+	if len(pools) != 2 {
+		t.Fatalf("Expected the test keys to hash to both pools")
+	}
+
+	client, output := newTestClient(server)
+	runTestCommand(t, client, append([]string{"mget"}, keys...)...)
+
+	expected := "*7\r\n" + bulkReply("value-a") + "$-1\r\n" + bulkReply("value-b") + bulkReply("value-c") +
+		bulkReply("value-d") + bulkReply("value-e") + bulkReply("value-f")
+	if output.String() != expected {
+		t.Errorf("Unexpected MGET reply.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
+
+func TestMgetWithDownPool(t *testing.T) {
+	listener := StartMockRedisServer(t, "/tmp/rmuxFanoutTest1.sock", mgetHandler)
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxFanoutTest.sock", "/tmp/rmuxFanoutTest1.sock", "/tmp/rmuxFanoutTest2.sock")
+	defer server.Listener.Close()
+
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	expected := "*6\r\n"
+	for _, key := range keys {
+		if pool, err := server.HashRing.GetConnectionPoolForKey([]byte(key)); err == nil && pool == server.ConnectionCluster[0] {
+			expected += bulkReply("value-" + key)
+		} else {
+			expected += "$-1\r\n"
+		}
+	}
+
+	client, output := newTestClient(server)
+	runTestCommand(t, client, append([]string{"mget"}, keys...)...)
+	if expectedErr := "-ERR " + ERR_CONNECTION_DOWN.Error() + "\r\n"; output.String() != expectedErr {
+		t.Errorf("MGET should have failed with a down pool.\r\nExpected %q\r\nGot      %q", expectedErr, output.String())
+	}
+
+	client, output = newTestClient(server)
+	client.NilOnPoolDown = true
+	runTestCommand(t, client, append([]string{"mget"}, keys...)...)
+	if output.String() != expected {
+		t.Errorf("MGET should have returned nil for keys on the down pool.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
+
+func toByteSlices(strs []string) [][]byte {
+	slices := make([][]byte, len(strs))
+	for i, str := range strs {
+		slices[i] = []byte(str)
+	}
+	return slices
+}
+
+//Answers multi-key writes as if every key existed
+func multiKeyHandler(command protocol.Command) string {
+	args := command.GetArgs()
+	switch string(command.GetCommand()) {
+	case "del", "exists", "unlink", "touch":
+		return ":" + strconv.Itoa(len(args)) + "\r\n"
+	case "mset":
+		return "+OK\r\n"
+	case "msetnx":
+		return ":1\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func TestMultiKeyWritesAcrossPools(t *testing.T) {
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest1.sock", multiKeyHandler)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest2.sock", multiKeyHandler)
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxFanoutTest.sock", "/tmp/rmuxFanoutTest1.sock", "/tmp/rmuxFanoutTest2.sock")
+	defer server.Listener.Close()
+
+	testData := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"del", "a", "b", "c", "d", "e", "f"}, ":6\r\n"},
+		{[]string{"exists", "a", "a", "b"}, ":3\r\n"},
+		{[]string{"unlink", "a", "b", "c"}, ":3\r\n"},
+		{[]string{"touch", "a", "b", "c", "d"}, ":4\r\n"},
+		{[]string{"mset", "a", "1", "b", "2", "c", "3", "d", "4"}, "+OK\r\n"},
+		{[]string{"msetnx", "a", "1", "b", "2", "c", "3", "d", "4"}, string(CROSS_POOL_RESPONSE) + "\r\n"},
+		{[]string{"msetnx", "a", "1"}, ":1\r\n"},
+	}
+
+	for _, data := range testData {
+		client, output := newTestClient(server)
+		runTestCommand(t, client, data.args...)
+
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+	}
+}
+
+func TestMsetWithFailingPool(t *testing.T) {
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest1.sock", multiKeyHandler)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest2.sock", func(command protocol.Command) string {
+		return "-MISCONF Errors writing to disk\r\n"
+	})
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxFanoutTest.sock", "/tmp/rmuxFanoutTest1.sock", "/tmp/rmuxFanoutTest2.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	runTestCommand(t, client, "mset", "a", "1", "b", "2", "c", "3", "d", "4")
+
+	if expected := "-MISCONF Errors writing to disk\r\n"; output.String() != expected {
+		t.Errorf("MSET should have failed when a pool does.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
+
+func TestKeysRoutedByKeyPosition(t *testing.T) {
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest1.sock", func(command protocol.Command) string { return "+pool1\r\n" })
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest2.sock", func(command protocol.Command) string { return "+pool2\r\n" })
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxFanoutTest.sock", "/tmp/rmuxFanoutTest1.sock", "/tmp/rmuxFanoutTest2.sock")
+	defer server.Listener.Close()
+
+	//Find a key on each pool
+	keysByPool := map[int]string{}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		pool, _ := server.HashRing.GetConnectionPoolForKey([]byte(key))
+		if pool == server.ConnectionCluster[0] {
+			keysByPool[1] = key
+		} else {
+			keysByPool[2] = key
+		}
+	}
+	if len(keysByPool) != 2 {
+		t.Fatalf("Expected the test keys to hash to both pools")
+	}
+
+	for poolNumber, key := range keysByPool {
+		expected := "+pool" + strconv.Itoa(poolNumber) + "\r\n"
+		for _, args := range [][]string{
+			{"object", "encoding", key},
+			{"memory", "usage", key},
+			{"xread", "STREAMS", key, "0"},
+			{"georadius", key, "15", "37", "200", "km", "STORE", key},
+			{"eval", "return 1", "2", key, key, "arg"},
+			{"evalsha", "e0e1f9fabfc9d4800c877a703b823ac0578ff8db", "1", key},
+		} {
+			client, output := newTestClient(server)
+			runTestCommand(t, client, args...)
+			if output.String() != expected {
+				t.Errorf("Expected %v to be routed to pool %d, got %q", args, poolNumber, output.String())
+			}
+		}
+	}
+
+	expected := string(CROSS_POOL_RESPONSE) + "\r\n"
+	for _, args := range [][]string{
+		{"xread", "STREAMS", keysByPool[1], keysByPool[2], "0", "0"},
+		{"sort", keysByPool[1], "STORE", keysByPool[2]},
+		{"eval", "return 1", "2", keysByPool[1], keysByPool[2]},
+	} {
+		client, output := newTestClient(server)
+		runTestCommand(t, client, args...)
+		if output.String() != expected {
+			t.Errorf("Expected %v to be rejected, got %q", args, output.String())
+		}
+	}
+}
+
+func TestMultiKeyCommandsWithHashTags(t *testing.T) {
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest1.sock", func(command protocol.Command) string { return "+pool1\r\n" })
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest2.sock", func(command protocol.Command) string { return "+pool2\r\n" })
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxFanoutTest.sock", "/tmp/rmuxFanoutTest1.sock", "/tmp/rmuxFanoutTest2.sock")
+	defer server.Listener.Close()
+	server.HashRing.HashTags = true
+
+	//Find a hash tag on each pool
+	tagsByPool := map[int]string{}
+	for _, tag := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		pool, _ := server.HashRing.GetConnectionPoolForKey([]byte(tag))
+		if pool == server.ConnectionCluster[0] {
+			tagsByPool[1] = tag
+		} else {
+			tagsByPool[2] = tag
+		}
+	}
+	if len(tagsByPool) != 2 {
+		t.Fatalf("Expected the test tags to hash to both pools")
+	}
+
+	for poolNumber, tag := range tagsByPool {
+		x, y := "{"+tag+"}x", "{"+tag+"}y"
+		expected := "+pool" + strconv.Itoa(poolNumber) + "\r\n"
+		for _, args := range [][]string{
+			{"rpoplpush", x, y},
+			{"brpoplpush", x, y, "0"},
+			{"lmove", x, y, "LEFT", "RIGHT"},
+			{"rename", x, y},
+			{"smove", x, y, "member"},
+			{"sinterstore", x, x, y},
+			{"zunionstore", x, "2", x, y},
+			{"bitop", "and", x, y},
+			{"pfcount", x, y},
+		} {
+			client, output := newTestClient(server)
+			runTestCommand(t, client, args...)
+			if output.String() != expected {
+				t.Errorf("Expected %v to be routed to pool %d, got %q", args, poolNumber, output.String())
+			}
+		}
+	}
+
+	expected := string(CROSS_POOL_RESPONSE) + "\r\n"
+	x, y := "{"+tagsByPool[1]+"}x", "{"+tagsByPool[2]+"}y"
+	for _, args := range [][]string{
+		{"rpoplpush", x, y},
+		{"rename", x, y},
+		{"sunion", x, y},
+	} {
+		client, output := newTestClient(server)
+		runTestCommand(t, client, args...)
+		if output.String() != expected {
+			t.Errorf("Expected %v to be rejected, got %q", args, output.String())
+		}
+	}
+}
+
+func TestScriptBroadcast(t *testing.T) {
+	subcommands := make(chan string, 10)
+	scriptHandler := func(exists string) func(protocol.Command) string {
+		return func(command protocol.Command) string {
+			subcommands <- strings.ToLower(string(command.GetFirstArg()))
+			switch strings.ToLower(string(command.GetFirstArg())) {
+			case "load":
+				return bulkReply("e0e1f9fabfc9d4800c877a703b823ac0578ff8db")
+			case "exists":
+				return exists
+			}
+			return "+OK\r\n"
+		}
+	}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest1.sock", scriptHandler("*2\r\n:1\r\n:1\r\n"))
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest2.sock", scriptHandler("*2\r\n:1\r\n:0\r\n"))
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxFanoutTest.sock", "/tmp/rmuxFanoutTest1.sock", "/tmp/rmuxFanoutTest2.sock")
+	defer server.Listener.Close()
+
+	var testData = []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"script", "load", "return 1"}, bulkReply("e0e1f9fabfc9d4800c877a703b823ac0578ff8db")},
+		{[]string{"script", "EXISTS", "sha1", "sha2"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"script", "flush"}, "+OK\r\n"},
+	}
+
+	for _, data := range testData {
+		client, output := newTestClient(server)
+		runTestCommand(t, client, data.args...)
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+
+		for i := 0; i < 2; i++ {
+			if subcommand := <-subcommands; subcommand != strings.ToLower(data.args[1]) {
+				t.Errorf("Expected %v to be sent to both pools, got %s", data.args, subcommand)
+			}
+		}
+	}
+
+	client, output := newTestClient(server)
+	runTestCommand(t, client, "script", "kill")
+	if expected := "-ERR " + protocol.ERR_COMMAND_UNSUPPORTED.Error() + "\r\n"; output.String() != expected {
+		t.Errorf("SCRIPT KILL should be rejected.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
+
+func TestBroadcastKeysDbsizeAndFlush(t *testing.T) {
+	broadcastHandler := func(keys ...string) func(protocol.Command) string {
+		return func(command protocol.Command) string {
+			switch string(command.GetCommand()) {
+			case "keys":
+				reply := "*" + strconv.Itoa(len(keys)) + "\r\n"
+				for _, key := range keys {
+					reply += bulkReply(key)
+				}
+				return reply
+			case "dbsize":
+				return ":" + strconv.Itoa(len(keys)) + "\r\n"
+			}
+			return "+OK\r\n"
+		}
+	}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest1.sock", broadcastHandler("a", "b"))
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxFanoutTest2.sock", broadcastHandler("c"))
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxFanoutTest.sock", "/tmp/rmuxFanoutTest1.sock", "/tmp/rmuxFanoutTest2.sock")
+	defer server.Listener.Close()
+
+	var testData = []struct {
+		args       []string
+		allowFlush bool
+		expected   string
+	}{
+		{[]string{"dbsize"}, false, ":3\r\n"},
+		{[]string{"flushdb"}, false, "-ERR " + protocol.ERR_COMMAND_UNSUPPORTED.Error() + "\r\n"},
+		{[]string{"flushall"}, false, "-ERR " + protocol.ERR_COMMAND_UNSUPPORTED.Error() + "\r\n"},
+		{[]string{"flushdb"}, true, "+OK\r\n"},
+		{[]string{"flushall", "async"}, true, "+OK\r\n"},
+	}
+
+	client, output := newTestClient(server)
+	runTestCommand(t, client, "keys", "*")
+	elements, err := protocol.SplitArrayResponse(output.Bytes())
+	if err != nil || len(elements) != 3 {
+		t.Errorf("Expected KEYS to return the keys of both pools, got %q", output.String())
+	}
+
+	for _, data := range testData {
+		client, output := newTestClient(server)
+		client.AllowFlush = data.allowFlush
+		runTestCommand(t, client, data.args...)
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"github.com/salesforce/rmux/protocol"
+	"strconv"
+)
+
+var (
+	HELLO_COMMAND  = []byte("hello")
+	CLIENT_COMMAND = []byte("client")
+
+	NOPROTO_RESPONSE      = []byte("-NOPROTO unsupported protocol version")
+	HELLO_NOAUTH_RESPONSE = []byte("-NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+
+	ERR_BAD_PROTOCOL_VERSION = protocol.NewRecoverableError("Protocol version is not an integer or out of range")
+	ERR_BAD_CLIENT_NAME      = protocol.NewRecoverableError("Client names cannot contain spaces, newlines or special characters.")
+	ERR_BAD_CLIENT_INFO      = protocol.NewRecoverableError("lib-name and lib-ver cannot contain spaces, newlines or special characters.")
+)
+
+//Answers HELLO the way redis does, with a map describing the server (a flat array for RESP2 clients)
+//RESP2 and RESP3 are spoken.  AUTH and SETNAME are handled the same way that AUTH and CLIENT SETNAME are
+func (this *Client) hello(command protocol.Command) ([]byte, error) {
+	args := command.GetArgs()
+	resp := this.Protocol
+	if len(args) > 0 {
+		version, err := protocol.ParseInt(args[0])
+		if err != nil {
+			return nil, ERR_BAD_PROTOCOL_VERSION
+		} else if version != 2 && version != 3 {
+			return NOPROTO_RESPONSE, nil
+		}
+		resp = version
+		args = args[1:]
+	}
+
+	//Every option is checked before the client is authenticated, the way redis does
+	var name []byte
+	var credentials [][]byte
+	for i := 0; i < len(args); i++ {
+		switch {
+		case bytes.EqualFold(args[i], []byte("auth")) && i+2 < len(args):
+			credentials = args[i+1 : i+3]
+			i += 2
+		case bytes.EqualFold(args[i], []byte("setname")) && i+1 < len(args):
+			if !isValidClientName(args[i+1]) {
+				return nil, ERR_BAD_CLIENT_NAME
+			}
+			name = args[i+1]
+			i++
+		default:
+			return nil, protocol.NewRecoverableError("Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+
+	if credentials != nil {
+		if this.Password == "" {
+			return NO_PASSWORD_RESPONSE, nil
+		} else if !this.checkPassword(credentials) {
+			return WRONGPASS_RESPONSE, nil
+		}
+		this.authenticated = true
+	}
+
+	if !this.IsAuthenticated() {
+		return HELLO_NOAUTH_RESPONSE, nil
+	}
+	if name != nil {
+		this.Name = string(name)
+	}
+	this.Protocol = resp
+
+	reply := protocol.JoinArrayResponse([][]byte{
+		bulkString([]byte("server")), bulkString([]byte("rmux")),
+		bulkString([]byte("version")), bulkString([]byte(version)),
+		bulkString([]byte("proto")), []byte(":" + strconv.Itoa(resp) + "\r\n"),
+		bulkString([]byte("id")), []byte(":" + strconv.FormatUint(this.Id, 10) + "\r\n"),
+		bulkString([]byte("mode")), bulkString([]byte("standalone")),
+		bulkString([]byte("role")), bulkString([]byte("master")),
+		bulkString([]byte("modules")), []byte("*0\r\n"),
+	})
+	return bytes.TrimSuffix(this.convertReply(command, reply), protocol.REDIS_NEWLINE), nil
+}
+
+//Answers the CLIENT subcommands that describe the client's own connection to rmux
+//None of them are sent to redis, since the client's commands share their connections to redis with other clients
+func (this *Client) clientCommand(command protocol.Command) ([]byte, error) {
+	args := command.GetArgs()
+	switch {
+	case bytes.EqualFold(args[0], []byte("id")) && len(args) == 1:
+		return []byte(":" + strconv.FormatUint(this.Id, 10)), nil
+	case bytes.EqualFold(args[0], []byte("getname")) && len(args) == 1:
+		if this.Name == "" && this.Protocol == 3 {
+			return bytes.TrimSuffix(protocol.RESP3_NULL, protocol.REDIS_NEWLINE), nil
+		} else if this.Name == "" {
+			return bytes.TrimSuffix(protocol.NIL_BULK_RESPONSE, protocol.REDIS_NEWLINE), nil
+		}
+		return bytes.TrimSuffix(bulkString([]byte(this.Name)), protocol.REDIS_NEWLINE), nil
+	case bytes.EqualFold(args[0], []byte("setname")) && len(args) == 2:
+		if !isValidClientName(args[1]) {
+			return nil, ERR_BAD_CLIENT_NAME
+		}
+		this.Name = string(args[1])
+		return protocol.OK_RESPONSE, nil
+	case bytes.EqualFold(args[0], []byte("setinfo")) && len(args) == 3:
+		if !isValidClientName(args[2]) {
+			return nil, ERR_BAD_CLIENT_INFO
+		}
+		if bytes.EqualFold(args[1], []byte("lib-name")) {
+			this.LibName = string(args[2])
+		} else if bytes.EqualFold(args[1], []byte("lib-ver")) {
+			this.LibVersion = string(args[2])
+		} else {
+			return nil, protocol.NewRecoverableError("Unrecognized option '" + string(args[1]) + "'")
+		}
+		return protocol.OK_RESPONSE, nil
+	case bytes.EqualFold(args[0], []byte("list")):
+		return this.clientList(args[1:])
+	case bytes.EqualFold(args[0], []byte("kill")) && len(args) > 1:
+		return this.clientKill(args[1:])
+	}
+
+	return nil, protocol.ERR_BAD_ARGUMENTS
+}
+
+//Client names (and library names and versions) can't hold spaces or special characters, so that CLIENT LIST stays
+//parseable
+func isValidClientName(name []byte) bool {
+	for _, c := range name {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"testing"
+)
+
+func TestHelloAndClientHandshake(t *testing.T) {
+	recorder := &transactionRecorder{}
+	listener := StartMockRedisServer(t, "/tmp/rmuxHandshakeTest1.sock", recorder.handle)
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxHandshakeTest.sock", "/tmp/rmuxHandshakeTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	client.Id = 7
+	client.Password = "secret"
+
+	helloReply := "*14\r\n" + bulkReply("server") + bulkReply("rmux") + bulkReply("version") + bulkReply(version) +
+		bulkReply("proto") + ":2\r\n" + bulkReply("id") + ":7\r\n" + bulkReply("mode") + bulkReply("standalone") +
+		bulkReply("role") + bulkReply("master") + bulkReply("modules") + "*0\r\n"
+
+	testData := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"hello"}, string(HELLO_NOAUTH_RESPONSE) + "\r\n"},
+		{[]string{"hello", "2", "AUTH", "default", "wrong"}, string(WRONGPASS_RESPONSE) + "\r\n"},
+		//A bad option leaves the client unauthenticated, even with the right password
+		{[]string{"hello", "2", "AUTH", "default", "secret", "SETNAME", "worker 1"}, "-ERR " + ERR_BAD_CLIENT_NAME.Error() + "\r\n"},
+		{[]string{"hello", "2", "AUTH", "default", "secret", "BOGUS"}, "-ERR Syntax error in HELLO option 'BOGUS'\r\n"},
+		{[]string{"hello"}, string(HELLO_NOAUTH_RESPONSE) + "\r\n"},
+		{[]string{"hello", "2", "AUTH", "default", "secret", "SETNAME", "worker-1"}, helloReply},
+		{[]string{"hello", "4"}, string(NOPROTO_RESPONSE) + "\r\n"},
+		{[]string{"hello", "two"}, "-ERR " + ERR_BAD_PROTOCOL_VERSION.Error() + "\r\n"},
+		{[]string{"hello", "2", "SETNAME"}, "-ERR Syntax error in HELLO option 'SETNAME'\r\n"},
+		{[]string{"client", "getname"}, bulkReply("worker-1")},
+		{[]string{"client", "setname", "worker 2"}, "-ERR " + ERR_BAD_CLIENT_NAME.Error() + "\r\n"},
+		{[]string{"client", "setname", "worker-2"}, "+OK\r\n"},
+		{[]string{"client", "getname"}, bulkReply("worker-2")},
+		{[]string{"client", "setinfo", "lib-name", "go-redis"}, "+OK\r\n"},
+		{[]string{"client", "setinfo", "LIB-VER", "9.0.0"}, "+OK\r\n"},
+		{[]string{"client", "setinfo", "lib-color", "blue"}, "-ERR Unrecognized option 'lib-color'\r\n"},
+		{[]string{"client", "id"}, ":7\r\n"},
+		{[]string{"client", "pause", "10"}, "-ERR " + protocol.ERR_COMMAND_UNSUPPORTED.Error() + "\r\n"},
+	}
+	for _, data := range testData {
+		output.Reset()
+		runClientCommand(t, server, client, data.args...)
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+	}
+
+	if client.LibName != "go-redis" || client.LibVersion != "9.0.0" {
+		t.Errorf("Expected the client's library to be go-redis 9.0.0, got %s %s", client.LibName, client.LibVersion)
+	}
+	if recorded := recorder.recorded(); recorded != "" {
+		t.Errorf("The handshake should not have reached redis, got %q", recorded)
+	}
+}
+
+func TestResp3Replies(t *testing.T) {
+	listener := StartMockRedisServer(t, "/tmp/rmuxHandshakeTest1.sock", func(command protocol.Command) string {
+		switch string(command.GetCommand()) {
+		case "hgetall":
+			return "*2\r\n" + bulkReply("field") + bulkReply("value")
+		case "smembers":
+			return "*1\r\n" + bulkReply("member")
+		case "zscore":
+			return bulkReply("1.5")
+		case "get":
+			return "$-1\r\n"
+		}
+		return "+OK\r\n"
+	})
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxHandshakeTest.sock", "/tmp/rmuxHandshakeTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	client.Id = 7
+	runClientCommand(t, server, client, "hello", "3")
+	expected := "%7\r\n" + bulkReply("server") + bulkReply("rmux") + bulkReply("version") + bulkReply(version) +
+		bulkReply("proto") + ":3\r\n" + bulkReply("id") + ":7\r\n" + bulkReply("mode") + bulkReply("standalone") +
+		bulkReply("role") + bulkReply("master") + bulkReply("modules") + "*0\r\n"
+	if output.String() != expected {
+		t.Errorf("Unexpected reply to HELLO 3.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+
+	testData := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"hgetall", "hash"}, "%1\r\n" + bulkReply("field") + bulkReply("value")},
+		{[]string{"smembers", "set"}, "~1\r\n" + bulkReply("member")},
+		{[]string{"zscore", "zset", "member"}, ",1.5\r\n"},
+		{[]string{"get", "missing"}, "_\r\n"},
+		{[]string{"client", "getname"}, "_\r\n"},
+	}
+	for _, data := range testData {
+		output.Reset()
+		runClientCommand(t, server, client, data.args...)
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+	}
+
+	if expected := ">3\r\n" + bulkReply("subscribe") + bulkReply("news") + ":1\r\n"; string(client.subscriptionReply(protocol.SUBSCRIBE_COMMAND, []byte("news"), 1)) != expected {
+		t.Errorf("Expected RESP3 subscription confirmations to be pushes, got %q", client.subscriptionReply(protocol.SUBSCRIBE_COMMAND, []byte("news"), 1))
+	}
+
+	//Switching back to RESP2 turns the conversion off again
+	runClientCommand(t, server, client, "hello", "2")
+	output.Reset()
+	runClientCommand(t, server, client, "hgetall", "hash")
+	if expected := "*2\r\n" + bulkReply("field") + bulkReply("value"); output.String() != expected {
+		t.Errorf("Unexpected RESP2 reply to HGETALL.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
@@ -0,0 +1,309 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/salesforce/rmux/protocol"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//The INFO sections that rmux answers, in the order that they are listed in
+var infoSections = []struct {
+	name string
+	//Whether a plain INFO includes the section
+	isDefault bool
+	//Builds a section that describes rmux itself.  Nil for the sections that are gathered from redis
+	build func(this *RedisMultiplexer) string
+	//The title of a section that is gathered from every connection pool when multiplexing, and merged together
+	poolTitle string
+}{
+	{"rmux", true, (*RedisMultiplexer).rmuxInfo, ""},
+	{"clients", true, (*RedisMultiplexer).clientsInfo, ""},
+	{"pools", true, (*RedisMultiplexer).poolsInfo, ""},
+	{"memory", true, nil, "Memory"},
+	{"stats", true, nil, "Stats"},
+	{"commandstats", false, (*RedisMultiplexer).commandstatsInfo, ""},
+	{"keyspace", true, nil, "Keyspace"},
+}
+
+//Call counts and latency for a single command
+type commandStat struct {
+	calls    int64
+	usec     int64
+	rejected int64
+}
+
+//The name that every command that isn't in the command table (other than RMUX) is counted under, so that clients can't
+//add an entry for every made-up command that they send
+const UNKNOWN_COMMAND_STAT = "unknown"
+
+//Call counts and latency for every command that clients of the server have sent
+type commandStats struct {
+	lock     sync.Mutex
+	commands map[string]*commandStat
+}
+
+func newCommandStats() *commandStats {
+	return &commandStats{commands: make(map[string]*commandStat)}
+}
+
+func (this *commandStats) get(command protocol.Command) *commandStat {
+	name := UNKNOWN_COMMAND_STAT
+	if info := protocol.LookupCommand(command.GetCommand()); info != nil {
+		name = info.Name
+	} else if bytes.Equal(command.GetCommand(), RMUX_COMMAND) {
+		// Rmux's own command is answered locally, like AUTH and CLIENT
+		name = string(RMUX_COMMAND)
+	}
+	stat, ok := this.commands[name]
+	if !ok {
+		stat = &commandStat{}
+		this.commands[name] = stat
+	}
+	return stat
+}
+
+//Records a call of the command, that took the given time to be answered
+func (this *commandStats) record(command protocol.Command, latency time.Duration) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	stat := this.get(command)
+	stat.calls++
+	stat.usec += int64(latency / time.Microsecond)
+	this.lock.Unlock()
+}
+
+//Records a command that was rejected before it could run
+func (this *commandStats) reject(command protocol.Command) {
+	if this == nil {
+		return
+	}
+	this.lock.Lock()
+	this.get(command).rejected++
+	this.lock.Unlock()
+}
+
+//Forgets every command's stats
+func (this *commandStats) reset() {
+	this.lock.Lock()
+	this.commands = make(map[string]*commandStat)
+	this.lock.Unlock()
+}
+
+//Gets a copy of every command's stats, keyed by command name
+func (this *commandStats) snapshot() map[string]commandStat {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	stats := make(map[string]commandStat, len(this.commands))
+	for name, stat := range this.commands {
+		stats[name] = *stat
+	}
+	return stats
+}
+
+//Records the queued commands once their replies have been written out, using the times that they were queued at
+func (this *Client) recordQueuedStats(commands []protocol.Command, queuedAt []time.Time) {
+	for i := 0; i < len(commands) && i < len(queuedAt); i++ {
+		this.commandStats.record(commands[i], time.Since(queuedAt[i]))
+	}
+}
+
+//Whether or not INFO is answered by rmux, instead of being sent to redis
+//Rmux's own sections are always answered locally.  When multiplexing, so is everything else
+func (this *RedisMultiplexer) IsInfoCommand(command protocol.Command) bool {
+	if !bytes.Equal(command.GetCommand(), protocol.INFO_COMMAND) {
+		return false
+	} else if this.multiplexing {
+		return true
+	}
+
+	args := command.GetArgs()
+	for _, arg := range args {
+		if !isLocalInfoSection(arg) {
+			return false
+		}
+	}
+	return len(args) > 0
+}
+
+func isLocalInfoSection(name []byte) bool {
+	for _, section := range infoSections {
+		if bytes.EqualFold(name, []byte(section.name)) {
+			return section.build != nil
+		}
+	}
+	return false
+}
+
+//Answers INFO with the requested sections, in redis's "key:value" format
+//Sections that rmux doesn't know are left out, the same way that redis leaves them out
+func (this *RedisMultiplexer) HandleInfoCommand(client *Client, command protocol.Command) error {
+	requested := make(map[string]bool)
+	for _, arg := range command.GetArgs() {
+		requested[string(bytes.ToLower(arg))] = true
+	}
+	everything := requested["all"] || requested["everything"]
+	if len(requested) == 0 {
+		requested["default"] = true
+	}
+
+	//The sections that come from redis are fetched from every pool at once, before anything is written out
+	var included []int
+	poolRequests := make(map[int][]*poolRequest)
+	var requests []*poolRequest
+	for i, section := range infoSections {
+		if !everything && !requested[section.name] && !(section.isDefault && requested["default"]) {
+			continue
+		} else if section.build == nil {
+			poolRequests[i] = infoRequests(client.HashRing, section.name)
+			requests = append(requests, poolRequests[i]...)
+		}
+		included = append(included, i)
+	}
+	executeBatches(batchRequests(client.HashRing, requests), client.DatabaseId)
+
+	var info bytes.Buffer
+	for _, i := range included {
+		if info.Len() > 0 {
+			info.WriteString("\r\n")
+		}
+		if infoSections[i].build != nil {
+			info.WriteString(infoSections[i].build(this))
+		} else {
+			info.WriteString(mergePoolInfo(infoSections[i].poolTitle, poolRequests[i]))
+		}
+	}
+
+	return client.WriteLine(bytes.TrimSuffix(bulkString([]byte(info.String())), protocol.REDIS_NEWLINE))
+}
+
+//Formats the given section of INFO
+func infoSection(title string, lines []string) string {
+	return "# " + title + "\r\n" + strings.Join(append(lines, ""), "\r\n")
+}
+
+func (this *RedisMultiplexer) rmuxInfo() string {
+	multiplexing := 0
+	if this.multiplexing {
+		multiplexing = 1
+	}
+	activeEndpoints := 0
+	for _, connectionPool := range this.ConnectionCluster {
+		if connectionPool.IsConnected() {
+			activeEndpoints++
+		}
+	}
+
+	var commands, rejected int64
+	for _, stat := range this.commandStats.snapshot() {
+		commands += stat.calls
+		rejected += stat.rejected
+	}
+
+	return infoSection("Rmux", []string{
+		"rmux_version:" + version,
+		"go_version:" + runtime.Version(),
+		fmt.Sprintf("process_id:%d", os.Getpid()),
+		fmt.Sprintf("uptime_in_seconds:%d", int(time.Since(this.started).Seconds())),
+		fmt.Sprintf("multiplexing:%d", multiplexing),
+		"role:master",
+		fmt.Sprintf("active_endpoints:%d", activeEndpoints),
+		fmt.Sprintf("total_endpoints:%d", len(this.ConnectionCluster)),
+		fmt.Sprintf("total_commands_processed:%d", commands),
+		fmt.Sprintf("total_rejected_commands:%d", rejected),
+	})
+}
+
+func (this *RedisMultiplexer) clientsInfo() string {
+	clients := this.clients.list()
+	pubsubClients, transactionClients := 0, 0
+	for _, client := range clients {
+		info := client.snapshot()
+		if info.isPubsub() {
+			pubsubClients++
+		} else if info.multi >= 0 {
+			transactionClients++
+		}
+	}
+
+	var blockedClients int32
+	for _, connectionPool := range this.ConnectionCluster {
+		blockedClients += atomic.LoadInt32(&connectionPool.BlockingCount)
+	}
+
+	return infoSection("Clients", []string{
+		fmt.Sprintf("connected_clients:%d", len(clients)),
+		fmt.Sprintf("blocked_clients:%d", blockedClients),
+		fmt.Sprintf("pubsub_clients:%d", pubsubClients),
+		fmt.Sprintf("clients_in_multi:%d", transactionClients),
+	})
+}
+
+func (this *RedisMultiplexer) poolsInfo() string {
+	lines := make([]string, 0, len(this.ConnectionCluster))
+	for i, connectionPool := range this.ConnectionCluster {
+		lines = append(lines, fmt.Sprintf("pool%d:endpoint=%s,status=%s,in_use=%d,idle=%d,capacity=%d,blocked=%d,waiting=%d,open=%d,reconnects=%d,reconnect_failures=%d,backoffs=%d,reaped=%d,expired=%d,acquire_waits=%d,acquire_wait_usec=%d,acquire_timeouts=%d,acquire_rejections=%d",
+			i, connectionPool.Endpoint, connectionPool.Status(), atomic.LoadInt32(&connectionPool.Count), connectionPool.IdleCount(),
+			connectionPool.Capacity(), atomic.LoadInt32(&connectionPool.BlockingCount), atomic.LoadInt32(&connectionPool.Waiters),
+			atomic.LoadInt64(&connectionPool.Stats.Open), atomic.LoadInt64(&connectionPool.Stats.Reconnects),
+			atomic.LoadInt64(&connectionPool.Stats.ReconnectFailures), atomic.LoadInt64(&connectionPool.Stats.Backoffs),
+			atomic.LoadInt64(&connectionPool.Stats.Reaped), atomic.LoadInt64(&connectionPool.Stats.Expired),
+			atomic.LoadInt64(&connectionPool.AcquireStats.Waits), atomic.LoadInt64(&connectionPool.AcquireStats.WaitMicroseconds),
+			atomic.LoadInt64(&connectionPool.AcquireStats.Timeouts), atomic.LoadInt64(&connectionPool.AcquireStats.Rejections)))
+	}
+	return infoSection("Pools", lines)
+}
+
+func (this *RedisMultiplexer) commandstatsInfo() string {
+	stats := this.commandStats.snapshot()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		stat := stats[name]
+		perCall := 0.0
+		if stat.calls > 0 {
+			perCall = float64(stat.usec) / float64(stat.calls)
+		}
+		lines = append(lines, fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f,rejected_calls=%d",
+			name, stat.calls, stat.usec, perCall, stat.rejected))
+	}
+	return infoSection("Commandstats", lines)
+}
@@ -0,0 +1,214 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"github.com/salesforce/rmux/connection"
+	"github.com/salesforce/rmux/protocol"
+	"strconv"
+	"strings"
+)
+
+//Builds an INFO request for the given section to every connection pool that is up, including those in maintenance
+func infoRequests(hashRing *connection.HashRing, section string) []*poolRequest {
+	var requests []*poolRequest
+	for _, connectionPool := range hashRing.GetConnectionPools() {
+		if connectionPool.IsReachable() {
+			command := protocol.NewMultibulkCommand(protocol.INFO_COMMAND, []byte(section))
+			requests = append(requests, &poolRequest{command: command, pool: connectionPool})
+		}
+	}
+	return requests
+}
+
+//Parts of the names of the numeric fields that describe a single redis server, such as its peak memory or its
+//fragmentation, rather than count something up.  Summing them across pools means nothing, so they are only shown under
+//each pool's own prefix
+var perServerInfoFields = []string{"_ratio", "_perc", "_peak", "maxmemory", "instantaneous_", "total_system_memory", "latest_fork_usec"}
+
+//One "key:value" line of an INFO section
+type infoField struct {
+	key   string
+	value string
+}
+
+//Merges one INFO section from every pool into a single section
+//Counters and memory totals are summed up, and each database's key counts are merged.  Numeric fields that describe a
+//single server are left out, and text fields are only kept if every pool agrees on them.  Each pool's own fields follow, prefixed by the pool's endpoint
+func mergePoolInfo(title string, requests []*poolRequest) string {
+	var poolFields [][]infoField
+	var poolLines []string
+	for _, request := range requests {
+		if request.err != nil || len(request.reply) == 0 || request.reply[0] != '$' {
+			continue
+		}
+
+		fields := parseInfoFields(bulkContents(request.reply))
+		poolFields = append(poolFields, fields)
+		prefix := strings.Replace(request.pool.Endpoint, ":", "-", -1) + "_"
+		for _, field := range fields {
+			poolLines = append(poolLines, prefix+field.key+":"+field.value)
+		}
+	}
+
+	var lines []string
+	for _, field := range mergeInfoFields(poolFields) {
+		lines = append(lines, field.key+":"+field.value)
+	}
+	return infoSection(title, append(lines, poolLines...))
+}
+
+//Parses the fields of an INFO reply, skipping its section titles and blank lines
+func parseInfoFields(info []byte) []infoField {
+	var fields []infoField
+	for _, line := range strings.Split(string(info), "\r\n") {
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if colon := strings.Index(line, ":"); colon > 0 {
+			fields = append(fields, infoField{line[:colon], line[colon+1:]})
+		}
+	}
+	return fields
+}
+
+//Merges the fields of every pool, in the order that they were first seen
+func mergeInfoFields(poolFields [][]infoField) []infoField {
+	var keys []string
+	values := make(map[string][]string)
+	for _, fields := range poolFields {
+		for _, field := range fields {
+			if _, ok := values[field.key]; !ok {
+				keys = append(keys, field.key)
+			}
+			values[field.key] = append(values[field.key], field.value)
+		}
+	}
+
+	merged := make([]infoField, 0, len(keys))
+	for _, key := range keys {
+		var value string
+		var ok bool
+		if strings.HasPrefix(key, "db") && strings.Contains(values[key][0], "keys=") {
+			value, ok = mergeKeyspace(values[key])
+		} else {
+			value, ok = mergeInfoValues(key, values[key])
+		}
+		if ok {
+			merged = append(merged, infoField{key, value})
+		}
+	}
+	return merged
+}
+
+//Sums up a field's numeric values, unless they describe a single server.  Text is only kept if every pool has the same
+//value
+func mergeInfoValues(key string, values []string) (string, bool) {
+	if strings.HasSuffix(key, "_human") {
+		// The text form of a single server's number
+		return "", false
+	}
+
+	sum := 0.0
+	isFloat := false
+	for _, value := range values {
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return sameInfoValue(values)
+		} else if isPerServerInfoField(key) {
+			return "", false
+		}
+		isFloat = isFloat || strings.ContainsAny(value, ".eE")
+		sum += number
+	}
+
+	if isFloat {
+		return strconv.FormatFloat(sum, 'f', 2, 64), true
+	}
+	return strconv.FormatInt(int64(sum), 10), true
+}
+
+func isPerServerInfoField(key string) bool {
+	for _, part := range perServerInfoFields {
+		if strings.Contains(key, part) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameInfoValue(values []string) (string, bool) {
+	for _, value := range values {
+		if value != values[0] {
+			return "", false
+		}
+	}
+	return values[0], true
+}
+
+//Merges a database's keyspace line from every pool, such as keys=10,expires=2,avg_ttl=3000
+//Counts are summed up, and avg_ttl is averaged out over the keys that expire
+func mergeKeyspace(values []string) (string, bool) {
+	var names []string
+	sums := make(map[string]int64)
+	var ttlTotal, expiresTotal int64
+	for _, value := range values {
+		counts := make(map[string]int64)
+		for _, pair := range strings.Split(value, ",") {
+			equals := strings.Index(pair, "=")
+			if equals < 0 {
+				return "", false
+			}
+			count, err := strconv.ParseInt(pair[equals+1:], 10, 64)
+			if err != nil {
+				return "", false
+			}
+
+			name := pair[:equals]
+			if _, ok := sums[name]; !ok {
+				names = append(names, name)
+			}
+			sums[name] += count
+			counts[name] = count
+		}
+		ttlTotal += counts["avg_ttl"] * counts["expires"]
+		expiresTotal += counts["expires"]
+	}
+
+	if expiresTotal > 0 {
+		sums["avg_ttl"] = ttlTotal / expiresTotal
+	}
+
+	var merged bytes.Buffer
+	for i, name := range names {
+		if i > 0 {
+			merged.WriteByte(',')
+		}
+		merged.WriteString(name + "=" + strconv.FormatInt(sums[name], 10))
+	}
+	return merged.String(), true
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"strings"
+	"testing"
+)
+
+func TestInfoSections(t *testing.T) {
+	recorder := &transactionRecorder{}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxInfoTest1.sock", recorder.handle)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxInfoTest2.sock", recorder.handle)
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxInfoTest.sock", "/tmp/rmuxInfoTest1.sock", "/tmp/rmuxInfoTest2.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	runClientCommand(t, server, client, "get", "a")
+	runClientCommand(t, server, client, "get", "b")
+	runClientCommand(t, server, client, "get")
+
+	output.Reset()
+	runClientCommand(t, server, client, "info")
+	info := string(bulkContents(output.Bytes()))
+	for _, expected := range []string{"# Rmux\r\n", "rmux_version:" + version + "\r\n", "multiplexing:1\r\n", "total_endpoints:2\r\n",
+		"total_commands_processed:2\r\n", "total_rejected_commands:1\r\n", "# Clients\r\n", "connected_clients:1\r\n", "# Pools\r\n",
+		"pool0:endpoint=/tmp/rmuxInfoTest1.sock,status=up,", "pool1:endpoint=/tmp/rmuxInfoTest2.sock,status=up,"} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Expected INFO to contain %q, got %q", expected, info)
+		}
+	}
+	if strings.Contains(info, "# Commandstats") {
+		t.Errorf("INFO should only include commandstats when asked for, got %q", info)
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "info", "COMMANDSTATS")
+	info = string(bulkContents(output.Bytes()))
+	if !strings.HasPrefix(info, "# Commandstats\r\ncmdstat_get:calls=2,") || !strings.Contains(info, ",rejected_calls=1\r\n") {
+		t.Errorf("Unexpected INFO commandstats, got %q", info)
+	}
+	if !strings.Contains(info, "cmdstat_info:calls=1,") {
+		t.Errorf("Expected INFO itself to be counted, got %q", info)
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "info", "latencystats")
+	if output.String() != "$0\r\n\r\n" {
+		t.Errorf("Sections that rmux doesn't know should be left out, got %q", output.String())
+	}
+
+	//Only the sections that come from redis are asked for
+	expected := "get a, get b, info memory, info stats, info keyspace, info memory, info stats, info keyspace"
+	if recorded := recorder.recorded(); recorded != expected {
+		t.Errorf("Expected redis to see %q, got %q", expected, recorded)
+	}
+}
+
+func TestInfoWithoutMultiplexing(t *testing.T) {
+	listener := StartMockRedisServer(t, "/tmp/rmuxInfoTest1.sock", func(command protocol.Command) string {
+		return bulkReply("redis_version:7.2.0\r\n")
+	})
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxInfoTest.sock", "/tmp/rmuxInfoTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+
+	//A plain INFO still goes to redis, but rmux's own sections are answered locally
+	runClientCommand(t, server, client, "info")
+	if expected := bulkReply("redis_version:7.2.0\r\n"); output.String() != expected {
+		t.Errorf("Expected INFO to reach redis.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "info", "rmux", "pools")
+	info := string(bulkContents(output.Bytes()))
+	if !strings.HasPrefix(info, "# Rmux\r\n") || !strings.Contains(info, "multiplexing:0\r\n") ||
+		!strings.Contains(info, "\r\n\r\n# Pools\r\npool0:endpoint=/tmp/rmuxInfoTest1.sock,") {
+		t.Errorf("Unexpected INFO rmux pools, got %q", info)
+	}
+}
+
+func TestCommandStatsOnlyCountsKnownCommands(t *testing.T) {
+	recorder := &transactionRecorder{}
+	listener := StartMockRedisServer(t, "/tmp/rmuxInfoTest1.sock", recorder.handle)
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxInfoTest.sock", "/tmp/rmuxInfoTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	client.Password = "secret"
+
+	//Nothing is counted until the client authenticates
+	runClientCommand(t, server, client, "get", "a")
+	runClientCommand(t, server, client, "madeup")
+	runClientCommand(t, server, client, "auth", "secret")
+
+	runClientCommand(t, server, client, "get", "a")
+	runClientCommand(t, server, client, "madeup1")
+	runClientCommand(t, server, client, "madeup2")
+	runClientCommand(t, server, client, "rmux", "version")
+
+	output.Reset()
+	runClientCommand(t, server, client, "info", "commandstats")
+	info := string(bulkContents(output.Bytes()))
+	for _, expected := range []string{"cmdstat_get:calls=1,", "cmdstat_auth:calls=1,", "cmdstat_rmux:calls=1,", "cmdstat_unknown:calls=0,usec=0,usec_per_call=0.00,rejected_calls=2\r\n"} {
+		if !strings.Contains(info, expected) {
+			t.Errorf("Expected INFO commandstats to contain %q, got %q", expected, info)
+		}
+	}
+	if strings.Contains(info, "madeup") {
+		t.Errorf("Unknown commands should be counted together, got %q", info)
+	}
+}
+
+func TestInfoAggregatesPools(t *testing.T) {
+	poolInfo := func(usedMemory, hits, keys string) func(protocol.Command) string {
+		return func(command protocol.Command) string {
+			switch string(command.GetFirstArg()) {
+			case "memory":
+				return bulkReply("# Memory\r\nused_memory:" + usedMemory + "\r\nused_memory_peak:2000\r\nmem_fragmentation_ratio:1.10\r\nmaxmemory:4096\r\nmaxmemory_policy:noeviction\r\n")
+			case "stats":
+				return bulkReply("# Stats\r\nkeyspace_hits:" + hits + "\r\nkeyspace_misses:1\r\ninstantaneous_ops_per_sec:3\r\n")
+			case "keyspace":
+				return bulkReply("# Keyspace\r\n" + keys)
+			}
+			return "-ERR unexpected\r\n"
+		}
+	}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxInfoTest1.sock", poolInfo("1000", "5", "db0:keys=10,expires=2,avg_ttl=1000\r\n"))
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxInfoTest2.sock", poolInfo("500", "7", "db0:keys=5,expires=2,avg_ttl=3000\r\ndb1:keys=1,expires=0,avg_ttl=0\r\n"))
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxInfoTest.sock", "/tmp/rmuxInfoTest1.sock", "/tmp/rmuxInfoTest2.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	runClientCommand(t, server, client, "info", "memory", "stats", "keyspace")
+	expected := "# Memory\r\n" +
+		"used_memory:1500\r\nmaxmemory_policy:noeviction\r\n" +
+		"/tmp/rmuxInfoTest1.sock_used_memory:1000\r\n/tmp/rmuxInfoTest1.sock_used_memory_peak:2000\r\n/tmp/rmuxInfoTest1.sock_mem_fragmentation_ratio:1.10\r\n" +
+		"/tmp/rmuxInfoTest1.sock_maxmemory:4096\r\n/tmp/rmuxInfoTest1.sock_maxmemory_policy:noeviction\r\n" +
+		"/tmp/rmuxInfoTest2.sock_used_memory:500\r\n/tmp/rmuxInfoTest2.sock_used_memory_peak:2000\r\n/tmp/rmuxInfoTest2.sock_mem_fragmentation_ratio:1.10\r\n" +
+		"/tmp/rmuxInfoTest2.sock_maxmemory:4096\r\n/tmp/rmuxInfoTest2.sock_maxmemory_policy:noeviction\r\n" +
+		"\r\n# Stats\r\n" +
+		"keyspace_hits:12\r\nkeyspace_misses:2\r\n" +
+		"/tmp/rmuxInfoTest1.sock_keyspace_hits:5\r\n/tmp/rmuxInfoTest1.sock_keyspace_misses:1\r\n/tmp/rmuxInfoTest1.sock_instantaneous_ops_per_sec:3\r\n" +
+		"/tmp/rmuxInfoTest2.sock_keyspace_hits:7\r\n/tmp/rmuxInfoTest2.sock_keyspace_misses:1\r\n/tmp/rmuxInfoTest2.sock_instantaneous_ops_per_sec:3\r\n" +
+		"\r\n# Keyspace\r\n" +
+		"db0:keys=15,expires=4,avg_ttl=2000\r\ndb1:keys=1,expires=0,avg_ttl=0\r\n" +
+		"/tmp/rmuxInfoTest1.sock_db0:keys=10,expires=2,avg_ttl=1000\r\n" +
+		"/tmp/rmuxInfoTest2.sock_db0:keys=5,expires=2,avg_ttl=3000\r\n/tmp/rmuxInfoTest2.sock_db1:keys=1,expires=0,avg_ttl=0\r\n"
+	if info := string(bulkContents(output.Bytes())); info != expected {
+		t.Errorf("Unexpected merged INFO.\r\nExpected %q\r\nGot      %q", expected, info)
+	}
+}
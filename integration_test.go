@@ -246,3 +246,15 @@ func TestLargeRequest(t *testing.T) {
 	checkResponse(t, cmd, expected)
 }
 
+
+func TestMuxMgetResponse(t *testing.T) {
+	cmd := makeCommand("set key1 a") + makeCommand("set key2 b") + makeCommand("mget key1 key3 key2")
+	expected := "+OK\r\n+OK\r\n*3\r\n$1\r\na\r\n$-1\r\n$1\r\nb\r\n"
+	checkMuxResponse(t, cmd, expected)
+}
+
+func TestMuxMultiKeyWriteResponse(t *testing.T) {
+	cmd := makeCommand("mset key1 a key2 b key3 c") + makeCommand("exists key1 key2 key4") + makeCommand("del key1 key2 key3")
+	expected := "+OK\r\n:2\r\n:3\r\n"
+	checkMuxResponse(t, cmd, expected)
+}
@@ -36,6 +36,13 @@ type PoolConfig struct {
 	Socket               string   `json:"socket"`
 	MaxProcesses         int      `json:"maxProcesses"`
 	PoolSize             int      `json:"poolSize"`
+	BlockingPoolSize     int      `json:"blockingPoolSize"`
+	PoolAcquireTimeout   int64    `json:"poolAcquireTimeout"`
+	PoolMaxWaiters       int      `json:"poolMaxWaiters"`
+	PoolMaxActive        int      `json:"poolMaxActive"`
+	PoolMinIdle          int      `json:"poolMinIdle"`
+	PoolIdleTimeout      int64    `json:"poolIdleTimeout"`
+	PoolMaxLifetime      int64    `json:"poolMaxLifetime"`
 	TcpConnections       []string `json:"tcpConnections"`
 	UnixConnections      []string `json:"unixConnections"`
 	LocalTimeout         int64      `json:"localTimeout"`
@@ -45,7 +52,25 @@ type PoolConfig struct {
 	RemoteReadTimeout    int64      `json:"remoteReadTimeout"`
 	RemoteWriteTimeout   int64      `json:"remoteWriteTimeout"`
 	RemoteConnectTimeout int64      `json:"remoteConnectTimeout"`
+	ReconnectBackoff     int64      `json:"reconnectBackoff"`
+	MaxReconnectBackoff  int64      `json:"maxReconnectBackoff"`
+	MaxConcurrentDials   int        `json:"maxConcurrentDials"`
 	Failover             bool       `json:"failover"`
+	NilOnPoolDown        bool       `json:"nilOnPoolDown"`
+	HashTags             bool       `json:"hashTags"`
+	AllowFlush           bool       `json:"allowFlush"`
+	Password             string     `json:"password"`
+	AdminPassword        string     `json:"adminPassword"`
+	MaintenanceFile      string     `json:"maintenanceFile"`
+	RemoteUsername       string     `json:"remoteUsername"`
+	RemotePassword       string     `json:"remotePassword"`
+	RemoteCredentials    map[string]CredentialsConfig `json:"remoteCredentials"`
+}
+
+//The credentials for a single redis server.  The username is only needed for ACL users
+type CredentialsConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 func ReadConfigFromFile(configFile string) ([]PoolConfig, error) {
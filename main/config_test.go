@@ -147,6 +147,40 @@ func TestParseConfigJson_Json2(test *testing.T) {
 	}
 }
 
+var jsonCredentials = []byte(`
+[{
+	"socket": "/tmp/rmux-redis1.sock",
+	"tcpConnections": [ "localhost:8001", "localhost:8002" ],
+	"password": "local",
+	"remotePassword": "shared",
+	"remoteCredentials": {
+		"localhost:8002": { "username": "rmux", "password": "secret" }
+	}
+}]
+`)
+
+func TestParseConfigJson_Credentials(test *testing.T) {
+	config, err := ParseConfigJson(jsonCredentials)
+	if err != nil {
+		test.Fatalf("Should not have errored parsing jsonCredentials")
+	}
+
+	expects := []PoolConfig{{
+		Socket:         "/tmp/rmux-redis1.sock",
+		TcpConnections: []string{"localhost:8001", "localhost:8002"},
+
+		Password:       "local",
+		RemotePassword: "shared",
+		RemoteCredentials: map[string]CredentialsConfig{
+			"localhost:8002": {Username: "rmux", Password: "secret"},
+		},
+	}}
+
+	if !reflect.DeepEqual(expects, config) {
+		test.Errorf("Did not parse configuration string as expected")
+	}
+}
+
 var json3 = []byte(`
 [{
 	"host": "localhost",
@@ -30,6 +30,7 @@ import (
 	"flag"
 	"fmt"
 	"github.com/salesforce/rmux"
+	"github.com/salesforce/rmux/connection"
 	. "github.com/salesforce/rmux/log"
 	"net"
 	"os"
@@ -50,6 +51,13 @@ var port = flag.Int("port", 6379, "The port to listen for incoming connections o
 var socket = flag.String("socket", "", "The socket to listen for incoming connections on.  If this is provided, host and port are ignored")
 var maxProcesses = flag.Int("maxProcesses", 0, "The number of processes to use.  If this is not defined, go's default is used.")
 var poolSize = flag.Int("poolSize", DEFAULT_POOL_SIZE, "The size of the connection pools to use")
+var blockingPoolSize = flag.Int("blockingPoolSize", 0, "The number of clients that can be blocked on each connection pool at once.  Defaults to poolSize")
+var poolAcquireTimeout = flag.Int64("poolAcquireTimeout", 0, "How long a client waits for a free connection in a pool in milliseconds, before it gets an error.  Waits forever if 0")
+var poolMaxWaiters = flag.Int("poolMaxWaiters", 0, "The number of clients that can wait for a free connection in each pool at once.  Unlimited if 0")
+var poolMaxActive = flag.Int("poolMaxActive", 0, "The most connections that each pool has open at once, blocking or not.  Unlimited if 0")
+var poolMinIdle = flag.Int("poolMinIdle", 0, "The fewest idle connections that each pool keeps open")
+var poolIdleTimeout = flag.Int64("poolIdleTimeout", 0, "How long an idle connection goes unused in milliseconds, before it is closed.  Never closed if 0")
+var poolMaxLifetime = flag.Int64("poolMaxLifetime", 0, "How long a connection stays open in milliseconds, before it is replaced.  Forever if 0")
 var tcpConnections = flag.String("tcpConnections", "localhost:6380 localhost:6381", "TCP connections (destination redis servers) to multiplex over")
 var unixConnections = flag.String("unixConnections", "", "Unix connections (destination redis servers) to multiplex over")
 var localTimeout = flag.Int64("localTimeout", 0, "Timeout to set locally in milliseconds (read+write)")
@@ -59,12 +67,23 @@ var remoteTimeout = flag.Int64("remoteTimeout", 0, "Timeout to set for remote re
 var remoteReadTimeout = flag.Int64("remoteReadTimeout", 0, "Timeout to set for remote redises (read)")
 var remoteWriteTimeout = flag.Int64("remoteWriteTimeout", 0, "Timeout to set for remote redises (write)")
 var remoteConnectTimeout = flag.Int64("remoteConnectTimeout", 0, "Timeout to set for remote redises (connect)")
+var reconnectBackoff = flag.Int64("reconnectBackoff", 0, "How long a pool waits to reconnect to its remote redis in milliseconds, after its first failed dial.  Doubles with every failure after it.  Defaults to 50, and -1 turns it off")
+var maxReconnectBackoff = flag.Int64("maxReconnectBackoff", 0, "The longest that a pool waits to reconnect to its remote redis in milliseconds.  Defaults to 5000")
+var maxConcurrentDials = flag.Int("maxConcurrentDials", 0, "The number of each pool's connections that can dial its remote redis at once.  Unlimited if 0")
 var cpuProfile = flag.String("cpuProfile", "", "Direct CPU Profile to target file")
 var configFile = flag.String("config", "", "Configuration file (JSON)")
 var doDebug = flag.Bool("debug", false, "Debug mode")
 var graphiteServer = flag.String("graphite", "", "Graphite statsd endpoint")
 var doTiming = flag.Bool("timing", false, "Send command timings to graphite")
 var failover = flag.Bool("failover", false, "Failover to another connection pool if target pool is down in mux mode")
+var nilOnPoolDown = flag.Bool("nilOnPoolDown", false, "Return nil for MGET keys whose connection pool is down, instead of failing the whole command in mux mode")
+var allowFlush = flag.Bool("allowFlush", false, "Send FLUSHDB and FLUSHALL to every connection pool in mux mode, instead of rejecting them")
+var hashTags = flag.Bool("hashTags", false, "Only hash the {...} part of keys that have one, so related keys land on the same pool in mux mode")
+var password = flag.String("password", "", "The password that clients must AUTH with before running any other command")
+var maintenanceFile = flag.String("maintenanceFile", "", "A file listing the endpoints to keep in maintenance.  Read on startup and on every SIGUSR1")
+var adminPassword = flag.String("adminPassword", "", "The password that clients must RMUX AUTH with before running the RMUX subcommands that change the server")
+var remoteUsername = flag.String("remoteUsername", "", "The ACL user to authenticate to remote redises as")
+var remotePassword = flag.String("remotePassword", "", "The password to authenticate to remote redises with")
 var useSyslog = flag.Bool("useSyslog", true, "If true, outputs to syslog as well as stdout")
 
 func main() {
@@ -139,6 +158,26 @@ func configureFromArgs() ([]PoolConfig, error) {
 		PoolSize:     *poolSize,
 		Failover:     *failover,
 
+		BlockingPoolSize: *blockingPoolSize,
+
+		PoolAcquireTimeout: *poolAcquireTimeout,
+		PoolMaxWaiters:     *poolMaxWaiters,
+		PoolMaxActive:      *poolMaxActive,
+		PoolMinIdle:        *poolMinIdle,
+		PoolIdleTimeout:    *poolIdleTimeout,
+		PoolMaxLifetime:    *poolMaxLifetime,
+
+		NilOnPoolDown: *nilOnPoolDown,
+		HashTags:      *hashTags,
+		AllowFlush:    *allowFlush,
+
+		Password:       *password,
+		AdminPassword:  *adminPassword,
+
+		MaintenanceFile: *maintenanceFile,
+		RemoteUsername: *remoteUsername,
+		RemotePassword: *remotePassword,
+
 		TcpConnections:  arrTcpConnections,
 		UnixConnections: arrUnixConnections,
 
@@ -150,6 +189,10 @@ func configureFromArgs() ([]PoolConfig, error) {
 		RemoteReadTimeout:    *remoteReadTimeout,
 		RemoteWriteTimeout:   *remoteWriteTimeout,
 		RemoteConnectTimeout: *remoteConnectTimeout,
+
+		ReconnectBackoff:    *reconnectBackoff,
+		MaxReconnectBackoff: *maxReconnectBackoff,
+		MaxConcurrentDials:  *maxConcurrentDials,
 	}}
 
 	return config, nil
@@ -200,6 +243,22 @@ func createInstances(configs []PoolConfig) (rmuxInstances []*rmux.RedisMultiplex
 		}
 
 		rmuxInstance.Failover = config.Failover
+		rmuxInstance.NilOnPoolDown = config.NilOnPoolDown
+		rmuxInstance.HashTags = config.HashTags
+		rmuxInstance.AllowFlush = config.AllowFlush
+		rmuxInstance.BlockingPoolSize = config.BlockingPoolSize
+		rmuxInstance.PoolMaxWaiters = config.PoolMaxWaiters
+		rmuxInstance.PoolMaxActive = config.PoolMaxActive
+		rmuxInstance.PoolMinIdle = config.PoolMinIdle
+		rmuxInstance.MaxConcurrentDials = config.MaxConcurrentDials
+		rmuxInstance.Password = config.Password
+		rmuxInstance.AdminPassword = config.AdminPassword
+		rmuxInstance.MaintenanceFile = config.MaintenanceFile
+		rmuxInstance.EndpointCredentials = connection.Credentials{Username: config.RemoteUsername, Password: config.RemotePassword}
+		rmuxInstance.CredentialsByEndpoint = make(map[string]connection.Credentials)
+		for endpoint, credentials := range config.RemoteCredentials {
+			rmuxInstance.CredentialsByEndpoint[endpoint] = connection.Credentials{Username: credentials.Username, Password: credentials.Password}
+		}
 
 		if config.LocalTimeout != 0 {
 			timeout := time.Duration(config.LocalTimeout) * time.Millisecond
@@ -220,6 +279,24 @@ func createInstances(configs []PoolConfig) (rmuxInstances []*rmux.RedisMultiplex
 			Info("Setting local client write timeout to: %s", timeout)
 		}
 
+		if config.PoolAcquireTimeout != 0 {
+			timeout := time.Duration(config.PoolAcquireTimeout) * time.Millisecond
+			rmuxInstance.PoolAcquireTimeout = timeout
+			Info("Setting pool acquire timeout to: %s", timeout)
+		}
+
+		if config.PoolIdleTimeout != 0 {
+			timeout := time.Duration(config.PoolIdleTimeout) * time.Millisecond
+			rmuxInstance.PoolIdleTimeout = timeout
+			Info("Setting pool idle timeout to: %s", timeout)
+		}
+
+		if config.PoolMaxLifetime != 0 {
+			lifetime := time.Duration(config.PoolMaxLifetime) * time.Millisecond
+			rmuxInstance.PoolMaxLifetime = lifetime
+			Info("Setting pool connection max lifetime to: %s", lifetime)
+		}
+
 		if config.RemoteTimeout != 0 {
 			duration := time.Duration(config.RemoteTimeout) * time.Millisecond
 			rmuxInstance.EndpointConnectTimeout = duration
@@ -246,6 +323,31 @@ func createInstances(configs []PoolConfig) (rmuxInstances []*rmux.RedisMultiplex
 			Info("Setting remote redis write timeout to: %s", duration)
 		}
 
+		if config.ReconnectBackoff == -1 {
+			rmuxInstance.ReconnectBackoff = 0
+			Info("Turning off the remote redis reconnect backoff")
+		} else if config.ReconnectBackoff != 0 {
+			backoff := time.Duration(config.ReconnectBackoff) * time.Millisecond
+			rmuxInstance.ReconnectBackoff = backoff
+			Info("Setting remote redis reconnect backoff to: %s", backoff)
+		}
+
+		if config.MaxReconnectBackoff != 0 {
+			backoff := time.Duration(config.MaxReconnectBackoff) * time.Millisecond
+			rmuxInstance.MaxReconnectBackoff = backoff
+			Info("Setting remote redis max reconnect backoff to: %s", backoff)
+		}
+
+		if rmuxInstance.ReconnectBackoff < 0 || rmuxInstance.ReconnectBackoff > rmuxInstance.MaxReconnectBackoff {
+			err = errors.New("reconnectBackoff must be -1 to turn it off, or positive and no longer than maxReconnectBackoff")
+			return
+		}
+
+		if config.PoolMaxActive < 0 || (config.PoolMaxActive > 0 && config.PoolMinIdle > config.PoolMaxActive) {
+			err = errors.New("poolMaxActive must be positive, and no fewer than poolMinIdle, or 0 for no limit")
+			return
+		}
+
 		if len(config.TcpConnections) > 0 {
 			for _, tcpConnection := range config.TcpConnections {
 				Info("Adding tcp (destination) connection: %s", tcpConnection)
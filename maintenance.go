@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bufio"
+	. "github.com/salesforce/rmux/log"
+	"github.com/salesforce/rmux/protocol"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+//The endpoints that are in maintenance, kept apart by what put them there, so that re-reading the maintenance file
+//doesn't bring back a pool that was drained with RMUX DRAIN, and RMUX ENABLE doesn't bring back one that the file lists
+type maintenanceState struct {
+	lock sync.Mutex
+	//Drained with RMUX DRAIN
+	drained map[string]bool
+	//Listed in the maintenance file, as of the last time it was read
+	listed map[string]bool
+}
+
+func newMaintenanceState() *maintenanceState {
+	return &maintenanceState{drained: make(map[string]bool), listed: make(map[string]bool)}
+}
+
+//Puts every connection pool for the given endpoint in maintenance, or takes them out of it, the way RMUX DRAIN and
+//RMUX ENABLE do.  While a pool is in maintenance its keys fail over to the next pool in the ring, and the commands that
+//are already running on it are left to finish
+func (this *RedisMultiplexer) SetPoolMaintenance(endpoint string, maintenance bool) error {
+	found := false
+	for _, connectionPool := range this.ConnectionCluster {
+		if connectionPool.Endpoint == endpoint {
+			found = true
+		}
+	}
+	if !found {
+		return protocol.NewRecoverableError("No connection pool for endpoint '" + endpoint + "'")
+	}
+
+	this.maintenance.lock.Lock()
+	defer this.maintenance.lock.Unlock()
+	if maintenance {
+		this.maintenance.drained[endpoint] = true
+	} else {
+		delete(this.maintenance.drained, endpoint)
+	}
+	this.applyMaintenance()
+
+	if !maintenance && this.maintenance.listed[endpoint] {
+		return protocol.NewRecoverableError("'" + endpoint + "' stays in maintenance while it is listed in the maintenance file")
+	}
+	return nil
+}
+
+//Puts the pools whose endpoints are listed in MaintenanceFile in maintenance, and takes the pools that it no longer
+//lists out of it, unless they were drained with RMUX DRAIN.  The file lists one endpoint per line.  Blank lines and
+//lines starting with # are skipped, and a missing file lists nothing
+func (this *RedisMultiplexer) ApplyMaintenanceFile() error {
+	endpoints := make(map[string]bool)
+	file, err := os.Open(this.MaintenanceFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err == nil {
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line != "" && !strings.HasPrefix(line, "#") {
+				endpoints[line] = true
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+
+	this.maintenance.lock.Lock()
+	defer this.maintenance.lock.Unlock()
+	this.maintenance.listed = endpoints
+	this.applyMaintenance()
+	return nil
+}
+
+//Puts the pools that either RMUX DRAIN or the maintenance file asked for in maintenance, and takes the rest out of it
+//Since that moves channels between pools, the subscriptions on the pools that lost channels are dropped
+//Must be called with the maintenance lock held
+func (this *RedisMultiplexer) applyMaintenance() {
+	changed := false
+	for _, connectionPool := range this.ConnectionCluster {
+		maintenance := this.maintenance.drained[connectionPool.Endpoint] || this.maintenance.listed[connectionPool.Endpoint]
+		if maintenance != connectionPool.InMaintenance() {
+			Info("Set maintenance to %t for %s", maintenance, connectionPool.Endpoint)
+			connectionPool.SetMaintenance(maintenance)
+			changed = true
+		}
+	}
+
+	if changed && this.multiplexing {
+		this.subscriptionHub.rehash(this.HashRing)
+	}
+}
+
+//Re-reads MaintenanceFile every time the process gets a SIGUSR1, until the server stops
+func (this *RedisMultiplexer) handleMaintenanceSignals() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-c:
+			if err := this.ApplyMaintenanceFile(); err != nil {
+				Error("Failed to read the maintenance file %s: %s", this.MaintenanceFile, err)
+			}
+		case <-this.stopped:
+			return
+		}
+	}
+}
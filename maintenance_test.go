@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPoolMaintenance(t *testing.T) {
+	recorder := &transactionRecorder{}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxMaintenanceTest1.sock", recorder.handle)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxMaintenanceTest2.sock", recorder.handle)
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxMaintenanceTest.sock", "/tmp/rmuxMaintenanceTest1.sock", "/tmp/rmuxMaintenanceTest2.sock")
+	defer server.Listener.Close()
+	server.AdminPassword = "admin"
+
+	key, _ := keysOnDifferentPools(t, server)
+	client, output := newTestClient(server)
+	runClientCommand(t, server, client, "rmux", "auth", "admin")
+
+	//The first pool's keys go to the second pool while it is drained
+	output.Reset()
+	runClientCommand(t, server, client, "rmux", "drain", "/tmp/rmuxMaintenanceTest1.sock")
+	runClientCommand(t, server, client, "get", key)
+	if expected := "+OK\r\n" + bulkReply("value"); output.String() != expected {
+		t.Errorf("Expected the drained pool's key to fail over.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	if pool, _ := server.HashRing.GetConnectionPoolForKey([]byte(key)); pool != server.ConnectionCluster[1] {
+		t.Errorf("Expected %s to be served by the second pool", key)
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "info", "pools")
+	if info := string(bulkContents(output.Bytes())); !strings.Contains(info, "pool0:endpoint=/tmp/rmuxMaintenanceTest1.sock,status=maintenance,") {
+		t.Errorf("Expected INFO to show the pool in maintenance, got %q", info)
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "rmux", "enable", "/tmp/nowhere.sock")
+	if expected := "-ERR No connection pool for endpoint '/tmp/nowhere.sock'\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply to enabling an unknown pool.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	runClientCommand(t, server, client, "rmux", "enable", "/tmp/rmuxMaintenanceTest1.sock")
+	if server.ConnectionCluster[0].InMaintenance() {
+		t.Errorf("The first pool should be out of maintenance")
+	}
+
+	//The maintenance file puts the pools it lists in maintenance, and leaves the drained ones alone
+	file, err := ioutil.TempFile("", "rmuxMaintenance")
+	if err != nil {
+		t.Fatalf("Error creating the maintenance file: %s", err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("# Patching\n/tmp/rmuxMaintenanceTest2.sock\n\n")
+	file.Close()
+
+	runClientCommand(t, server, client, "rmux", "drain", "/tmp/rmuxMaintenanceTest1.sock")
+	server.MaintenanceFile = file.Name()
+	if err := server.ApplyMaintenanceFile(); err != nil {
+		t.Fatalf("Error applying the maintenance file: %s", err)
+	}
+	if !server.ConnectionCluster[0].InMaintenance() || !server.ConnectionCluster[1].InMaintenance() {
+		t.Errorf("Expected the drained pool and the listed pool to be in maintenance")
+	}
+
+	//A pool the file lists can't be enabled until the file stops listing it
+	output.Reset()
+	runClientCommand(t, server, client, "rmux", "enable", "/tmp/rmuxMaintenanceTest2.sock")
+	if expected := "-ERR '/tmp/rmuxMaintenanceTest2.sock' stays in maintenance while it is listed in the maintenance file\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply to enabling a listed pool.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	if !server.ConnectionCluster[1].InMaintenance() {
+		t.Errorf("The listed pool should stay in maintenance")
+	}
+
+	os.Remove(file.Name())
+	if err := server.ApplyMaintenanceFile(); err != nil || server.ConnectionCluster[1].InMaintenance() {
+		t.Errorf("A missing maintenance file should take the listed pools out of maintenance, got %v", err)
+	}
+	if !server.ConnectionCluster[0].InMaintenance() {
+		t.Errorf("Re-reading the maintenance file shouldn't enable a drained pool")
+	}
+}
+
+func TestBroadcastReachesPoolsInMaintenance(t *testing.T) {
+	handler := func(command protocol.Command) string {
+		switch string(command.GetCommand()) {
+		case "dbsize":
+			return ":2\r\n"
+		case "keys":
+			return "*1\r\n" + bulkReply("key")
+		case "scan":
+			return "*2\r\n" + bulkReply("0") + "*1\r\n" + bulkReply("key")
+		}
+		return "+OK\r\n"
+	}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxMaintenanceTest1.sock", handler)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxMaintenanceTest2.sock", handler)
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxMaintenanceTest.sock", "/tmp/rmuxMaintenanceTest1.sock", "/tmp/rmuxMaintenanceTest2.sock")
+	defer server.Listener.Close()
+	if err := server.SetPoolMaintenance("/tmp/rmuxMaintenanceTest1.sock", true); err != nil {
+		t.Fatalf("Error draining the first pool: %s", err)
+	}
+
+	//The drained redis is still up, and still holds its keys
+	testData := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"dbsize"}, ":4\r\n"},
+		{[]string{"keys", "*"}, "*2\r\n" + bulkReply("key") + bulkReply("key")},
+		{[]string{"scan", "0"}, "*2\r\n" + bulkReply("1") + "*1\r\n" + bulkReply("key")},
+	}
+	for _, data := range testData {
+		client, output := newTestClient(server)
+		runClientCommand(t, server, client, data.args...)
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v while a pool is drained.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+	}
+}
+
+func TestSubscriptionsMoveAcrossDrain(t *testing.T) {
+	published := make(chan string, 4)
+	handler := func(pool string) func(protocol.Command) string {
+		subscribe := pubsubHandler(pool)
+		return func(command protocol.Command) string {
+			if string(command.GetCommand()) == "publish" {
+				published <- pool
+				return ":1\r\n"
+			}
+			return subscribe(command)
+		}
+	}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxMaintenanceTest1.sock", handler("pool1"))
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxMaintenanceTest2.sock", handler("pool2"))
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxMaintenanceTest.sock", "/tmp/rmuxMaintenanceTest1.sock", "/tmp/rmuxMaintenanceTest2.sock")
+	defer server.Listener.Close()
+
+	channel, _ := keysOnDifferentPools(t, server)
+	subscriber, output := newTestClient(server)
+	defer subscriber.closeSubscriptions()
+	publisher, _ := newTestClient(server)
+
+	//Subscribes, and checks that a publish on the channel lands on the same redis server as the subscription
+	subscribeAndPublish := func(pool string) {
+		output.Reset()
+		runClientCommand(t, server, subscriber, "subscribe", channel)
+		receivePushes(t, subscriber, 1)
+		if message := "*3\r\n" + bulkReply("message") + bulkReply(channel) + bulkReply(pool); !strings.Contains(output.String(), message) {
+			t.Errorf("Expected %s to be subscribed on %s, got %q", channel, pool, output.String())
+		}
+		runClientCommand(t, server, publisher, "publish", channel, "hello")
+		if publishedPool := <-published; publishedPool != pool {
+			t.Errorf("Expected %s to be published on %s, where it is subscribed, but it went to %s", channel, pool, publishedPool)
+		}
+	}
+	//Checks that the subscriber was told that its subscriptions are gone
+	expectDropped := func(action string) {
+		select {
+		case item := <-subscriber.PushChannel:
+			if err := subscriber.WritePush(item); err != ERR_CONNECTION_DOWN {
+				t.Errorf("Expected the subscriber to be told its subscriptions are gone after %s, got %v", action, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for the subscriber to be told its subscriptions are gone after %s", action)
+		}
+		if subscriber.IsSubscribed() {
+			t.Errorf("The subscriber should have left subscriber mode after %s", action)
+		}
+	}
+
+	subscribeAndPublish("pool1")
+
+	if err := server.SetPoolMaintenance("/tmp/rmuxMaintenanceTest1.sock", true); err != nil {
+		t.Fatalf("Error draining the first pool: %s", err)
+	}
+	expectDropped("the drain")
+	subscribeAndPublish("pool2")
+
+	if err := server.SetPoolMaintenance("/tmp/rmuxMaintenanceTest1.sock", false); err != nil {
+		t.Fatalf("Error enabling the first pool: %s", err)
+	}
+	expectDropped("the enable")
+	subscribeAndPublish("pool1")
+}
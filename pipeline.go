@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/connection"
+	"github.com/salesforce/rmux/graphite"
+	. "github.com/salesforce/rmux/log"
+	"github.com/salesforce/rmux/protocol"
+	"sync"
+	"time"
+)
+
+//A single command that is sent to a connection pool, along with the reply it got back
+type poolRequest struct {
+	command protocol.Command
+	//The pool to send the command to. If nil, the pool is picked by hashing the command's key
+	pool  *connection.ConnectionPool
+	reply []byte
+	err   error
+}
+
+//The requests from one client pipeline that hash to the same connection pool, in the order they were queued
+type poolBatch struct {
+	pool     *connection.ConnectionPool
+	requests []*poolRequest
+}
+
+//Splits the given requests up by the connection pool that they are sent to
+//Requests that can not be placed on a pool are failed with ERR_CONNECTION_DOWN
+func batchRequests(hashRing *connection.HashRing, requests []*poolRequest) []*poolBatch {
+	batches := make([]*poolBatch, 0, len(hashRing.ConnectionPools))
+	batchesByPool := make(map[*connection.ConnectionPool]*poolBatch)
+
+	for _, request := range requests {
+		connectionPool := request.pool
+		if connectionPool == nil {
+			var err error
+			connectionPool, err = hashRing.GetConnectionPool(request.command)
+			if err != nil {
+				Error("Failed to retrieve a connection pool from the hashring")
+				request.err = ERR_CONNECTION_DOWN
+				continue
+			}
+		}
+
+		batch, ok := batchesByPool[connectionPool]
+		if !ok {
+			batch = &poolBatch{pool: connectionPool}
+			batchesByPool[connectionPool] = batch
+			batches = append(batches, batch)
+		}
+		batch.requests = append(batch.requests, request)
+	}
+
+	return batches
+}
+
+//Runs every batch against its own connection pool at the same time, and waits for all of them to finish
+func executeBatches(batches []*poolBatch, databaseId int) {
+	if len(batches) == 1 {
+		batches[0].execute(databaseId)
+		return
+	}
+
+	var waitGroup sync.WaitGroup
+	for _, batch := range batches {
+		waitGroup.Add(1)
+		go func(batch *poolBatch) {
+			defer waitGroup.Done()
+			batch.execute(databaseId)
+		}(batch)
+	}
+	waitGroup.Wait()
+}
+
+//Writes all of the batch's commands to a single connection from its pool, and reads back a reply for each of them
+//Any request that does not get a reply is given an error instead
+func (this *poolBatch) execute(databaseId int) {
+	redisConn, err := this.pool.GetConnection()
+	if err != nil {
+		Error("Failed to retrieve an active connection from the provided connection pool")
+		this.fail(0, acquireError(err))
+		return
+	}
+	defer this.pool.RecycleRemoteConnection(redisConn)
+
+	commands := make([]protocol.Command, len(this.requests))
+	for i, request := range this.requests {
+		commands[i] = request.command
+	}
+
+	if err := sendCommands(redisConn, databaseId, commands); err != nil {
+		this.fail(0, ERR_CONNECTION_DOWN)
+		return
+	}
+
+	replies, err := protocol.ReadServerResponses(redisConn.Reader, len(this.requests))
+	for i, reply := range replies {
+		this.requests[i].reply = reply
+	}
+
+	if err != nil {
+		Error("Error when reading redis responses for a pipeline: %s. Disconnecting the connection.", err)
+		redisConn.Disconnect()
+		this.fail(len(replies), ERR_CONNECTION_DOWN)
+	}
+}
+
+//Gets the error that a client is given when a connection couldn't be taken from a pool
+//Clients are told when the pool is busy, rather than down, so that they know to back off instead of failing over
+func acquireError(err error) error {
+	if err == connection.ERR_ACQUIRE_TIMEOUT || err == connection.ERR_TOO_MANY_WAITERS {
+		return err
+	}
+	return ERR_CONNECTION_DOWN
+}
+
+//Fails every request in the batch from the given offset onwards
+func (this *poolBatch) fail(offset int, err error) {
+	for _, request := range this.requests[offset:] {
+		request.err = err
+	}
+}
+
+//Selects the given database on the connection if needed, then writes and flushes the commands to it
+//The connection is disconnected if anything goes wrong, so that it gets re-established the next time it is used
+func sendCommands(redisConn *connection.Connection, databaseId int, commands []protocol.Command) error {
+	if redisConn.DatabaseId != databaseId {
+		if err := redisConn.SelectDatabase(databaseId); err != nil {
+			// Disconnect the current connection if selecting failed, will auto-reconnect this connection holder when queried later
+			redisConn.Disconnect()
+			return err
+		}
+	}
+
+	startWrite := time.Now()
+
+	for _, command := range commands {
+		_, err := redisConn.Writer.Write(command.GetBuffer())
+		if err != nil {
+			Error("Error when writing to server: %s. Disconnecting the connection.", err)
+			redisConn.Disconnect()
+			return err
+		}
+	}
+
+	for redisConn.Writer.Buffered() > 0 {
+		err := redisConn.Writer.Flush()
+		if err != nil {
+			Error("Error when flushing to server: %s. Disconnecting the connection.", err)
+			redisConn.Disconnect()
+			return err
+		}
+	}
+
+	graphite.Timing("redis_write", time.Now().Sub(startWrite))
+
+	return nil
+}
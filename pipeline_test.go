@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"fmt"
+	"github.com/salesforce/rmux/connection"
+	"github.com/salesforce/rmux/protocol"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMultiplexedPipelineKeepsOrder(t *testing.T) {
+	var served [2]int32
+	for i, sock := range []string{"/tmp/rmuxPipelineTest1.sock", "/tmp/rmuxPipelineTest2.sock"} {
+		i := i
+		listener := StartMockRedisServer(t, sock, func(command protocol.Command) string {
+			atomic.AddInt32(&served[i], 1)
+			return bulkReply(string(command.GetFirstArg()))
+		})
+		defer listener.Close()
+	}
+
+	server := newTestMultiplexer(t, "/tmp/rmuxPipelineTest.sock", "/tmp/rmuxPipelineTest1.sock", "/tmp/rmuxPipelineTest2.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+
+	expected := ""
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key%d", i)
+		command, err := protocol.ParseCommand([]byte(makeTestCommand("get", key)))
+		if err != nil {
+			t.Fatalf("Error parsing command: %s", err)
+		}
+		client.Queue(command)
+		expected += bulkReply(key)
+	}
+
+	if err := client.FlushRedisAndRespond(); err != nil {
+		t.Fatalf("Error flushing pipeline: %s", err)
+	}
+
+	if output.String() != expected {
+		t.Errorf("Pipeline replies came back out of order.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+
+	if served[0] == 0 || served[1] == 0 {
+		t.Errorf("Expected the pipeline to be split over both pools, got %d and %d commands", served[0], served[1])
+	}
+
+	if client.HasQueued() {
+		t.Errorf("Client should not have anything queued after a flush")
+	}
+}
+
+func TestMultiplexedPipelineWithDownPool(t *testing.T) {
+	listener := StartMockRedisServer(t, "/tmp/rmuxPipelineTest1.sock", func(command protocol.Command) string {
+		return bulkReply(string(command.GetFirstArg()))
+	})
+	defer listener.Close()
+
+	// Nothing is listening on the second socket
+	server := newTestMultiplexer(t, "/tmp/rmuxPipelineTest.sock", "/tmp/rmuxPipelineTest1.sock", "/tmp/rmuxPipelineTest2.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+
+	expected := ""
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%d", i)
+		command, _ := protocol.ParseCommand([]byte(makeTestCommand("get", key)))
+		client.Queue(command)
+
+		if pool, err := server.HashRing.GetConnectionPool(command); err == nil && pool == server.ConnectionCluster[0] {
+			expected += bulkReply(key)
+		} else {
+			expected += "-ERR " + ERR_CONNECTION_DOWN.Error() + "\r\n"
+		}
+	}
+
+	client.FlushRedisAndRespond()
+
+	if output.String() != expected {
+		t.Errorf("Unexpected pipeline replies.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+}
+
+func TestPipelineWithBusyPool(t *testing.T) {
+	handler := func(command protocol.Command) string {
+		return bulkReply(string(command.GetFirstArg()))
+	}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxPipelineTest1.sock", handler)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxPipelineTest2.sock", handler)
+	defer listener2.Close()
+
+	testData := []struct {
+		endpoints []string
+	}{
+		{[]string{"/tmp/rmuxPipelineTest1.sock"}},
+		{[]string{"/tmp/rmuxPipelineTest1.sock", "/tmp/rmuxPipelineTest2.sock"}},
+	}
+
+	for _, data := range testData {
+		server := newTestMultiplexer(t, "/tmp/rmuxPipelineTest.sock", data.endpoints...)
+
+		// Every connection of the first pool is taken, so its commands give up waiting for one
+		busyPool := server.ConnectionCluster[0]
+		busyPool.AcquireTimeout = 10 * time.Millisecond
+		for i := 0; i < busyPool.Capacity(); i++ {
+			redisConn, err := busyPool.GetConnection()
+			if err != nil {
+				t.Fatalf("Failed to take a connection from the pool: %s", err)
+			}
+			defer busyPool.RecycleRemoteConnection(redisConn)
+		}
+
+		client, output := newTestClient(server)
+
+		expected := ""
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("key%d", i)
+			command, _ := protocol.ParseCommand([]byte(makeTestCommand("get", key)))
+			client.Queue(command)
+
+			if pool, _ := server.HashRing.GetConnectionPool(command); len(data.endpoints) == 1 || pool == busyPool {
+				expected += "-ERR " + connection.ERR_ACQUIRE_TIMEOUT.Error() + "\r\n"
+			} else {
+				expected += bulkReply(key)
+			}
+		}
+
+		if err := client.FlushRedisAndRespond(); err != nil {
+			t.Errorf("The client should stay connected while the pool is busy, got %s", err)
+		}
+		if output.String() != expected {
+			t.Errorf("Unexpected pipeline replies with %d pools.\r\nExpected %q\r\nGot      %q", len(data.endpoints), expected, output.String())
+		}
+
+		server.Listener.Close()
+	}
+}
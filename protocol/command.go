@@ -31,4 +31,8 @@ type Command interface {
 	GetBuffer() []byte
 	GetFirstArg() []byte
 	GetArgCount() int
+	//Gets the argument at the given index, where 0 is the first argument after the command.  Returns nil if there is none
+	GetArg(index int) []byte
+	//Gets every argument after the command, without copying them out of the command's buffer
+	GetArgs() [][]byte
 }
@@ -0,0 +1,668 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"strconv"
+	"time"
+)
+
+type CommandFlag uint32
+
+const (
+	//The command only reads data
+	FLAG_READONLY CommandFlag = 1 << iota
+	//The command may modify data
+	FLAG_WRITE
+	//The command may block the connection it is run on
+	FLAG_BLOCKING
+	//The command is an administrative command, that should be run directly on a redis server
+	FLAG_ADMIN
+	//The command is part of pub/sub
+	FLAG_PUBSUB
+	//The command's keys can not be found from its key positions alone (ex: eval's numkeys, sort's STORE option)
+	FLAG_MOVABLEKEYS
+)
+
+type SupportLevel int
+
+const (
+	//The command is never run through rmux
+	SUPPORTED_NEVER SupportLevel = iota
+	//The command is only run when rmux is not multiplexing
+	SUPPORTED_SINGLE_POOL
+	//When multiplexing, the command is only run if it is given a single key
+	SUPPORTED_SINGLE_KEY
+	//When multiplexing, the command is only run if all of its keys hash to the same connection pool
+	SUPPORTED_SAME_POOL
+	//The command is always run
+	SUPPORTED_ALWAYS
+)
+
+//Describes a redis command, in the same terms as redis's COMMAND INFO
+type CommandInfo struct {
+	Name string
+	//The number of arguments the command takes, including the command name.  A negative arity means at least that many
+	Arity int
+	Flags CommandFlag
+	//The position of the command's first key, or 0 if it does not take keys
+	FirstKey int
+	//The position of the command's last key.  Negative positions count back from the end of the arguments
+	LastKey int
+	//The step between the command's keys
+	KeyStep int
+	//Whether or not rmux will run the command
+	Support SupportLevel
+}
+
+const (
+	ro   = FLAG_READONLY
+	w    = FLAG_WRITE
+	blk  = FLAG_BLOCKING
+	adm  = FLAG_ADMIN
+	ps   = FLAG_PUBSUB
+	mvk  = FLAG_MOVABLEKEYS
+	none = CommandFlag(0)
+)
+
+//Every command that rmux knows about.  Commands that are missing from this table are never run.
+//Key positions follow redis's COMMAND INFO, except that publish is keyed by its channel so that it can be hashed.
+//Commands with FLAG_MOVABLEKEYS have their keys found by movableKeyFinders instead.
+var commandTable = []CommandInfo{
+	// Strings
+	{"append", 3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"decr", 2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"decrby", 3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"get", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"getbit", 3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"getdel", 2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"getex", -2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"getrange", 4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"getset", 3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"incr", 2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"incrby", 3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"incrbyfloat", 3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"lcs", -3, ro, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"mget", -2, ro, 1, -1, 1, SUPPORTED_ALWAYS},
+	{"mset", -3, w, 1, -1, 2, SUPPORTED_ALWAYS},
+	{"msetnx", -3, w, 1, -1, 2, SUPPORTED_ALWAYS},
+	{"psetex", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"set", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"setbit", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"setex", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"setnx", 3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"setrange", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"strlen", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"substr", 4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+
+	// Keys
+	{"copy", -3, w, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"del", -2, w, 1, -1, 1, SUPPORTED_ALWAYS},
+	{"dump", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"exists", -2, ro, 1, -1, 1, SUPPORTED_ALWAYS},
+	{"expire", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"expireat", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"expiretime", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"keys", 2, ro, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"migrate", -6, w | adm | mvk, 3, 3, 1, SUPPORTED_NEVER},
+	{"move", 3, w, 1, 1, 1, SUPPORTED_NEVER},
+	{"object", -2, ro, 2, 2, 1, SUPPORTED_ALWAYS},
+	{"persist", 2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"pexpire", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"pexpireat", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"pexpiretime", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"pttl", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"randomkey", 1, ro, 0, 0, 0, SUPPORTED_SINGLE_POOL},
+	{"rename", 3, w, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"renamenx", 3, w, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"restore", -4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"scan", -2, ro, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"sort", -2, w | mvk, 1, 1, 1, SUPPORTED_SAME_POOL},
+	{"sort_ro", -2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"touch", -2, ro, 1, -1, 1, SUPPORTED_ALWAYS},
+	{"ttl", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"type", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"unlink", -2, w, 1, -1, 1, SUPPORTED_ALWAYS},
+	{"wait", 3, none, 0, 0, 0, SUPPORTED_NEVER},
+
+	// Lists
+	{"blmove", 6, w | blk, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"blmpop", -5, w | blk | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"blpop", -3, w | blk, 1, -2, 1, SUPPORTED_SAME_POOL},
+	{"brpop", -3, w | blk, 1, -2, 1, SUPPORTED_SAME_POOL},
+	{"brpoplpush", 4, w | blk, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"lindex", 3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"linsert", 5, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"llen", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"lmove", 5, w, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"lmpop", -4, w | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"lpop", -2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"lpos", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"lpush", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"lpushx", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"lrange", 4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"lrem", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"lset", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"ltrim", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"rpop", -2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"rpoplpush", 3, w, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"rpush", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"rpushx", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+
+	// Sets
+	{"sadd", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"scard", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"sdiff", -2, ro, 1, -1, 1, SUPPORTED_SAME_POOL},
+	{"sdiffstore", -3, w, 1, -1, 1, SUPPORTED_SAME_POOL},
+	{"sinter", -2, ro, 1, -1, 1, SUPPORTED_SAME_POOL},
+	{"sintercard", -3, ro | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"sinterstore", -3, w, 1, -1, 1, SUPPORTED_SAME_POOL},
+	{"sismember", 3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"smembers", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"smismember", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"smove", 4, w, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"spop", -2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"srandmember", -2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"srem", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"sscan", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"sunion", -2, ro, 1, -1, 1, SUPPORTED_SAME_POOL},
+	{"sunionstore", -3, w, 1, -1, 1, SUPPORTED_SAME_POOL},
+
+	// Hashes
+	{"hdel", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hexists", 3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hget", 3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hgetall", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hincrby", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hincrbyfloat", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hkeys", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hlen", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hmget", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hmset", -4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hrandfield", -2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hscan", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hset", -4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hsetnx", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hstrlen", 3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"hvals", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+
+	// Sorted sets
+	{"bzmpop", -5, w | blk | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"bzpopmax", -3, w | blk, 1, -2, 1, SUPPORTED_SAME_POOL},
+	{"bzpopmin", -3, w | blk, 1, -2, 1, SUPPORTED_SAME_POOL},
+	{"zadd", -4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zcard", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zcount", 4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zdiff", -3, ro | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"zdiffstore", -4, w | mvk, 1, 1, 1, SUPPORTED_SAME_POOL},
+	{"zincrby", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zinter", -3, ro | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"zintercard", -3, ro | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"zinterstore", -4, w | mvk, 1, 1, 1, SUPPORTED_SAME_POOL},
+	{"zlexcount", 4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zmpop", -4, w | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"zmscore", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zpopmax", -2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zpopmin", -2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrandmember", -2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrange", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrangebylex", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrangebyscore", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrangestore", -5, w, 1, 2, 1, SUPPORTED_SAME_POOL},
+	{"zrank", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrem", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zremrangebylex", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zremrangebyrank", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zremrangebyscore", 4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrevrange", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrevrangebylex", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrevrangebyscore", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zrevrank", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zscan", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zscore", 3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"zunion", -3, ro | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"zunionstore", -4, w | mvk, 1, 1, 1, SUPPORTED_SAME_POOL},
+
+	// HyperLogLog
+	{"pfadd", -2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"pfcount", -2, ro, 1, -1, 1, SUPPORTED_SAME_POOL},
+	{"pfmerge", -2, w, 1, -1, 1, SUPPORTED_SAME_POOL},
+
+	// Bitmaps
+	{"bitcount", -2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"bitfield", -2, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"bitfield_ro", -2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"bitop", -4, w, 2, -1, 1, SUPPORTED_SAME_POOL},
+	{"bitpos", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+
+	// Geo
+	{"geoadd", -5, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"geodist", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"geohash", -2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"geopos", -2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"georadius", -6, w | mvk, 1, 1, 1, SUPPORTED_SAME_POOL},
+	{"georadius_ro", -6, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"georadiusbymember", -5, w | mvk, 1, 1, 1, SUPPORTED_SAME_POOL},
+	{"georadiusbymember_ro", -5, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"geosearch", -7, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"geosearchstore", -8, w, 1, 2, 1, SUPPORTED_SAME_POOL},
+
+	// Streams
+	{"xack", -4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xadd", -5, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xautoclaim", -6, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xclaim", -6, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xdel", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xgroup", -2, w, 2, 2, 1, SUPPORTED_ALWAYS},
+	{"xinfo", -2, ro, 2, 2, 1, SUPPORTED_ALWAYS},
+	{"xlen", 2, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xpending", -3, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xrange", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xread", -4, ro | blk | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"xreadgroup", -7, w | blk | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"xrevrange", -4, ro, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xsetid", -3, w, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"xtrim", -4, w, 1, 1, 1, SUPPORTED_ALWAYS},
+
+	// Pub/Sub
+	{"psubscribe", -2, ps, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"publish", 3, ps, 1, 1, 1, SUPPORTED_ALWAYS},
+	{"pubsub", -2, ps, 0, 0, 0, SUPPORTED_NEVER},
+	{"punsubscribe", -1, ps, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"subscribe", -2, ps, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"unsubscribe", -1, ps, 0, 0, 0, SUPPORTED_ALWAYS},
+
+	// Scripting
+	{"eval", -3, w | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"eval_ro", -3, ro | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"evalsha", -3, w | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"evalsha_ro", -3, ro | mvk, 0, 0, 0, SUPPORTED_SAME_POOL},
+	{"fcall", -3, w | mvk, 0, 0, 0, SUPPORTED_SINGLE_POOL},
+	{"fcall_ro", -3, ro | mvk, 0, 0, 0, SUPPORTED_SINGLE_POOL},
+	{"function", -2, w, 0, 0, 0, SUPPORTED_SINGLE_POOL},
+	{"script", -2, none, 0, 0, 0, SUPPORTED_ALWAYS},
+
+	// Connection
+	{"auth", -2, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"client", -2, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"echo", 2, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"hello", -1, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"ping", -1, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"quit", -1, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"reset", 1, none, 0, 0, 0, SUPPORTED_NEVER},
+	{"select", 2, none, 0, 0, 0, SUPPORTED_ALWAYS},
+
+	// Transactions
+	{"discard", 1, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"exec", 1, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"multi", 1, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"unwatch", 1, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"watch", -2, ro, 1, -1, 1, SUPPORTED_SAME_POOL},
+
+	// Server
+	{"acl", -2, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"bgrewriteaof", 1, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"bgsave", -1, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"cluster", -2, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"command", -1, none, 0, 0, 0, SUPPORTED_NEVER},
+	{"config", -2, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"dbsize", 1, ro, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"debug", -2, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"failover", -1, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"flushall", -1, w, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"flushdb", -1, w, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"info", -1, none, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"lastsave", 1, none, 0, 0, 0, SUPPORTED_NEVER},
+	{"latency", -2, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"memory", -2, ro | mvk, 0, 0, 0, SUPPORTED_ALWAYS},
+	{"module", -2, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"monitor", 1, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"psync", -3, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"replicaof", 3, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"role", 1, none, 0, 0, 0, SUPPORTED_NEVER},
+	{"save", 1, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"shutdown", -1, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"slaveof", 3, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"slowlog", -2, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"swapdb", 3, w, 0, 0, 0, SUPPORTED_NEVER},
+	{"sync", 1, adm, 0, 0, 0, SUPPORTED_NEVER},
+	{"time", 1, ro, 0, 0, 0, SUPPORTED_ALWAYS},
+}
+
+//commandTable, indexed by command name
+var commands = make(map[string]*CommandInfo, len(commandTable))
+
+func init() {
+	for i := range commandTable {
+		commands[commandTable[i].Name] = &commandTable[i]
+	}
+}
+
+//Looks up the given (lowercased) command name.  Returns nil if rmux does not know about the command
+func LookupCommand(command []byte) *CommandInfo {
+	return commands[string(command)]
+}
+
+//Whether or not the command has all of the given flags
+func (this *CommandInfo) HasFlag(flag CommandFlag) bool {
+	return this.Flags&flag == flag
+}
+
+//Whether or not the given number of arguments (including the command name) matches the command's arity
+func (this *CommandInfo) CheckArity(argc int) bool {
+	if this.Arity >= 0 {
+		return argc == this.Arity
+	}
+	return argc >= -this.Arity
+}
+
+//Gets the positions of the keys in a command with argc arguments (including the command name), from its key positions
+//Commands with FLAG_MOVABLEKEYS can have more keys than these
+func (this *CommandInfo) KeyPositions(argc int) []int {
+	if this.FirstKey <= 0 {
+		return nil
+	}
+
+	lastKey := this.LastKey
+	if lastKey < 0 {
+		lastKey = argc + lastKey
+	}
+	if lastKey >= argc {
+		lastKey = argc - 1
+	}
+
+	positions := make([]int, 0, 1)
+	for i := this.FirstKey; i <= lastKey; i += this.KeyStep {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+//Whether or not rmux will run the command, given whether it is multiplexing and whether the command has multiple keys
+func (this *CommandInfo) IsSupported(isMultiplexing, isMultipleKeys bool) bool {
+	switch this.Support {
+	case SUPPORTED_ALWAYS, SUPPORTED_SAME_POOL:
+		return true
+	case SUPPORTED_SINGLE_KEY:
+		return !isMultiplexing || !isMultipleKeys
+	case SUPPORTED_SINGLE_POOL:
+		return !isMultiplexing
+	}
+	return false
+}
+
+//Commands that are only run with one of the listed subcommands, because the rest report on a whole redis server
+var allowedSubcommands = map[string][]string{
+	"client": {"id", "getname", "setname", "setinfo", "list", "kill"},
+	"memory": {"usage"},
+	"object": {"encoding", "freq", "idletime", "refcount", "help"},
+}
+
+//Commands whose keys can not be found from their key positions alone, and the functions that find them
+//Each function is given every argument after the command
+var movableKeyFinders = map[string]func(args [][]byte) [][]byte{
+	"blmpop":            keysAfterNumkeys(1),
+	"bzmpop":            keysAfterNumkeys(1),
+	"eval":              keysAfterNumkeys(1),
+	"eval_ro":           keysAfterNumkeys(1),
+	"evalsha":           keysAfterNumkeys(1),
+	"evalsha_ro":        keysAfterNumkeys(1),
+	"fcall":             keysAfterNumkeys(1),
+	"fcall_ro":          keysAfterNumkeys(1),
+	"georadius":         geoStoreKeys(5),
+	"georadiusbymember": geoStoreKeys(4),
+	"lmpop":             keysAfterNumkeys(0),
+	"memory":            memoryKeys,
+	"sintercard":        keysAfterNumkeys(0),
+	"sort":              sortKeys,
+	"xread":             streamKeys,
+	"xreadgroup":        streamKeys,
+	"zdiff":             keysAfterNumkeys(0),
+	"zdiffstore":        destinationAndKeysAfterNumkeys,
+	"zinter":            keysAfterNumkeys(0),
+	"zintercard":        keysAfterNumkeys(0),
+	"zinterstore":       destinationAndKeysAfterNumkeys,
+	"zmpop":             keysAfterNumkeys(0),
+	"zunion":            keysAfterNumkeys(0),
+	"zunionstore":       destinationAndKeysAfterNumkeys,
+}
+
+//Finds the keys that follow a numkeys argument at the given index (ex: EVAL script numkeys key...)
+func keysAfterNumkeys(index int) func(args [][]byte) [][]byte {
+	return func(args [][]byte) [][]byte {
+		if len(args) <= index {
+			return nil
+		}
+
+		numKeys, err := ParseInt(args[index])
+		if err != nil || numKeys <= 0 || len(args) < index+1+numKeys {
+			return nil
+		}
+		return args[index+1 : index+1+numKeys]
+	}
+}
+
+//ZINTERSTORE, ZUNIONSTORE and ZDIFFSTORE take a destination, followed by numkeys and their keys
+func destinationAndKeysAfterNumkeys(args [][]byte) [][]byte {
+	if len(args) == 0 {
+		return nil
+	}
+	return append([][]byte{args[0]}, keysAfterNumkeys(1)(args)...)
+}
+
+//XREAD and XREADGROUP take their keys after STREAMS, followed by one id per key
+func streamKeys(args [][]byte) [][]byte {
+	for i, arg := range args {
+		if bytes.EqualFold(arg, []byte("streams")) {
+			streams := args[i+1:]
+			return streams[:len(streams)/2]
+		}
+	}
+	return nil
+}
+
+//MEMORY USAGE key is the only memory subcommand with a key
+func memoryKeys(args [][]byte) [][]byte {
+	if len(args) > 1 && bytes.EqualFold(args[0], []byte("usage")) {
+		return args[1:2]
+	}
+	return nil
+}
+
+//SORT key has a destination key after STORE.  The patterns given to BY and GET are not counted as keys
+func sortKeys(args [][]byte) [][]byte {
+	if len(args) == 0 {
+		return nil
+	}
+
+	keys := args[0:1]
+	for i := 1; i < len(args); i++ {
+		switch string(bytes.ToLower(args[i])) {
+		case "limit":
+			i += 2
+		case "by", "get":
+			i++
+		case "store":
+			if i+1 < len(args) {
+				keys = append([][]byte{args[0]}, args[i+1])
+			}
+			i++
+		}
+	}
+	return keys
+}
+
+//GEORADIUS and GEORADIUSBYMEMBER have a destination key after STORE or STOREDIST, once their fixed arguments are done
+func geoStoreKeys(fixedArgs int) func(args [][]byte) [][]byte {
+	return func(args [][]byte) [][]byte {
+		if len(args) == 0 {
+			return nil
+		}
+
+		keys := args[0:1]
+		for i := fixedArgs; i < len(args); i++ {
+			switch string(bytes.ToLower(args[i])) {
+			case "count":
+				i++
+			case "store", "storedist":
+				if i+1 < len(args) {
+					keys = append([][]byte{args[0]}, args[i+1])
+				}
+				i++
+			}
+		}
+		return keys
+	}
+}
+
+//Finds how long a blocking command may block for, for the commands whose timeout is not their last argument
+//XREAD and XREADGROUP only block when they are given BLOCK, with a timeout in milliseconds
+var blockingTimeoutFinders = map[string]func(args [][]byte) ([]byte, time.Duration, bool){
+	"blmpop":     firstArgTimeout,
+	"bzmpop":     firstArgTimeout,
+	"xread":      blockOptionTimeout,
+	"xreadgroup": blockOptionTimeout,
+}
+
+func firstArgTimeout(args [][]byte) ([]byte, time.Duration, bool) {
+	if len(args) == 0 {
+		return nil, 0, true
+	}
+	return args[0], time.Second, true
+}
+
+func blockOptionTimeout(args [][]byte) ([]byte, time.Duration, bool) {
+	for i := 0; i < len(args)-1; i++ {
+		if bytes.EqualFold(args[i], []byte("streams")) {
+			break
+		} else if bytes.EqualFold(args[i], []byte("block")) {
+			return args[i+1], time.Millisecond, true
+		}
+	}
+	return nil, 0, false
+}
+
+//Gets how long the given command may block redis's reply for, and whether it blocks at all
+//A timeout of 0 blocks forever, the same as in redis.  Timeouts that can't be parsed are returned as 0 as well, since
+//redis replies to them with an error right away
+func GetBlockingTimeout(command Command) (timeout time.Duration, isBlocking bool) {
+	info := LookupCommand(command.GetCommand())
+	if info == nil || !info.HasFlag(FLAG_BLOCKING) {
+		return 0, false
+	}
+
+	args := command.GetArgs()
+	value, unit := []byte(nil), time.Second
+	if findTimeout, ok := blockingTimeoutFinders[info.Name]; ok {
+		if value, unit, isBlocking = findTimeout(args); !isBlocking {
+			return 0, false
+		}
+	} else if len(args) > 0 {
+		value = args[len(args)-1]
+	}
+
+	seconds, err := strconv.ParseFloat(string(value), 64)
+	if err != nil || seconds < 0 {
+		return 0, true
+	}
+	return time.Duration(seconds * float64(unit)), true
+}
+
+//Gets every key of the given command.  The keys point into the command's buffer
+func GetKeys(command Command) [][]byte {
+	info := LookupCommand(command.GetCommand())
+	if info == nil {
+		return nil
+	}
+
+	if findKeys, ok := movableKeyFinders[info.Name]; ok {
+		return findKeys(command.GetArgs())
+	}
+
+	positions := info.KeyPositions(command.GetArgCount() + 1)
+	if len(positions) == 0 {
+		return nil
+	}
+
+	keys := make([][]byte, len(positions))
+	for i, position := range positions {
+		keys[i] = command.GetArg(position - 1)
+	}
+	return keys
+}
+
+//Gets the first key of the given command, or nil if it does not have one
+func GetFirstKey(command Command) []byte {
+	info := LookupCommand(command.GetCommand())
+	if info == nil {
+		return nil
+	}
+
+	if info.HasFlag(FLAG_MOVABLEKEYS) {
+		if keys := GetKeys(command); len(keys) > 0 {
+			return keys[0]
+		}
+		return nil
+	}
+
+	if info.FirstKey <= 0 {
+		return nil
+	}
+	return command.GetArg(info.FirstKey - 1)
+}
+
+//Checks that rmux will run the given command, and that it was given the right number of arguments
+func CheckCommand(command Command, isMultiplexing bool) error {
+	info := LookupCommand(command.GetCommand())
+	if info == nil {
+		return ERR_COMMAND_UNSUPPORTED
+	}
+
+	argc := command.GetArgCount() + 1
+	if !info.IsSupported(isMultiplexing, len(info.KeyPositions(argc)) > 1) {
+		return ERR_COMMAND_UNSUPPORTED
+	}
+
+	if subcommands, ok := allowedSubcommands[info.Name]; ok && !containsFold(subcommands, command.GetFirstArg()) {
+		return ERR_COMMAND_UNSUPPORTED
+	}
+
+	if !info.CheckArity(argc) {
+		return ERR_BAD_ARGUMENTS
+	}
+	return nil
+}
+
+//Whether or not the given list holds the given value, ignoring case
+func containsFold(list []string, value []byte) bool {
+	for _, item := range list {
+		if bytes.EqualFold([]byte(item), value) {
+			return true
+		}
+	}
+	return false
+}
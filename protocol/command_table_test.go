@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package protocol
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCommandTableIsConsistent(test *testing.T) {
+	if len(commands) != len(commandTable) {
+		test.Errorf("The command table has %d entries, but only %d unique names", len(commandTable), len(commands))
+	}
+
+	for _, info := range commandTable {
+		if info.FirstKey > 0 && info.KeyStep <= 0 {
+			test.Errorf("%s has keys but no key step", info.Name)
+		}
+		if info.FirstKey == 0 && (info.LastKey != 0 || info.KeyStep != 0) {
+			test.Errorf("%s has no first key, but has a last key or key step", info.Name)
+		}
+		if info.Support == SUPPORTED_SINGLE_KEY && info.LastKey == info.FirstKey {
+			test.Errorf("%s can only take one key, and should not be SUPPORTED_SINGLE_KEY", info.Name)
+		}
+		if _, ok := movableKeyFinders[info.Name]; ok != info.HasFlag(FLAG_MOVABLEKEYS) && info.Support != SUPPORTED_NEVER {
+			test.Errorf("%s should have a movable key finder if and only if it has FLAG_MOVABLEKEYS", info.Name)
+		}
+	}
+
+	for name := range movableKeyFinders {
+		if LookupCommand([]byte(name)) == nil {
+			test.Errorf("%s has a movable key finder, but is not in the command table", name)
+		}
+	}
+}
+
+func TestLookupCommand(test *testing.T) {
+	info := LookupCommand([]byte("blpop"))
+	if info == nil {
+		test.Fatal("blpop was not found")
+	}
+	if !info.HasFlag(FLAG_WRITE | FLAG_BLOCKING) {
+		test.Errorf("blpop should be a blocking write, got flags %d", info.Flags)
+	}
+	if info.HasFlag(FLAG_READONLY) {
+		test.Error("blpop should not be readonly")
+	}
+
+	if LookupCommand([]byte("notacommand")) != nil {
+		test.Error("An unknown command was found")
+	}
+	if LookupCommand([]byte("GET")) != nil {
+		test.Error("Lookups should expect lowercased command names")
+	}
+}
+
+func TestCheckArity(test *testing.T) {
+	var testData = []struct {
+		command  string
+		argc     int
+		expected bool
+	}{
+		{"get", 2, true},
+		{"get", 1, false},
+		{"get", 3, false},
+		{"set", 3, true},
+		{"set", 5, true},
+		{"set", 2, false},
+		{"ping", 1, true},
+		{"ping", 2, true},
+		{"time", 2, false},
+	}
+
+	for _, data := range testData {
+		if LookupCommand([]byte(data.command)).CheckArity(data.argc) != data.expected {
+			test.Errorf("Expected %s with %d arguments to be %t", data.command, data.argc, data.expected)
+		}
+	}
+}
+
+func TestKeyPositions(test *testing.T) {
+	var testData = []struct {
+		command  string
+		argc     int
+		expected []int
+	}{
+		{"get", 2, []int{1}},
+		{"mget", 4, []int{1, 2, 3}},
+		{"mset", 5, []int{1, 3}},
+		{"blpop", 4, []int{1, 2}},
+		{"bitop", 5, []int{2, 3, 4}},
+		{"rename", 3, []int{1, 2}},
+		{"object", 3, []int{2}},
+		{"time", 1, nil},
+		{"eval", 5, nil},
+	}
+
+	for _, data := range testData {
+		positions := LookupCommand([]byte(data.command)).KeyPositions(data.argc)
+		if !reflect.DeepEqual(positions, data.expected) {
+			test.Errorf("Expected key positions %v for %s, got %v", data.expected, data.command, positions)
+		}
+	}
+}
+
+func TestGetFirstKey(test *testing.T) {
+	var testData = []struct {
+		command  *MultibulkCommand
+		expected []byte
+	}{
+		{NewMultibulkCommand([]byte("get"), []byte("key")), []byte("key")},
+		{NewMultibulkCommand([]byte("bitop"), []byte("and"), []byte("dest"), []byte("src")), []byte("dest")},
+		{NewMultibulkCommand([]byte("xinfo"), []byte("stream"), []byte("key")), []byte("key")},
+		{NewMultibulkCommand([]byte("xinfo"), []byte("help")), nil},
+		{NewMultibulkCommand([]byte("echo"), []byte("hello")), nil},
+	}
+
+	for _, data := range testData {
+		key := GetFirstKey(data.command)
+		if !reflect.DeepEqual(key, data.expected) {
+			test.Errorf("Expected first key %q for %q, got %q", data.expected, data.command.GetBuffer(), key)
+		}
+	}
+}
+
+func TestGetKeys(test *testing.T) {
+	var testData = []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{"get", "key"}, []string{"key"}},
+		{[]string{"mset", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]string{"eval", "return 1", "2", "a", "b", "arg"}, []string{"a", "b"}},
+		{[]string{"evalsha", "abc", "0", "arg"}, nil},
+		{[]string{"eval", "return 1", "3", "a"}, nil},
+		{[]string{"xread", "COUNT", "2", "STREAMS", "s1", "s2", "0", "0"}, []string{"s1", "s2"}},
+		{[]string{"xreadgroup", "GROUP", "g", "c", "streams", "s1", ">"}, []string{"s1"}},
+		{[]string{"object", "encoding", "key"}, []string{"key"}},
+		{[]string{"memory", "usage", "key", "SAMPLES", "5"}, []string{"key"}},
+		{[]string{"memory", "stats"}, nil},
+		{[]string{"georadius", "geo", "15", "37", "200", "km", "COUNT", "5", "STORE", "dest"}, []string{"geo", "dest"}},
+		{[]string{"georadiusbymember", "geo", "member", "200", "km", "STOREDIST", "dest"}, []string{"geo", "dest"}},
+		{[]string{"georadius", "geo", "15", "37", "200", "km", "WITHDIST"}, []string{"geo"}},
+		{[]string{"sort", "list", "BY", "store", "LIMIT", "0", "10", "STORE", "dest"}, []string{"list", "dest"}},
+		{[]string{"sort", "list", "GET", "#"}, []string{"list"}},
+		{[]string{"zunionstore", "dest", "2", "a", "b", "WEIGHTS", "1", "2"}, []string{"dest", "a", "b"}},
+		{[]string{"blmpop", "0", "2", "a", "b", "LEFT"}, []string{"a", "b"}},
+		{[]string{"time"}, nil},
+	}
+
+	for _, data := range testData {
+		args := make([][]byte, len(data.args)-1)
+		for i, arg := range data.args[1:] {
+			args[i] = []byte(arg)
+		}
+		command := NewMultibulkCommand([]byte(data.args[0]), args...)
+
+		keys := GetKeys(command)
+		if len(keys) != len(data.expected) {
+			test.Errorf("Expected keys %q for %v, got %q", data.expected, data.args, keys)
+			continue
+		}
+		for i, key := range keys {
+			if string(key) != data.expected[i] {
+				test.Errorf("Expected keys %q for %v, got %q", data.expected, data.args, keys)
+				break
+			}
+		}
+
+		var expectedFirstKey []byte
+		if len(data.expected) > 0 {
+			expectedFirstKey = []byte(data.expected[0])
+		}
+		if firstKey := GetFirstKey(command); !reflect.DeepEqual(firstKey, expectedFirstKey) {
+			test.Errorf("Expected first key %q for %v, got %q", expectedFirstKey, data.args, firstKey)
+		}
+	}
+}
+
+func TestGetBlockingTimeout(test *testing.T) {
+	var testData = []struct {
+		args       []string
+		timeout    time.Duration
+		isBlocking bool
+	}{
+		{[]string{"get", "key"}, 0, false},
+		{[]string{"blpop", "a", "b", "5"}, 5 * time.Second, true},
+		{[]string{"brpoplpush", "src", "dest", "0.5"}, 500 * time.Millisecond, true},
+		{[]string{"bzpopmin", "zset", "0"}, 0, true},
+		{[]string{"blmpop", "2", "1", "list", "LEFT"}, 2 * time.Second, true},
+		{[]string{"xread", "COUNT", "2", "BLOCK", "1500", "STREAMS", "s1", "0"}, 1500 * time.Millisecond, true},
+		{[]string{"xread", "STREAMS", "block", "0"}, 0, false},
+		{[]string{"xreadgroup", "GROUP", "g", "c", "STREAMS", "s1", ">"}, 0, false},
+		{[]string{"blpop", "a", "soon"}, 0, true},
+	}
+
+	for _, data := range testData {
+		args := make([][]byte, len(data.args)-1)
+		for i, arg := range data.args[1:] {
+			args[i] = []byte(arg)
+		}
+
+		timeout, isBlocking := GetBlockingTimeout(NewMultibulkCommand([]byte(data.args[0]), args...))
+		if timeout != data.timeout || isBlocking != data.isBlocking {
+			test.Errorf("Expected %v to block for %s (%t), got %s (%t)", data.args, data.timeout, data.isBlocking, timeout, isBlocking)
+		}
+	}
+}
+
+func TestCheckCommand(test *testing.T) {
+	var testData = []struct {
+		command      *MultibulkCommand
+		multiplexing bool
+		expected     error
+	}{
+		{NewMultibulkCommand([]byte("get"), []byte("key")), true, nil},
+		{NewMultibulkCommand([]byte("get")), true, ERR_BAD_ARGUMENTS},
+		{NewMultibulkCommand([]byte("xlen"), []byte("stream")), true, nil},
+		{NewMultibulkCommand([]byte("pfcount"), []byte("a")), true, nil},
+		{NewMultibulkCommand([]byte("pfcount"), []byte("a"), []byte("b")), true, nil},
+		{NewMultibulkCommand([]byte("pfcount"), []byte("a"), []byte("b")), false, nil},
+		{NewMultibulkCommand([]byte("rename"), []byte("a"), []byte("b")), true, nil},
+		{NewMultibulkCommand([]byte("randomkey")), true, ERR_COMMAND_UNSUPPORTED},
+		{NewMultibulkCommand([]byte("shutdown")), false, ERR_COMMAND_UNSUPPORTED},
+		{NewMultibulkCommand([]byte("object"), []byte("ENCODING"), []byte("key")), true, nil},
+		{NewMultibulkCommand([]byte("memory"), []byte("usage"), []byte("key")), true, nil},
+		{NewMultibulkCommand([]byte("memory"), []byte("stats")), true, ERR_COMMAND_UNSUPPORTED},
+		{NewMultibulkCommand([]byte("notacommand")), false, ERR_COMMAND_UNSUPPORTED},
+	}
+
+	for _, data := range testData {
+		err := CheckCommand(data.command, data.multiplexing)
+		if err != data.expected {
+			test.Errorf("Expected %v for %q, got %v", data.expected, data.command.GetBuffer(), err)
+		}
+	}
+}
@@ -32,3 +32,8 @@ type RecoverableError struct {
 func (e *RecoverableError) Error() string {
 	return e.errMsg
 }
+
+//Creates an error that is passed on to the client, without closing its connection
+func NewRecoverableError(message string) *RecoverableError {
+	return &RecoverableError{message}
+}
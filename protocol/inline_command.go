@@ -35,6 +35,8 @@ type InlineCommand struct {
 	// Usually denotes the key
 	FirstArg []byte
 	ArgCount int
+	// Every argument after the command
+	Args [][]byte
 }
 
 func NewInlineCommand() *InlineCommand {
@@ -75,6 +77,7 @@ func ParseInlineCommand(b []byte) (*InlineCommand, error) {
 			c.FirstArg = part
 		}
 
+		c.Args = append(c.Args, part)
 		c.ArgCount++
 	}
 
@@ -97,3 +100,14 @@ func (this *InlineCommand) GetFirstArg() []byte {
 func (this *InlineCommand) GetArgCount() int {
 	return this.ArgCount
 }
+
+func (this *InlineCommand) GetArg(index int) []byte {
+	if index < 0 || index >= len(this.Args) {
+		return nil
+	}
+	return this.Args[index]
+}
+
+func (this *InlineCommand) GetArgs() [][]byte {
+	return this.Args
+}
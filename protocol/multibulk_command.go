@@ -27,6 +27,7 @@ package protocol
 
 import (
 	"bytes"
+	"strconv"
 )
 
 var NIL_STRING []byte = nil
@@ -37,6 +38,8 @@ type MultibulkCommand struct {
 	// Usually denotes the key
 	FirstArg []byte
 	ArgCount int
+	// Every argument after the command, parsed out of the buffer the first time they're asked for
+	args [][]byte
 }
 
 func ParseMultibulkCommand(b []byte) (*MultibulkCommand, error) {
@@ -64,30 +67,17 @@ func ParseMultibulkCommand(b []byte) (*MultibulkCommand, error) {
 
 	cBuf := c.Buffer[newlinePos+2:]
 	for i := 0; i < 2 && i < count; i++ {
-		if cBuf[0] != '$' {
-			return nil, ERROR_COMMAND_PARSE
-		}
-
-		newlinePos := bytes.Index(cBuf, REDIS_NEWLINE)
-		if newlinePos < 0 {
-			return nil, ERROR_COMMAND_PARSE
-		}
-
-		count, err := ParseInt(cBuf[1:newlinePos])
+		var arg []byte
+		arg, cBuf, err = nextBulkString(cBuf)
 		if err != nil {
 			return nil, err
-		} else if count < 0 {
-			cBuf = cBuf[newlinePos+2:]
-			continue
 		}
 
 		if i == 0 {
-			c.Command = cBuf[newlinePos+2 : newlinePos+2+count]
+			c.Command = arg
 		} else {
-			c.FirstArg = cBuf[newlinePos+2 : newlinePos+2+count]
+			c.FirstArg = arg
 		}
-
-		cBuf = cBuf[newlinePos+2+count+2:]
 	}
 
 	for i := 0; i < len(c.Command); i++ {
@@ -99,6 +89,45 @@ func ParseMultibulkCommand(b []byte) (*MultibulkCommand, error) {
 	return c, nil
 }
 
+//Reads the bulk string at the start of the given buffer, returning it (nil for a null bulk string) and the rest of the buffer
+func nextBulkString(buffer []byte) (arg []byte, rest []byte, err error) {
+	if len(buffer) == 0 || buffer[0] != '$' {
+		return nil, nil, ERROR_COMMAND_PARSE
+	}
+
+	newlinePos := bytes.Index(buffer, REDIS_NEWLINE)
+	if newlinePos < 0 {
+		return nil, nil, ERROR_COMMAND_PARSE
+	}
+
+	length, err := ParseInt(buffer[1:newlinePos])
+	if err != nil {
+		return nil, nil, err
+	} else if length < 0 {
+		return nil, buffer[newlinePos+2:], nil
+	}
+
+	if len(buffer) < newlinePos+2+length+2 {
+		return nil, nil, ERROR_COMMAND_PARSE
+	}
+	return buffer[newlinePos+2 : newlinePos+2+length], buffer[newlinePos+2+length+2:], nil
+}
+
+//Builds a new multibulk command out of the given command name and arguments
+func NewMultibulkCommand(command []byte, args ...[]byte) *MultibulkCommand {
+	var buffer bytes.Buffer
+	buffer.WriteString("*" + strconv.Itoa(len(args)+1) + "\r\n")
+	for _, arg := range append([][]byte{command}, args...) {
+		buffer.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		buffer.Write(arg)
+		buffer.Write(REDIS_NEWLINE)
+	}
+
+	// A command we've just formatted will always parse
+	c, _ := ParseMultibulkCommand(buffer.Bytes())
+	return c
+}
+
 // Satisfy Command Interface
 func (this *MultibulkCommand) GetCommand() []byte {
 	return this.Command
@@ -115,3 +144,43 @@ func (this *MultibulkCommand) GetFirstArg() []byte {
 func (this *MultibulkCommand) GetArgCount() int {
 	return this.ArgCount
 }
+
+//Gets the argument at the given index, where 0 is the first argument after the command.  Returns nil if there is none
+func (this *MultibulkCommand) GetArg(index int) []byte {
+	if index == 0 {
+		return this.FirstArg
+	}
+
+	args := this.GetArgs()
+	if index < 0 || index >= len(args) {
+		return nil
+	}
+	return args[index]
+}
+
+//Gets every argument after the command.  The arguments point into the command's buffer, and should not be modified
+func (this *MultibulkCommand) GetArgs() [][]byte {
+	if this.args != nil || this.ArgCount == 0 {
+		return this.args
+	}
+
+	// Skip past the count, which was checked when the command was parsed
+	cBuf := this.Buffer[bytes.Index(this.Buffer, REDIS_NEWLINE)+2:]
+	args := make([][]byte, 0, this.ArgCount+1)
+	for i := 0; i <= this.ArgCount; i++ {
+		arg, rest, err := nextBulkString(cBuf)
+		if err != nil {
+			break
+		}
+		args = append(args, arg)
+		cBuf = rest
+	}
+
+	// Drop the command itself
+	if len(args) > 0 {
+		this.args = args[1:]
+	} else {
+		this.args = args
+	}
+	return this.args
+}
@@ -27,8 +27,10 @@ package protocol
 
 import (
 	"bufio"
+	"bytes"
 	. "github.com/salesforce/rmux/writer"
 	"io"
+	"strconv"
 )
 
 const (
@@ -52,6 +54,7 @@ var (
 
 	//Commands declared once for convenience
 	DEL_COMMAND         = []byte("del")
+	MGET_COMMAND        = []byte("mget")
 	SUBSCRIBE_COMMAND   = []byte("subscribe")
 	UNSUBSCRIBE_COMMAND = []byte("unsubscribe")
 	PING_COMMAND        = []byte("ping")
@@ -64,246 +67,20 @@ var (
 	OK_RESPONSE   = []byte("+OK")
 	PONG_RESPONSE = []byte("+PONG")
 	ERR_RESPONSE  = []byte("$-1")
+	//A nil bulk string, as it appears inside of a multibulk response
+	NIL_BULK_RESPONSE = []byte("$-1\r\n")
 
 	//Redis expects \r\n newlines.  Using this means we can stop remembering that
 	REDIS_NEWLINE = []byte("\r\n")
-
-	//These functions should not be executed through a proxy.
-	//If you know what you're doing, you are welcome to execute them directly on your server
-	UNSAFE_FUNCTIONS = map[string]bool{
-		"auth":         true,
-		"bgrewriteaof": true,
-		"bgsave":       true,
-		"client":       true,
-		"config":       true,
-		"dbsize":       true,
-		"discard":      true,
-		"debug":        true,
-		"exec":         true,
-		"lastsave":     true,
-		"move":         true,
-		"monitor":      true,
-		"migrate":      true,
-		"multi":        true,
-		"object":       true,
-		"punsubscribe": true,
-		"psubscribe":   true,
-		"pubsub":       true,
-		"randomkey":    true,
-		"save":         true,
-		"shutdown":     true,
-		"slaveof":      true,
-		"slowlog":      true,
-		"subscribe":    true,
-		"sync":         true,
-		"time":         true,
-		"unsubscribe":  true,
-		"unwatch":      true,
-		"watch":        true,
-	}
-
-	//These functions will only work if multiplexing is disabled.
-	//It would be rather worthless to watch on one server, multi on another, and increment on a third
-	SINGLE_DB_FUNCTIONS = map[string]bool{
-		"bitop":       true,
-		"brpoplpush":  true,
-		"eval":        true,
-		"keys":        true,
-		"flushall":    true,
-		"flushdb":     true,
-		"mget":        true,
-		"mset":        true,
-		"msetnx":      true,
-		"rename":      true,
-		"renamenx":    true,
-		"rpoplpush":   true,
-		"script":      true,
-		"sdiff":       true,
-		"sdiffstore":  true,
-		"sinter":      true,
-		"sinterstore": true,
-		"smove":       true,
-		"sunion":      true,
-		"sunionstore": true,
-		"zinterstore": true,
-		"zunionstore": true,
-	}
 )
 
+//Whether or not rmux will run the given command.  isMultipleArgument is whether the command is given multiple keys
 func IsSupportedFunction(command []byte, isMultiplexing, isMultipleArgument bool) bool {
-	commandLength := len(command)
-
-	if command[0] == 'd' {
-		//*del is only supported if we're not multiplexing, or there's only one argument
-		if command[2] == 'l' && isMultipleArgument {
-			return false
-		}
-		//supported: decr, decrby, del, dump
-		//unsupported: debug, dbsize, discard
-		return (command[1] == 'e' || command[1] == 'u') && command[2] != 'b'
-	} else if command[0] == 'g' {
-		//supported: get, getbit, getrange, getset
-		return true
-	} else if command[0] == 's' {
-		//supported: select, set, setbit, setex, setnx, setrange, sort, spop, srandmember, srem, strlen
-		if command[1] == 'e' || command[1] == 'o' || command[1] == 'p' || command[1] == 'r' || command[1] == 't' {
-			return true
-		}
-		//supported: sadd
-		//unsupported: save
-		if command[1] == 'a' {
-			return command[2] == 'd'
-		}
-		//unsupported: shutdown. slaveof, slowlog, sync
-		if command[1] == 'h' || command[1] == 'l' || command[1] == 'y' {
-			return false
-		}
-		// not supported: subscribe
-		if command[1] == 'u' && command[2] == 'b' {
-			return false
-		}
-		//supported if multiplexing is disabled: script, sdiff, sdiffstore, sinter, sinterstore, smove, sunion, sunionstore
-		if !isMultiplexing {
-			return true
-		}
-		if command[1] == 'c' {
-			if command[3] == 'n' {
-				// supported if not multiplexing: scan
-				return !isMultiplexing
-			}
-			// supported: scard
-			return true
-		} else if command[1] == 'i' && command[2] == 's' {
-			// supported: sismember
-			return true
-		} else if command[1] == 'm' && command[2] == 'e' {
-			// supported: smembers
-			return true
-		} else if command[1] == 's' {
-			// supported: sscan
-			return true
-		}
-	} else if command[0] == 'h' {
-		//supported: hdel, hexists, hget, hgetall, hincrby, hincrbyfloat, hkeys, hlen, hmget, hmset, hsetnx, hvals
-		return true
-	} else if command[0] == 'i' {
-		//supported: incr, incrby, incrbyfloat
-		return true
-	} else if command[0] == 'l' {
-		//unsupported: lastsave
-		//supported: lindex, linsert, llen, lpop, lpush, lpushx, lrange, lrem, lset, ltrim
-		return command[1] != 'a'
-	} else if command[0] == 'z' {
-		// supported: zadd, zcard, zcount, zincrby, zlexcount, zrange, zrangebylex,
-		//            zrevrangebylex, zrangebyscore, zrank, zrem, zremrangebylex, zremrangebyrank,
-		//            zremrangebyscore, zrevrank, zscore
-		if !isMultiplexing {
-			return true
-		}
-
-		//supported if multiplexing is disabled: zinterstore, zunionstore
-		return !(command[1] == 'i' && command[3] == 't' || command[1] == 'u')
-	} else if command[0] == 'p' {
-		//supported: ping, psetex, pttl
-		if command[1] == 'u' {
-			if command[2] == 'n' || (command[2] == 'b' && command[3] == 's') {
-				// unsupported: punsubscribe, pubsub
-				return false
-			} else if command[2] == 'b' && command[3] == 'l' {
-				// supported: publish
-				return true
-			} else {
-				return false
-			}
-		} else if command[1] == 's' && command[2] == 'u' {
-			// unsupported: psubscribe
-			return false
-		} else if command[1] == 'f' {
-			// pf* class of functions (hyperloglog)
-			if command[2] == 'm' {
-				// supported if not multiplexing: pfmerge
-				return !isMultiplexing
-			} else if command[2] == 'c' {
-				// supported with one key if multiplexing: pfcount
-				if isMultiplexing {
-					return !isMultipleArgument
-				}
-				return true
-			}
-			// supported: pfadd
-			return true
-		} else if command[1] == 'e' {
-			// supported: persist, pexpire, pexpireat
-			return true
-		}
-		return true
-	} else if command[0] == 'q' {
-		//supported: quit
-		return true
-	} else if command[0] == 'r' {
-		if command[1] == 'p' && commandLength < 8 {
-			//supported: rpop, rpush, rpushx
-			return true
-		} else if !isMultiplexing {
-			// supported if multiplexing is disabled: rename, renamenx, rpoplpush, randomkey
-			// not supported: role
-			return command[1] != 'o'
-		} else if command[1] == 'e' && command[2] == 's' {
-			// supported: restore
-			return true
-		}
-		return false
-	} else if command[0] == 't' {
-		//supported: time, ttl, type
-		return true
-	} else if command[0] == 'u' {
-		//unsupported: unsubscribe, unwatch
-		return false
-	} else if command[0] == 'w' {
-		//unsupported: watch
-		return false
-	} else if command[0] == 'a' {
-		//supported: append
-		//unsupported: auth
-		return command[1] == 'p'
-	} else if command[0] == 'b' {
-		if command[1] == 'i' && (commandLength == 8 || commandLength == 6) {
-			//supported: bitcount, bitpos
-			return true
-		} else if command[1] == 'g' {
-			//unsupported: bgsave, bgwriteaof
-			return false
-		}
-		//supported if not multiplexing: bitop, brpop, blpop, brpoplpush
-		return !isMultiplexing
-	} else if command[0] == 'c' {
-		//unsupported: client, config
-		return false
-	} else if command[0] == 'e' {
-		//unsupported: exec
-		if command[2] == 'e' {
-			return false
-		}
-		//supported: echo, exists, expire, expireat
-		//supported if not multiplexing: eval, evalsha
-		return command[1] != 'v' || !isMultiplexing
-	} else if command[0] == 'f' {
-		//Support flushall and flushdb in non-multiplexing mode
-		return !isMultiplexing
-	} else if command[0] == 'k' {
-		//supported if not multiplexing: keys
-		return !isMultiplexing
-	} else if command[0] == 'm' {
-		//supported if not multiplexing: mget, mset, msetnx
-		//unsupported: move, monitor, migrate, multi
-		if isMultiplexing {
-			return false
-		}
-		return command[1] == 'g' || command[1] == 's'
-	} else if command[0] == 'o' {
+	info := LookupCommand(command)
+	if info == nil {
 		return false
 	}
-	return false
+	return info.IsSupported(isMultiplexing, isMultipleArgument)
 }
 
 //Parses a string into an int.
@@ -447,3 +224,47 @@ func CopyServerResponses(reader *bufio.Reader, localBuffer *FlexibleWriter, numR
 
 	return nil
 }
+
+//Reads numResponses server responses from the reader, and returns a copy of each of them
+//If the stream ends early, the responses that were read are returned along with the error
+func ReadServerResponses(reader *bufio.Reader, numResponses int) (responses [][]byte, err error) {
+	scanner := NewRespScanner(reader)
+	responses = make([][]byte, 0, numResponses)
+
+	for len(responses) < numResponses && scanner.Scan() {
+		response := make([]byte, len(scanner.Bytes()))
+		copy(response, scanner.Bytes())
+		responses = append(responses, response)
+	}
+
+	if sErr := scanner.Err(); sErr != nil {
+		return responses, sErr
+	}
+
+	if len(responses) < numResponses {
+		return responses, io.EOF
+	}
+
+	return responses, nil
+}
+
+//Splits a multibulk server response up into the responses it holds
+//A nil multibulk response comes back as a nil slice
+func SplitArrayResponse(response []byte) (elements [][]byte, err error) {
+	if len(response) == 0 || response[0] != '*' {
+		return nil, ERROR_BAD_BULK_FORMAT
+	}
+
+	elements, _, err = splitAggregate(response)
+	return elements, err
+}
+
+//Joins the given responses into a single multibulk response
+func JoinArrayResponse(elements [][]byte) []byte {
+	var response bytes.Buffer
+	response.WriteString("*" + strconv.Itoa(len(elements)) + "\r\n")
+	for _, element := range elements {
+		response.Write(element)
+	}
+	return response.Bytes()
+}
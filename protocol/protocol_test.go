@@ -148,6 +148,104 @@ func (test *ProtocolTester) verifyGoodCopyServerResponse(goodMessage, extraMessa
 	}
 }
 
+func TestReadServerResponses(test *testing.T) {
+	reader := bufio.NewReader(bytes.NewBufferString("+OK\r\n$4\r\ntest\r\n*2\r\n:1\r\n$-1\r\n"))
+
+	responses, err := ReadServerResponses(reader, 3)
+	if err != nil {
+		test.Fatalf("ReadServerResponses errored: %s", err)
+	}
+
+	expected := []string{"+OK\r\n", "$4\r\ntest\r\n", "*2\r\n:1\r\n$-1\r\n"}
+	for i, response := range responses {
+		if string(response) != expected[i] {
+			test.Errorf("Expected response %d to be %q, got %q", i, expected[i], response)
+		}
+	}
+
+	reader = bufio.NewReader(bytes.NewBufferString("+OK\r\n"))
+	responses, err = ReadServerResponses(reader, 2)
+	if err == nil {
+		test.Errorf("ReadServerResponses should have errored when the stream ended early")
+	}
+	if len(responses) != 1 {
+		test.Errorf("ReadServerResponses should have returned the one response it read, got %d", len(responses))
+	}
+}
+
+func TestGetArgs(test *testing.T) {
+	testData := []struct {
+		input string
+		args  []string
+	}{
+		{"*4\r\n$4\r\nmget\r\n$4\r\nkey1\r\n$4\r\nkey2\r\n$4\r\nkey3\r\n", []string{"key1", "key2", "key3"}},
+		{"*1\r\n$4\r\nping\r\n", []string{}},
+		{"*3\r\n$3\r\nset\r\n$-1\r\n$0\r\n\r\n", []string{"", ""}},
+		{"mget key1  key2\r\n", []string{"key1", "key2"}},
+		{"+ping\r\n", []string{}},
+		{"$4\r\nping\r\n", []string{}},
+	}
+
+	for _, data := range testData {
+		command, err := ParseCommand([]byte(data.input))
+		if err != nil {
+			test.Fatalf("Error parsing %q: %s", data.input, err)
+		}
+
+		args := command.GetArgs()
+		if len(args) != len(data.args) {
+			test.Errorf("Expected %d arguments from %q, got %d", len(data.args), data.input, len(args))
+			continue
+		}
+
+		for i, arg := range args {
+			if string(arg) != data.args[i] {
+				test.Errorf("Expected argument %d of %q to be %q, got %q", i, data.input, data.args[i], arg)
+			}
+			if string(command.GetArg(i)) != data.args[i] {
+				test.Errorf("Expected GetArg(%d) of %q to be %q, got %q", i, data.input, data.args[i], command.GetArg(i))
+			}
+		}
+	}
+}
+
+func TestNewMultibulkCommand(test *testing.T) {
+	command := NewMultibulkCommand([]byte("mget"), []byte("key1"), []byte("key2"))
+
+	if expected := "*3\r\n$4\r\nmget\r\n$4\r\nkey1\r\n$4\r\nkey2\r\n"; string(command.GetBuffer()) != expected {
+		test.Errorf("Expected buffer %q, got %q", expected, command.GetBuffer())
+	}
+
+	if string(command.GetFirstArg()) != "key1" || command.GetArgCount() != 2 {
+		test.Errorf("Built command did not parse as expected")
+	}
+}
+
+func TestSplitArrayResponse(test *testing.T) {
+	elements, err := SplitArrayResponse([]byte("*3\r\n$4\r\ntest\r\n$-1\r\n*1\r\n:1\r\n"))
+	if err != nil {
+		test.Fatalf("SplitArrayResponse errored: %s", err)
+	}
+
+	expected := []string{"$4\r\ntest\r\n", "$-1\r\n", "*1\r\n:1\r\n"}
+	if len(elements) != len(expected) {
+		test.Fatalf("Expected %d elements, got %d", len(expected), len(elements))
+	}
+	for i, element := range elements {
+		if string(element) != expected[i] {
+			test.Errorf("Expected element %d to be %q, got %q", i, expected[i], element)
+		}
+	}
+
+	if joined := JoinArrayResponse(elements); string(joined) != "*3\r\n"+strings.Join(expected, "") {
+		test.Errorf("JoinArrayResponse did not rebuild the response, got %q", joined)
+	}
+
+	if _, err := SplitArrayResponse([]byte("+OK\r\n")); err == nil {
+		test.Errorf("SplitArrayResponse should have errored on a non-multibulk response")
+	}
+}
+
 func BenchmarkGoodParseInt(bench *testing.B) {
 	for i := 0; i < bench.N; i++ {
 		ParseInt([]byte("12345"))
@@ -166,34 +264,34 @@ var testDataAllRedisCommands = []struct {
 	SupportsNonMux bool
 }{
 	{"append", true, true},
-	{"auth", false, false},
+	{"auth", true, true}, // answered by rmux itself
 	{"bgrewriteaof", false, false},
 	{"bgsave", false, false},
 	{"bitcount", true, true},
-	{"bitop", false, true}, // has a different format than other commands
+	{"bitop", true, true}, // its keys start after the operation, and must hash to the same pool
 	{"bitpos", true, true},
-	{"blpop", false, true},      // key [key ...] timeout
-	{"brpop", false, true},      // key [key ...] timeout
-	{"brpoplpush", false, true}, // source destination timeout - source and destination are keys
-	{"client", false, false},    // dangerous
+	{"blpop", true, true},      // rejected if its keys hash to several pools
+	{"brpop", true, true},      // rejected if its keys hash to several pools
+	{"brpoplpush", true, true}, // source and destination must hash to the same pool
+	{"client", true, true},      // only the subcommands about connections to rmux itself
 	{"cluster", false, false},   // dangerous
 	{"command", false, false},   // shouldn't need it
 	{"config", false, false},    // dangerous
-	{"dbsize", false, false},    // considered dangerous
+	{"dbsize", true, true},      // summed across pools
 	{"debug", false, false},     // dangerous
 	{"decr", true, true},
 	{"decrby", true, true},
 	{"del", true, true},
-	{"discard", false, false}, // dont support transactions
+	{"discard", true, true}, // transactions are pinned to a connection
 	{"dump", true, true},
 	{"echo", true, true},
-	{"eval", false, true}, // can operate on several keys
-	{"evalsha", false, true},
-	{"exec", false, false},
+	{"eval", true, true}, // rejected if its keys hash to several pools
+	{"evalsha", true, true},
+	{"exec", true, true},
 	{"exists", true, true},
 	{"expireat", true, true},
-	{"flushall", false, true},
-	{"flushdb", false, true},
+	{"flushall", true, true}, // only when flushes are allowed
+	{"flushdb", true, true},
 	{"get", true, true},
 	{"getbit", true, true},
 	{"getrange", true, true},
@@ -215,7 +313,7 @@ var testDataAllRedisCommands = []struct {
 	{"incrby", true, true},
 	{"incrbyfloat", true, true},
 	{"info", true, true},
-	{"keys", false, true},      // can glob many keys, not supported over mux
+	{"keys", true, true},       // concatenated across pools
 	{"lastsave", false, false}, // system related information
 	{"lindex", true, true},
 	{"linsert", true, true},
@@ -227,42 +325,43 @@ var testDataAllRedisCommands = []struct {
 	{"lrem", true, true},
 	{"lset", true, true},
 	{"ltrim", true, true},
-	{"mget", false, true},
+	{"mget", true, true},     // split up across connection pools when multiplexing
 	{"migrate", false, false}, // system related operation - dangerous
 	{"monitor", false, false}, // system related operation - dangerous
 	{"move", false, false},    // moves between dbs, let's not support
-	{"mset", false, true},     // should operate on multiple keys
-	{"multi", false, false},   // transaction related
-	{"object", false, false},  // to inspect internals
+	{"mset", true, true},      // split up across connection pools when multiplexing
+	{"msetnx", true, true},    // only when every key hashes to the same connection pool
+	{"multi", true, true},     // transaction related
+	{"object", true, true},
 	{"persist", true, true},
 	{"pexpire", true, true},
 	{"pexpireat", true, true},
 	{"pfadd", true, true},
 	{"pfcount", true, true},
-	{"pfmerge", false, true},
+	{"pfmerge", true, true},
 	{"ping", true, true},
 	{"psetex", true, true},
-	{"psubscribe", false, false},
+	{"psubscribe", true, true},
 	{"pubsub", false, false},
 	{"pttl", true, true},
 	{"publish", true, true},
-	{"punsubscribe", false, false},
+	{"punsubscribe", true, true},
 	{"quit", true, true},
 	{"randomkey", false, true},
-	{"rename", false, true},
-	{"renamenx", false, true},
+	{"rename", true, true},
+	{"renamenx", true, true},
 	{"restore", true, true},
 	{"role", false, false}, // returns role in replication
 	{"rpop", true, true},
-	{"rpoplpush", false, true},
+	{"rpoplpush", true, true},
 	{"rpush", true, true},
 	{"rpushx", true, true},
 	{"sadd", true, true},
 	{"save", false, false},
 	{"scard", true, true},
 	{"script", true, true},
-	{"sdiff", false, true},
-	{"sdiffstore", false, true},
+	{"sdiff", true, true},
+	{"sdiffstore", true, true},
 	{"select", true, true},
 	{"set", true, true},
 	{"setbit", true, true},
@@ -270,33 +369,35 @@ var testDataAllRedisCommands = []struct {
 	{"setnx", true, true},
 	{"setrange", true, true},
 	{"shutdown", false, false}, // system related operation - dangerous
-	{"sinter", false, true},
-	{"sinterstore", false, true},
+	{"sinter", true, true},
+	{"sinterstore", true, true},
 	{"sismember", true, true},
 	{"slaveof", false, false}, // system related operation - dangerous
 	{"slowlog", false, false}, // system related operation - dangerous
 	{"smembers", true, true},
-	{"smove", false, true},
+	{"smove", true, true},
 	{"sort", true, true},
 	{"spop", true, true},
 	{"srandmember", true, true},
 	{"srem", true, true},
 	{"strlen", true, true},
-	{"subscribe", false, false},
-	{"sunion", false, true},
-	{"sunionstore", false, true},
+	{"subscribe", true, true},
+	{"sunion", true, true},
+	{"sunionstore", true, true},
 	{"sync", false, false}, // used for replication
 	{"time", true, true},
+	{"touch", true, true},
 	{"ttl", true, true},
 	{"type", true, true},
-	{"unsubscribe", false, false},
-	{"unwatch", false, false}, // transaction related
-	{"watch", false, false},   // transaction related
+	{"unlink", true, true},
+	{"unsubscribe", true, true},
+	{"unwatch", true, true},   // transaction related
+	{"watch", true, true},     // rejected if its keys hash to several pools
 	{"zadd", true, true},
 	{"zcard", true, true},
 	{"zcount", true, true},
 	{"zincrby", true, true},
-	{"zinterstore", false, true},
+	{"zinterstore", true, true},
 	{"zlexcount", true, true},
 	{"zrange", true, true},
 	{"zrangebylex", true, true},
@@ -307,12 +408,12 @@ var testDataAllRedisCommands = []struct {
 	{"zremrangebylex", true, true},
 	{"zremrangebyrank", true, true},
 	{"zremrangebyscore", true, true},
-	{"zrevremrange", true, true},
+	{"zrevrange", true, true},
 	{"zrevrangebyscore", true, true},
 	{"zrevrank", true, true},
 	{"zscore", true, true},
-	{"zunionscore", false, true},
-	{"scan", false, true},
+	{"zunionstore", true, true},
+	{"scan", true, true},
 	{"sscan", true, true},
 	{"hscan", true, true},
 	{"zscan", true, true},
@@ -341,39 +442,13 @@ func TestIsSupportedFunction_NotMultipleKeys(test *testing.T) {
 }
 
 func TestIsSupportedFunction_MultipleKeys(test *testing.T) {
-	unsupportedWithMultipleKeys := map[string]bool{
-		// given multiple keys, the following should not be supported in mux mode
-		"bitop":       true,
-		"blpop":       true,
-		"brpop":       true,
-		"del":         true,
-		"mget":        true,
-		"pfcount":     true,
-		"pfmerge":     true,
-		"sdiff":       true,
-		"sdiffstore":  true,
-		"sinter":      true,
-		"sinterstore": true,
-		"sunion":      true,
-		"sunionstore": true,
-		"watch":       true,
-		"zinterstore": true,
-		"zunionstore": true,
-	}
-
+	// Commands with several keys are run in mux mode whenever they'd be run with one, and are only rejected later on
+	// if their keys hash to more than one pool
 	for _, command := range testDataAllRedisCommands {
 		bcommand := []byte(command.Command)
 
-		isSupported := IsSupportedFunction(bcommand, true, true)
-
-		if unsupportedWithMultipleKeys[command.Command] {
-			if isSupported {
-				test.Errorf("Should not be supported with multiple args in multiplexing mode but is: %s", command.Command)
-			}
-		} else {
-			if isSupported != command.SupportsMux {
-				test.Errorf("Should be supported if has multiple args in multiplexing mode but is not: %s", command.Command)
-			}
+		if isSupported := IsSupportedFunction(bcommand, true, true); isSupported != command.SupportsMux {
+			test.Errorf("Expected %s to be supported (%t) with multiple args in multiplexing mode, got %t", command.Command, command.SupportsMux, isSupported)
 		}
 	}
 }
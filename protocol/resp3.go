@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"strconv"
+)
+
+//How a command's RESP2 reply is typed in RESP3, for the replies that RESP3 gives a type of their own
+type ReplyType int
+
+const (
+	//The reply keeps its RESP2 shape, with its nils turned into RESP3 nulls
+	REPLY_DEFAULT ReplyType = iota
+	//A flat array of keys and values becomes a map
+	REPLY_MAP
+	//An array of flat arrays of keys and values becomes an array of maps
+	REPLY_MAPS
+	//An array becomes a set
+	REPLY_SET
+	//A bulk string holding a number becomes a double
+	REPLY_DOUBLE
+	//A flat array of fields and values becomes an array of [field, value] pairs
+	REPLY_PAIRS
+	//A flat array of members and scores becomes an array of [member, score] pairs, with each score a double
+	REPLY_SCORED_PAIRS
+	//An array that ends with a score, such as [member, score] or [key, member, score], has its score become a double
+	REPLY_SCORED_MEMBER
+)
+
+var (
+	RESP3_NULL = []byte("_\r\n")
+
+	//The commands whose replies are typed differently in RESP3, the same way that redis types them, whatever their
+	//arguments are.  The replies that depend on the arguments are typed by resp3ReplyType
+	resp3ReplyTypes = map[string]ReplyType{
+		"hgetall":  REPLY_MAP,
+		"hello":    REPLY_MAP,
+		"smembers": REPLY_SET,
+		"sinter":   REPLY_SET,
+		"sunion":   REPLY_SET,
+		"sdiff":    REPLY_SET,
+		"zscore":   REPLY_DOUBLE,
+		"zincrby":  REPLY_DOUBLE,
+		"bzpopmin": REPLY_SCORED_MEMBER,
+		"bzpopmax": REPLY_SCORED_MEMBER,
+	}
+
+	//The commands whose reply is a flat array of members and scores when they are given WITHSCORES
+	withScoresCommands = map[string]bool{
+		"zrange":           true,
+		"zrangebyscore":    true,
+		"zrevrange":        true,
+		"zrevrangebyscore": true,
+		"zunion":           true,
+		"zinter":           true,
+		"zdiff":            true,
+		"zrandmember":      true,
+	}
+)
+
+//Converts a RESP2 reply from redis into the RESP3 reply that the same command would have gotten
+//A nil command converts the reply without typing it
+func ConvertToResp3(command Command, reply []byte) []byte {
+	converted, err := convertToResp3(reply, resp3ReplyType(command))
+	if err != nil {
+		return reply
+	}
+	return converted
+}
+
+//Types the command's reply, by its subcommand or options where they change the reply's shape
+func resp3ReplyType(command Command) ReplyType {
+	if command == nil {
+		return REPLY_DEFAULT
+	}
+
+	name := string(bytes.ToLower(command.GetCommand()))
+	switch {
+	case name == "xinfo":
+		// Only XINFO STREAM is a map.  GROUPS and CONSUMERS are arrays of them
+		switch string(bytes.ToLower(command.GetFirstArg())) {
+		case "stream":
+			return REPLY_MAP
+		case "groups", "consumers":
+			return REPLY_MAPS
+		}
+		return REPLY_DEFAULT
+	case name == "zpopmin" || name == "zpopmax":
+		// A count makes it an array of pairs, even if it is 1
+		if command.GetArgCount() > 1 {
+			return REPLY_SCORED_PAIRS
+		}
+		return REPLY_SCORED_MEMBER
+	case name == "hrandfield" && hasOption(command, "withvalues"):
+		return REPLY_PAIRS
+	case withScoresCommands[name] && hasOption(command, "withscores"):
+		return REPLY_SCORED_PAIRS
+	}
+	return resp3ReplyTypes[name]
+}
+
+//Whether the command was given the option, anywhere after its first argument
+func hasOption(command Command, option string) bool {
+	for i := 1; i < command.GetArgCount(); i++ {
+		if bytes.EqualFold(command.GetArg(i), []byte(option)) {
+			return true
+		}
+	}
+	return false
+}
+
+func convertToResp3(reply []byte, replyType ReplyType) ([]byte, error) {
+	if len(reply) == 0 {
+		return reply, nil
+	}
+
+	switch reply[0] {
+	case '$':
+		if bytes.HasPrefix(reply, []byte("$-")) {
+			return RESP3_NULL, nil
+		} else if replyType == REPLY_DOUBLE {
+			return append(append([]byte{','}, bulkContents(reply)...), REDIS_NEWLINE...), nil
+		}
+	case '*':
+		elements, err := SplitArrayResponse(reply)
+		if err != nil {
+			return nil, err
+		} else if elements == nil {
+			return RESP3_NULL, nil
+		}
+
+		if (replyType == REPLY_PAIRS || replyType == REPLY_SCORED_PAIRS) && len(elements)%2 == 0 {
+			return convertPairs(elements, replyType == REPLY_SCORED_PAIRS)
+		}
+
+		for i, element := range elements {
+			elementType := REPLY_DEFAULT
+			if replyType == REPLY_MAPS {
+				elementType = REPLY_MAP
+			} else if replyType == REPLY_SCORED_MEMBER && i == len(elements)-1 {
+				elementType = REPLY_DOUBLE
+			}
+			if elements[i], err = convertToResp3(element, elementType); err != nil {
+				return nil, err
+			}
+		}
+
+		switch {
+		case replyType == REPLY_MAP && len(elements)%2 == 0:
+			return joinAggregate('%', len(elements)/2, elements), nil
+		case replyType == REPLY_SET:
+			return joinAggregate('~', len(elements), elements), nil
+		}
+		return joinAggregate('*', len(elements), elements), nil
+	}
+
+	return reply, nil
+}
+
+//Groups a flat array's elements into an array of pairs, with the second of each pair a double if it is a score
+func convertPairs(elements [][]byte, scored bool) ([]byte, error) {
+	valueType := REPLY_DEFAULT
+	if scored {
+		valueType = REPLY_DOUBLE
+	}
+
+	pairs := make([][]byte, 0, len(elements)/2)
+	for i := 0; i < len(elements); i += 2 {
+		key, err := convertToResp3(elements[i], REPLY_DEFAULT)
+		if err != nil {
+			return nil, err
+		}
+		value, err := convertToResp3(elements[i+1], valueType)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, joinAggregate('*', 2, [][]byte{key, value}))
+	}
+	return joinAggregate('*', len(pairs), pairs), nil
+}
+
+//Splits any kind of aggregate (array, map, set or push) up into its elements
+//Maps are split into their keys and values.  The count is the aggregate's own count, which is -1 for a nil array
+func splitAggregate(reply []byte) (elements [][]byte, count int, err error) {
+	newlinePos := bytes.Index(reply, REDIS_NEWLINE)
+	if newlinePos < 0 {
+		return nil, 0, ERROR_BAD_BULK_FORMAT
+	}
+
+	count, err = ParseInt(reply[1:newlinePos])
+	if err != nil || count < 0 {
+		return nil, count, err
+	}
+
+	numElements := count
+	if reply[0] == '%' {
+		numElements *= 2
+	}
+
+	elements = make([][]byte, 0, numElements)
+	rest := reply[newlinePos+2:]
+	for i := 0; i < numElements; i++ {
+		advance, token, err := ScanResp(rest, true)
+		if err != nil {
+			return nil, 0, err
+		} else if token == nil {
+			return nil, 0, ERROR_BAD_BULK_FORMAT
+		}
+
+		elements = append(elements, token)
+		rest = rest[advance:]
+	}
+
+	return elements, count, nil
+}
+
+func joinAggregate(prefix byte, count int, elements [][]byte) []byte {
+	var response bytes.Buffer
+	response.WriteByte(prefix)
+	response.WriteString(strconv.Itoa(count) + "\r\n")
+	for _, element := range elements {
+		response.Write(element)
+	}
+	return response.Bytes()
+}
+
+//Gets the contents of a length-prefixed string, such as $5\r\nhello\r\n
+func bulkContents(bulk []byte) []byte {
+	newlinePos := bytes.Index(bulk, REDIS_NEWLINE)
+	if newlinePos < 0 {
+		return nil
+	}
+	return bytes.TrimSuffix(bulk[newlinePos+2:], REDIS_NEWLINE)
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package protocol
+
+import (
+	"testing"
+)
+
+func TestConvertToResp3(test *testing.T) {
+	testData := []struct {
+		command  []string
+		reply    string
+		expected string
+	}{
+		{[]string{"get", "foo"}, "$-1\r\n", "_\r\n"},
+		{[]string{"get", "foo"}, "$3\r\nbar\r\n", "$3\r\nbar\r\n"},
+		{[]string{"mget", "a", "b"}, "*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+		{[]string{"blpop", "a", "0"}, "*-1\r\n", "_\r\n"},
+		{[]string{"HGETALL", "h"}, "*4\r\n$1\r\nf\r\n$1\r\n1\r\n$1\r\ng\r\n$1\r\n2\r\n", "%2\r\n$1\r\nf\r\n$1\r\n1\r\n$1\r\ng\r\n$1\r\n2\r\n"},
+		{[]string{"smembers", "s"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "~2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"zscore", "z", "a"}, "$3\r\n1.5\r\n", ",1.5\r\n"},
+		{[]string{"zscore", "z", "a"}, "$-1\r\n", "_\r\n"},
+		{[]string{"incrbyfloat", "f", "1.5"}, "$3\r\n1.5\r\n", "$3\r\n1.5\r\n"},
+		{[]string{"incr", "i"}, ":1\r\n", ":1\r\n"},
+		{[]string{"hgetall", "h"}, "-ERR wrong type\r\n", "-ERR wrong type\r\n"},
+		{nil, "*2\r\n$1\r\na\r\n$-1\r\n", "*2\r\n$1\r\na\r\n_\r\n"},
+
+		//XINFO STREAM is a map, while GROUPS and CONSUMERS are arrays of maps
+		{[]string{"xinfo", "stream", "s"}, "*2\r\n$6\r\nlength\r\n:2\r\n", "%1\r\n$6\r\nlength\r\n:2\r\n"},
+		{[]string{"XINFO", "GROUPS", "s"}, "*2\r\n*2\r\n$4\r\nname\r\n$2\r\ng1\r\n*2\r\n$4\r\nname\r\n$2\r\ng2\r\n",
+			"*2\r\n%1\r\n$4\r\nname\r\n$2\r\ng1\r\n%1\r\n$4\r\nname\r\n$2\r\ng2\r\n"},
+		{[]string{"xinfo", "consumers", "s", "g"}, "*1\r\n*2\r\n$4\r\nname\r\n$2\r\nc1\r\n", "*1\r\n%1\r\n$4\r\nname\r\n$2\r\nc1\r\n"},
+		{[]string{"xinfo", "help"}, "*1\r\n$4\r\nhelp\r\n", "*1\r\n$4\r\nhelp\r\n"},
+
+		//Members and scores are paired up, with each score a double
+		{[]string{"zrange", "z", "0", "-1", "WITHSCORES"}, "*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n",
+			"*2\r\n*2\r\n$1\r\na\r\n,1\r\n*2\r\n$1\r\nb\r\n,2\r\n"},
+		{[]string{"zrange", "z", "0", "-1"}, "*2\r\n$1\r\na\r\n$1\r\nb\r\n", "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{[]string{"zrandmember", "z", "1", "withscores"}, "*2\r\n$1\r\na\r\n$1\r\n1\r\n", "*1\r\n*2\r\n$1\r\na\r\n,1\r\n"},
+		{[]string{"zpopmin", "z", "1"}, "*2\r\n$1\r\na\r\n$1\r\n1\r\n", "*1\r\n*2\r\n$1\r\na\r\n,1\r\n"},
+		{[]string{"zpopmax", "z"}, "*2\r\n$1\r\na\r\n$1\r\n1\r\n", "*2\r\n$1\r\na\r\n,1\r\n"},
+		{[]string{"zpopmax", "z"}, "*0\r\n", "*0\r\n"},
+		{[]string{"bzpopmin", "z", "0"}, "*3\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\n1\r\n", "*3\r\n$1\r\nz\r\n$1\r\na\r\n,1\r\n"},
+
+		//Fields and values are paired up
+		{[]string{"hrandfield", "h", "-2", "WithValues"}, "*4\r\n$1\r\nf\r\n$1\r\n1\r\n$1\r\ng\r\n$1\r\n2\r\n",
+			"*2\r\n*2\r\n$1\r\nf\r\n$1\r\n1\r\n*2\r\n$1\r\ng\r\n$1\r\n2\r\n"},
+		{[]string{"hrandfield", "h", "2"}, "*2\r\n$1\r\nf\r\n$1\r\ng\r\n", "*2\r\n$1\r\nf\r\n$1\r\ng\r\n"},
+	}
+
+	for _, data := range testData {
+		var command Command
+		if data.command != nil {
+			args := make([][]byte, len(data.command)-1)
+			for i, arg := range data.command[1:] {
+				args[i] = []byte(arg)
+			}
+			command = NewMultibulkCommand([]byte(data.command[0]), args...)
+		}
+		if converted := ConvertToResp3(command, []byte(data.reply)); string(converted) != data.expected {
+			test.Errorf("Expected %v's reply %q to become %q, got %q", data.command, data.reply, data.expected, converted)
+		}
+	}
+}
@@ -53,6 +53,18 @@ func ScanResp(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		advance, token, err = ScanError(data, atEOF)
 	case '*':
 		advance, token, err = ScanArray(data, atEOF)
+	case '_', '#', ',', '(':
+		// RESP3 nulls, booleans, doubles and big numbers all fit on a single line
+		advance, token, err = scanNewline(data, atEOF)
+	case '=', '!':
+		// RESP3 verbatim strings and blob errors are sized the same way that bulk strings are
+		advance, token, err = scanBulk(data, atEOF)
+	case '%':
+		// A RESP3 map holds a key and a value for each of its entries
+		advance, token, err = scanAggregate(data, atEOF, 2)
+	case '~', '>':
+		// RESP3 sets and pushes are laid out the same way that arrays are
+		advance, token, err = scanAggregate(data, atEOF, 1)
 	default:
 		advance, token, err = ScanInlineString(data, atEOF)
 	}
@@ -120,6 +132,11 @@ func ScanBulkString(data []byte, atEOF bool) (advance int, token []byte, err err
 		return 0, nil, ERROR_COMMAND_PARSE
 	}
 
+	return scanBulk(data, atEOF)
+}
+
+//Scans a length-prefixed string, whatever its type prefix is
+func scanBulk(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	advance, token, err = scanNewline(data, atEOF)
 	if err != nil || advance == 0 {
 		return advance, token, err
@@ -198,6 +215,12 @@ func ScanArray(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		return 0, nil, ERROR_COMMAND_PARSE
 	}
 
+	return scanAggregate(data, atEOF, 1)
+}
+
+//Scans a count-prefixed aggregate, whatever its type prefix is, which holds elementsPerCount elements for each one
+//that it counts
+func scanAggregate(data []byte, atEOF bool, elementsPerCount int) (advance int, token []byte, err error) {
 	advance, token, err = scanNewline(data, atEOF)
 	if err != nil {
 		return 0, nil, err
@@ -218,6 +241,7 @@ func ScanArray(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if err != nil {
 		return 0, nil, err
 	}
+	arrayCount *= elementsPerCount
 
 	s := advance
 	rData := data[s:]
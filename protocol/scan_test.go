@@ -57,6 +57,17 @@ func TestScanResp(t *testing.T) {
 		{"$-1\r\n$-1\r\n", []string{"$-1\r\n", "$-1\r\n"}},
 		{"*2\r\n$-1\r\n$-1\r\n", []string{"*2\r\n$-1\r\n$-1\r\n"}},
 
+		// RESP3
+		{"_\r\n#t\r\n,3.14\r\n(3492890328409238509324850943850943825024385\r\n", []string{"_\r\n", "#t\r\n", ",3.14\r\n", "(3492890328409238509324850943850943825024385\r\n"}},
+		{"=15\r\ntxt:Some string\r\n!21\r\nSYNTAX invalid syntax\r\n", []string{"=15\r\ntxt:Some string\r\n", "!21\r\nSYNTAX invalid syntax\r\n"}},
+		{
+			"%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n~2\r\n,1.5\r\n_\r\n>2\r\n$7\r\nmessage\r\n#f\r\n",
+			[]string{
+				"%2\r\n+first\r\n:1\r\n$6\r\nsecond\r\n~2\r\n,1.5\r\n_\r\n",
+				">2\r\n$7\r\nmessage\r\n#f\r\n",
+			},
+		},
+
 		// Check for panic case in testing
 		{"$", []string{}},
 
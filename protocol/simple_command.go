@@ -70,3 +70,11 @@ func (this *SimpleCommand) GetFirstArg() []byte {
 func (this *SimpleCommand) GetArgCount() int {
 	return 0
 }
+
+func (this *SimpleCommand) GetArg(index int) []byte {
+	return nil
+}
+
+func (this *SimpleCommand) GetArgs() [][]byte {
+	return nil
+}
@@ -82,3 +82,11 @@ func (this *StringCommand) GetFirstArg() []byte {
 func (this *StringCommand) GetArgCount() int {
 	return 0
 }
+
+func (this *StringCommand) GetArg(index int) []byte {
+	return nil
+}
+
+func (this *StringCommand) GetArgs() [][]byte {
+	return nil
+}
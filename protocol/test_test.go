@@ -66,6 +66,14 @@ func (this *commandTester) checkCommandOutput(expects commandTestData, command C
 		this.Errorf("Expected parsed arg1 to match. Expected %q, got %q", expects.arg1, command.GetFirstArg())
 	}
 
+	if bytes.Compare(command.GetFirstArg(), command.GetArg(0)) != 0 {
+		this.Errorf("Expected GetArg(0) to match the first argument. Expected %q, got %q", command.GetFirstArg(), command.GetArg(0))
+	}
+
+	if expects.argCount != len(command.GetArgs()) {
+		this.Errorf("GetArgs() did not return every argument.\r\nExpected: %d\r\nGot: %d", expects.argCount, len(command.GetArgs()))
+	}
+
 	if expects.argCount != command.GetArgCount() {
 		this.Errorf("GetArgCount() did not match expectations.\r\nExpected: %d\r\nGot: %d", expects.argCount, command.GetArgCount())
 	}
@@ -0,0 +1,363 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/salesforce/rmux/connection"
+	. "github.com/salesforce/rmux/log"
+	"github.com/salesforce/rmux/protocol"
+	"strconv"
+)
+
+//A message that redis pushed to a subscribed client, or the error that ended the client's subscriptions
+type pushItem struct {
+	message []byte
+	err     error
+}
+
+//The channels and patterns that a client is subscribed to, and the connection pools that they were subscribed on
+//The upstream subscriptions themselves are held by the server's subscriptionHub, and shared with its other clients
+type subscriber struct {
+	channels map[string][]*connection.ConnectionPool
+	patterns map[string][]*connection.ConnectionPool
+}
+
+var (
+	PSUBSCRIBE_COMMAND   = []byte("psubscribe")
+	PUNSUBSCRIBE_COMMAND = []byte("punsubscribe")
+
+	//Pushed messages, as redis sends them
+	MESSAGE_PREFIX  = []byte("*3\r\n$7\r\nmessage\r\n")
+	PMESSAGE_PREFIX = []byte("*4\r\n$8\r\npmessage\r\n")
+
+	//Commands that put a client into (or take it out of) subscriber mode
+	subscriptionCommands = map[string]bool{
+		"subscribe":    true,
+		"psubscribe":   true,
+		"unsubscribe":  true,
+		"punsubscribe": true,
+	}
+)
+
+func newSubscriber() *subscriber {
+	return &subscriber{
+		channels: make(map[string][]*connection.ConnectionPool),
+		patterns: make(map[string][]*connection.ConnectionPool),
+	}
+}
+//Whether or not the client has any subscriptions
+func (this *Client) IsSubscribed() bool {
+	return this.subscriber != nil
+}
+
+//Whether or not the command needs to be handled by HandleSubscriberCommand, instead of being sent to redis
+func (this *Client) IsSubscriberCommand(command protocol.Command) bool {
+	if this.IsSubscribed() {
+		// Quitting works the same way whether or not the client is subscribed
+		return !bytes.Equal(command.GetCommand(), protocol.QUIT_COMMAND)
+	}
+	return subscriptionCommands[string(command.GetCommand())]
+}
+
+//Handles a command from a client that is subscribed, or is about to subscribe
+//Replies are buffered on the client's writer, and get flushed along with the rest of the client's pipeline
+func (this *Client) HandleSubscriberCommand(command protocol.Command) error {
+	name := command.GetCommand()
+	if subscriptionCommands[string(name)] {
+		if err := protocol.CheckCommand(command, this.Multiplexing); err != nil {
+			return this.WriteError(err, false)
+		}
+	}
+
+	switch {
+	case bytes.Equal(name, protocol.SUBSCRIBE_COMMAND):
+		return this.subscribe(command.GetArgs(), false)
+	case bytes.Equal(name, PSUBSCRIBE_COMMAND):
+		return this.subscribe(command.GetArgs(), true)
+	case bytes.Equal(name, protocol.UNSUBSCRIBE_COMMAND):
+		return this.unsubscribe(command.GetArgs(), false)
+	case bytes.Equal(name, PUNSUBSCRIBE_COMMAND):
+		return this.unsubscribe(command.GetArgs(), true)
+	case bytes.Equal(name, protocol.PING_COMMAND):
+		// Subscribed clients get their pong as a push, the way redis sends it
+		_, err := this.Writer.Write(protocol.JoinArrayResponse([][]byte{bulkString([]byte("pong")), bulkString(append([]byte{}, command.GetFirstArg()...))}))
+		return err
+	}
+
+	return protocol.WriteError([]byte(fmt.Sprintf("Can't execute '%s': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context", name)), this.Writer, false)
+}
+
+//Gets the connection pools that a channel or pattern is subscribed on
+//Channels are hashed the same way that PUBLISH is, so that publishers and subscribers meet on the same redis server.
+//A pattern can match channels on any server, so it is subscribed on all of them
+func (this *Client) subscriptionPools(name []byte, isPattern bool) ([]*connection.ConnectionPool, error) {
+	if !this.Multiplexing {
+		return []*connection.ConnectionPool{this.HashRing.DefaultConnectionPool}, nil
+	} else if isPattern {
+		return this.HashRing.GetConnectionPools(), nil
+	}
+
+	connectionPool, err := this.HashRing.GetConnectionPoolForKey(name)
+	if err != nil {
+		return nil, err
+	}
+	return []*connection.ConnectionPool{connectionPool}, nil
+}
+
+func (this *Client) subscribe(names [][]byte, isPattern bool) error {
+	if this.subscriber == nil {
+		this.subscriber = newSubscriber()
+	}
+	sub := this.subscriber
+
+	kind, subscriptions := protocol.SUBSCRIBE_COMMAND, sub.channels
+	if isPattern {
+		kind, subscriptions = PSUBSCRIBE_COMMAND, sub.patterns
+	}
+
+	//Subscribe through the hub, grouped by connection pool
+	namePools := make([][]*connection.ConnectionPool, len(names))
+	poolNames := make(map[*connection.ConnectionPool][][]byte)
+	for i, name := range names {
+		if _, ok := subscriptions[string(name)]; ok {
+			continue
+		}
+
+		pools, err := this.subscriptionPools(name, isPattern)
+		if err != nil {
+			continue
+		}
+		namePools[i] = pools
+		for _, connectionPool := range pools {
+			poolNames[connectionPool] = append(poolNames[connectionPool], name)
+		}
+	}
+	failedPools := this.subscriptionHub.subscribe(this, isPattern, poolNames)
+
+	//Confirm each subscription, in the order they were asked for
+	var abandoned map[*connection.ConnectionPool][][]byte
+	for i, name := range names {
+		if _, ok := subscriptions[string(name)]; !ok {
+			if !subscribedEverywhere(namePools[i], failedPools) {
+				//Don't hold on to a pattern on the pools that it did get subscribed on
+				for _, connectionPool := range namePools[i] {
+					if abandoned == nil {
+						abandoned = make(map[*connection.ConnectionPool][][]byte)
+					}
+					abandoned[connectionPool] = append(abandoned[connectionPool], name)
+				}
+				this.WriteError(ERR_CONNECTION_DOWN, false)
+				continue
+			}
+			subscriptions[string(name)] = namePools[i]
+		}
+
+		if _, err := this.Writer.Write(this.subscriptionReply(kind, name, sub.count())); err != nil {
+			return err
+		}
+	}
+	if abandoned != nil {
+		this.subscriptionHub.unsubscribe(this, isPattern, abandoned)
+	}
+
+	if sub.count() == 0 {
+		this.closeSubscriptions()
+	}
+	return nil
+}
+
+func (this *Client) unsubscribe(names [][]byte, isPattern bool) error {
+	kind := protocol.UNSUBSCRIBE_COMMAND
+	if isPattern {
+		kind = PUNSUBSCRIBE_COMMAND
+	}
+
+	sub := this.subscriber
+	if sub == nil {
+		// Not subscribed to anything, so there's nothing to tell redis
+		if len(names) == 0 {
+			_, err := this.Writer.Write(this.subscriptionReply(kind, nil, 0))
+			return err
+		}
+		for _, name := range names {
+			if _, err := this.Writer.Write(this.subscriptionReply(kind, name, 0)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	subscriptions := sub.channels
+	if isPattern {
+		subscriptions = sub.patterns
+	}
+
+	//With no names given, everything is unsubscribed from
+	if len(names) == 0 {
+		for name := range subscriptions {
+			names = append(names, []byte(name))
+		}
+		if len(names) == 0 {
+			_, err := this.Writer.Write(this.subscriptionReply(kind, nil, sub.count()))
+			return err
+		}
+	}
+
+	this.subscriptionHub.unsubscribe(this, isPattern, groupSubscriptionsByPool(subscriptions, names))
+
+	for _, name := range names {
+		delete(subscriptions, string(name))
+		if _, err := this.Writer.Write(this.subscriptionReply(kind, name, sub.count())); err != nil {
+			return err
+		}
+	}
+
+	if sub.count() == 0 {
+		this.closeSubscriptions()
+	}
+	return nil
+}
+
+//Writes a pushed message out to the client.  Messages that arrive after the client has unsubscribed are dropped
+func (this *Client) WritePush(item pushItem) error {
+	if !this.IsSubscribed() {
+		return nil
+	}
+
+	if item.err != nil {
+		// The client can't know which of its subscriptions were lost, so it has to start over
+		this.closeSubscriptions()
+		return item.err
+	}
+
+	_, err := this.Writer.Write(this.pushFrame(item.message))
+	return err
+}
+
+//Drops all of the client's subscriptions, letting the hub unsubscribe upstream from the ones that no one else needs
+func (this *Client) closeSubscriptions() {
+	sub := this.subscriber
+	if sub == nil {
+		return
+	}
+	this.subscriber = nil
+
+	this.subscriptionHub.unsubscribe(this, false, groupSubscriptionsByPool(sub.channels, nil))
+	this.subscriptionHub.unsubscribe(this, true, groupSubscriptionsByPool(sub.patterns, nil))
+
+	//Drop anything that was pushed before the subscriptions were dropped
+	for {
+		select {
+		case <-this.PushChannel:
+		default:
+			return
+		}
+	}
+}
+
+//Hands a pushed message to the client's goroutine
+//A client that can't keep up with its messages is disconnected, rather than holding up every other subscriber
+func (this *Client) push(item pushItem) {
+	select {
+	case this.PushChannel <- item:
+	default:
+		Warn("Disconnecting a subscribed client that is not keeping up with its messages")
+		this.Connection.Close()
+	}
+}
+
+//The number of channels and patterns that the client is subscribed to
+func (this *subscriber) count() int {
+	return len(this.channels) + len(this.patterns)
+}
+
+//Groups the given subscriptions (or all of them, if names is nil) by the connection pools they were subscribed on
+func groupSubscriptionsByPool(subscriptions map[string][]*connection.ConnectionPool, names [][]byte) map[*connection.ConnectionPool][][]byte {
+	if names == nil {
+		for name := range subscriptions {
+			names = append(names, []byte(name))
+		}
+	}
+
+	poolNames := make(map[*connection.ConnectionPool][][]byte)
+	for _, name := range names {
+		for _, connectionPool := range subscriptions[string(name)] {
+			poolNames[connectionPool] = append(poolNames[connectionPool], name)
+		}
+	}
+	return poolNames
+}
+
+//Whether or not a subscription was made on all of its connection pools
+func subscribedEverywhere(pools []*connection.ConnectionPool, failedPools map[*connection.ConnectionPool]bool) bool {
+	if len(pools) == 0 {
+		return false
+	}
+	for _, connectionPool := range pools {
+		if failedPools[connectionPool] {
+			return false
+		}
+	}
+	return true
+}
+
+//Formats a (p)(un)subscribe confirmation
+func subscriptionReply(kind, name []byte, count int) []byte {
+	return protocol.JoinArrayResponse([][]byte{bulkString(kind), bulkString(name), []byte(":" + strconv.Itoa(count) + "\r\n")})
+}
+
+//Formats a (p)(un)subscribe confirmation in the client's RESP version
+func (this *Client) subscriptionReply(kind, name []byte, count int) []byte {
+	return this.pushFrame(subscriptionReply(kind, name, count))
+}
+
+//RESP3 clients get pushed messages (and subscription confirmations) as push frames instead of arrays
+func (this *Client) pushFrame(message []byte) []byte {
+	if this.Protocol != 3 || len(message) == 0 || message[0] != '*' {
+		return message
+	}
+	// The message is shared with the other subscribers, so it can't be changed in place
+	return append([]byte{'>'}, message[1:]...)
+}
+
+//Gets the contents of a bulk string, such as $5\r\nhello\r\n
+func bulkContents(bulk []byte) []byte {
+	newlinePos := bytes.Index(bulk, protocol.REDIS_NEWLINE)
+	if len(bulk) == 0 || bulk[0] != '$' || newlinePos < 0 {
+		return nil
+	}
+	return bytes.TrimSuffix(bulk[newlinePos+2:], protocol.REDIS_NEWLINE)
+}
+
+//Formats a bulk string, or a nil bulk string if the value is nil
+func bulkString(value []byte) []byte {
+	if value == nil {
+		return protocol.NIL_BULK_RESPONSE
+	}
+	return []byte("$" + strconv.Itoa(len(value)) + "\r\n" + string(value) + "\r\n")
+}
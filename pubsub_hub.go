@@ -0,0 +1,320 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/salesforce/rmux/connection"
+	. "github.com/salesforce/rmux/log"
+	"github.com/salesforce/rmux/protocol"
+	. "github.com/salesforce/rmux/writer"
+	"sync"
+)
+
+//Holds the upstream subscriptions of every client of a server, so that many local subscribers to the same channel share
+//a single upstream subscription.  The lock only guards the hub's maps: connections dial, and (un)subscribe commands are
+//written, without it, so that a pool that is slow or down doesn't hold up the messages from the others
+type subscriptionHub struct {
+	lock sync.Mutex
+	//One subscribed connection per connection pool
+	connections map[*connection.ConnectionPool]*hubConnection
+}
+
+//A connection that holds upstream subscriptions on a single connection pool, along with the local clients of each
+//channel and pattern.  The number of clients is each subscription's reference count
+type hubConnection struct {
+	hub            *subscriptionHub
+	connectionPool *connection.ConnectionPool
+	redisConn      *connection.Connection
+	channels       map[string]map[*Client]bool
+	patterns       map[string]map[*Client]bool
+	//Closed once the connection has dialed, after which err says whether it failed
+	connected chan struct{}
+	err       error
+	//The (un)subscribe commands that are waiting to be written, in the order that the maps were changed in
+	pending [][]byte
+	//Wakes up writeLoop when there are pending commands
+	wake chan bool
+	//Closed once the connection has failed
+	done chan struct{}
+}
+
+func newSubscriptionHub() *subscriptionHub {
+	return &subscriptionHub{connections: make(map[*connection.ConnectionPool]*hubConnection)}
+}
+
+//Subscribes the client to the given channels (or patterns) on each pool, subscribing upstream to the ones that no other
+//client is subscribed to yet.  Returns the pools that could not be subscribed on
+func (this *subscriptionHub) subscribe(client *Client, isPattern bool, poolNames map[*connection.ConnectionPool][][]byte) map[*connection.ConnectionPool]bool {
+	failedPools := make(map[*connection.ConnectionPool]bool)
+	for connectionPool, names := range poolNames {
+		hubConn, err := this.getConnection(connectionPool)
+		if err != nil {
+			Error("Failed to connect to %s for subscriptions: %s", connectionPool.Endpoint, err)
+			failedPools[connectionPool] = true
+			continue
+		}
+
+		this.lock.Lock()
+		if this.connections[connectionPool] != hubConn {
+			// It failed while this client was waiting for it to connect
+			this.lock.Unlock()
+			failedPools[connectionPool] = true
+			continue
+		}
+
+		subscriptions := hubConn.subscriptions(isPattern)
+		var newNames [][]byte
+		for _, name := range names {
+			if subscriptions[string(name)] == nil {
+				newNames = append(newNames, name)
+				subscriptions[string(name)] = make(map[*Client]bool)
+			}
+			subscriptions[string(name)][client] = true
+		}
+
+		if len(newNames) > 0 {
+			kind := protocol.SUBSCRIBE_COMMAND
+			if isPattern {
+				kind = PSUBSCRIBE_COMMAND
+			}
+			hubConn.send(kind, newNames)
+		}
+		this.lock.Unlock()
+	}
+
+	return failedPools
+}
+
+//Drops the client's subscriptions to the given channels (or patterns) on each pool, unsubscribing upstream from the
+//ones that no other client is subscribed to anymore
+func (this *subscriptionHub) unsubscribe(client *Client, isPattern bool, poolNames map[*connection.ConnectionPool][][]byte) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for connectionPool, names := range poolNames {
+		hubConn, ok := this.connections[connectionPool]
+		if !ok {
+			continue
+		}
+
+		subscriptions := hubConn.subscriptions(isPattern)
+		var unusedNames [][]byte
+		for _, name := range names {
+			clients, ok := subscriptions[string(name)]
+			if !ok || !clients[client] {
+				continue
+			}
+
+			delete(clients, client)
+			if len(clients) == 0 {
+				delete(subscriptions, string(name))
+				unusedNames = append(unusedNames, name)
+			}
+		}
+
+		if len(unusedNames) > 0 {
+			kind := protocol.UNSUBSCRIBE_COMMAND
+			if isPattern {
+				kind = PUNSUBSCRIBE_COMMAND
+			}
+			hubConn.send(kind, unusedNames)
+		}
+	}
+}
+
+//Drops every subscribed connection that holds a channel which the hash ring no longer sends to its pool, such as once a
+//pool is drained or enabled, so that its clients are told that their subscriptions are gone instead of silently missing
+//what is published on the pool that their channels moved to
+func (this *subscriptionHub) rehash(hashRing *connection.HashRing) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+
+	for connectionPool, hubConn := range this.connections {
+		for channel := range hubConn.channels {
+			if routedPool, err := hashRing.GetConnectionPoolForKey([]byte(channel)); err != nil || routedPool != connectionPool {
+				Info("Dropping the subscriptions on %s, since its channels have moved", connectionPool.Endpoint)
+				hubConn.fail()
+				break
+			}
+		}
+	}
+}
+
+//Gets the pool's subscribed connection, connecting it if need be.  The dial is made without the hub's lock, and any
+//other clients that want the same connection wait for it to finish
+func (this *subscriptionHub) getConnection(connectionPool *connection.ConnectionPool) (*hubConnection, error) {
+	this.lock.Lock()
+	hubConn, ok := this.connections[connectionPool]
+	if !ok {
+		hubConn = &hubConnection{
+			hub:            this,
+			connectionPool: connectionPool,
+			channels:       make(map[string]map[*Client]bool),
+			patterns:       make(map[string]map[*Client]bool),
+			connected:      make(chan struct{}),
+			wake:           make(chan bool, 1),
+			done:           make(chan struct{}),
+		}
+		this.connections[connectionPool] = hubConn
+	}
+	this.lock.Unlock()
+
+	if !ok {
+		hubConn.connect()
+	}
+	<-hubConn.connected
+	if hubConn.err != nil {
+		return nil, hubConn.err
+	}
+	return hubConn, nil
+}
+
+//Dials the connection, and starts reading and writing on it.  Drops it from the hub if it fails to connect
+func (this *hubConnection) connect() {
+	defer close(this.connected)
+
+	// Subscribed connections sit idle until something is published, so they are never given a read timeout
+	redisConn := this.connectionPool.CreateConnection()
+	redisConn.SetReadTimeout(0)
+	if this.err = redisConn.ReconnectIfNecessary(); this.err != nil {
+		this.hub.lock.Lock()
+		delete(this.hub.connections, this.connectionPool)
+		this.hub.lock.Unlock()
+		return
+	}
+
+	this.redisConn = redisConn
+	go this.readLoop(redisConn.Reader)
+	go this.writeLoop(redisConn.Writer)
+}
+
+func (this *hubConnection) subscriptions(isPattern bool) map[string]map[*Client]bool {
+	if isPattern {
+		return this.patterns
+	}
+	return this.channels
+}
+
+//Queues a (un)subscribe command to be written upstream by writeLoop.  Must be called with the hub's lock held
+func (this *hubConnection) send(kind []byte, names [][]byte) {
+	this.pending = append(this.pending, protocol.NewMultibulkCommand(kind, names...).GetBuffer())
+	select {
+	case this.wake <- true:
+	default:
+	}
+}
+
+//Writes the queued (un)subscribe commands upstream, in order, until the connection fails
+func (this *hubConnection) writeLoop(writer *FlexibleWriter) {
+	for {
+		select {
+		case <-this.wake:
+		case <-this.done:
+			return
+		}
+
+		this.hub.lock.Lock()
+		pending := this.pending
+		this.pending = nil
+		this.hub.lock.Unlock()
+
+		var err error
+		for _, command := range pending {
+			if _, err = writer.Write(command); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = writer.Flush()
+		}
+		if err != nil {
+			Error("Failed to update the subscriptions on %s: %s", this.connectionPool.Endpoint, err)
+			this.hub.lock.Lock()
+			this.fail()
+			this.hub.lock.Unlock()
+			return
+		}
+	}
+}
+
+//Drops the connection from the hub, and lets every client that was subscribed over it know that its subscriptions are
+//gone.  Must be called with the hub's lock held, once the connection has connected
+func (this *hubConnection) fail() {
+	if this.hub.connections[this.connectionPool] != this {
+		return
+	}
+	delete(this.hub.connections, this.connectionPool)
+	close(this.done)
+	this.redisConn.Disconnect()
+
+	clients := make(map[*Client]bool)
+	for _, subscriptions := range []map[string]map[*Client]bool{this.channels, this.patterns} {
+		for _, subscribers := range subscriptions {
+			for client := range subscribers {
+				clients[client] = true
+			}
+		}
+	}
+	for client := range clients {
+		client.push(pushItem{err: ERR_CONNECTION_DOWN})
+	}
+}
+
+//Passes the messages that redis pushes on to every local client that is subscribed to their channel or pattern
+//Redis's own (un)subscribe confirmations are dropped, since the clients' confirmations are made locally
+func (this *hubConnection) readLoop(reader *bufio.Reader) {
+	scanner := protocol.NewRespScanner(reader)
+	for scanner.Scan() {
+		var subscriptions map[string]map[*Client]bool
+		if bytes.HasPrefix(scanner.Bytes(), MESSAGE_PREFIX) {
+			subscriptions = this.channels
+		} else if bytes.HasPrefix(scanner.Bytes(), PMESSAGE_PREFIX) {
+			subscriptions = this.patterns
+		} else {
+			continue
+		}
+
+		elements, err := protocol.SplitArrayResponse(scanner.Bytes())
+		if err != nil || len(elements) < 3 {
+			continue
+		}
+
+		message := make([]byte, len(scanner.Bytes()))
+		copy(message, scanner.Bytes())
+
+		this.hub.lock.Lock()
+		for client := range subscriptions[string(bulkContents(elements[1]))] {
+			client.push(pushItem{message: message})
+		}
+		this.hub.lock.Unlock()
+	}
+
+	this.hub.lock.Lock()
+	this.fail()
+	this.hub.lock.Unlock()
+}
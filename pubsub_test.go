@@ -0,0 +1,306 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Answers subscriptions the way redis does, then pushes one message per channel (or pattern) naming the pool it came from
+func pubsubHandler(pool string) func(protocol.Command) string {
+	return func(command protocol.Command) string {
+		kind := string(command.GetCommand())
+		response := ""
+		for i, arg := range command.GetArgs() {
+			response += "*3\r\n" + bulkReply(kind) + bulkReply(string(arg)) + ":" + string('1'+byte(i)) + "\r\n"
+			switch kind {
+			case "subscribe":
+				response += "*3\r\n" + bulkReply("message") + bulkReply(string(arg)) + bulkReply(pool)
+			case "psubscribe":
+				response += "*4\r\n" + bulkReply("pmessage") + bulkReply(string(arg)) + bulkReply("matched") + bulkReply(pool)
+			}
+		}
+		return response
+	}
+}
+
+//Waits for the given number of pushed messages, and writes them out to the client
+func receivePushes(t *testing.T, client *Client, count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case item := <-client.PushChannel:
+			if err := client.WritePush(item); err != nil {
+				t.Fatalf("Error writing pushed message: %s", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for pushed message %d", i+1)
+		}
+	}
+	client.Writer.Flush()
+}
+
+func TestSubscribeAcrossPools(t *testing.T) {
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxPubsubTest1.sock", pubsubHandler("pool1"))
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxPubsubTest2.sock", pubsubHandler("pool2"))
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxPubsubTest.sock", "/tmp/rmuxPubsubTest1.sock", "/tmp/rmuxPubsubTest2.sock")
+	defer server.Listener.Close()
+
+	channels := []string{"a", "b", "c", "d"}
+	expectedPools := map[string]string{}
+	for _, channel := range channels {
+		pool, _ := server.HashRing.GetConnectionPoolForKey([]byte(channel))
+		expectedPools[channel] = "pool1"
+		if pool != server.ConnectionCluster[0] {
+			expectedPools[channel] = "pool2"
+		}
+	}
+
+	client, output := newTestClient(server)
+	defer client.closeSubscriptions()
+	runClientCommand(t, server, client, append([]string{"subscribe"}, channels...)...)
+
+	expected := ""
+	for i, channel := range channels {
+		expected += string(subscriptionReply(protocol.SUBSCRIBE_COMMAND, []byte(channel), i+1))
+	}
+	if output.String() != expected {
+		t.Errorf("Unexpected subscribe confirmations.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	if !client.IsSubscribed() {
+		t.Fatalf("The client should be subscribed")
+	}
+
+	//Each channel should have been subscribed on the pool that PUBLISH hashes it to
+	output.Reset()
+	receivePushes(t, client, len(channels))
+	for _, channel := range channels {
+		message := "*3\r\n" + bulkReply("message") + bulkReply(channel) + bulkReply(expectedPools[channel])
+		if !strings.Contains(output.String(), message) {
+			t.Errorf("Expected channel %s to get a message from %s, got %q", channel, expectedPools[channel], output.String())
+		}
+	}
+
+	//Patterns are subscribed on every pool
+	output.Reset()
+	runClientCommand(t, server, client, "psubscribe", "news.*")
+	if expected := string(subscriptionReply(PSUBSCRIBE_COMMAND, []byte("news.*"), 5)); output.String() != expected {
+		t.Errorf("Unexpected psubscribe confirmation.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	output.Reset()
+	receivePushes(t, client, 2)
+	for _, pool := range []string{"pool1", "pool2"} {
+		if !strings.Contains(output.String(), bulkReply("matched")+bulkReply(pool)) {
+			t.Errorf("Expected a pattern message from %s, got %q", pool, output.String())
+		}
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "ping")
+	if expected := "*2\r\n" + bulkReply("pong") + bulkReply(""); output.String() != expected {
+		t.Errorf("Unexpected subscribed ping reply.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "get", "a")
+	if !strings.HasPrefix(output.String(), "-ERR Can't execute 'get'") {
+		t.Errorf("Subscribed clients should not be able to run other commands, got %q", output.String())
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "unsubscribe", "a")
+	if expected := string(subscriptionReply(protocol.UNSUBSCRIBE_COMMAND, []byte("a"), 4)); output.String() != expected {
+		t.Errorf("Unexpected unsubscribe confirmation.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+
+	runClientCommand(t, server, client, "unsubscribe")
+	runClientCommand(t, server, client, "punsubscribe")
+	if client.IsSubscribed() {
+		t.Errorf("The client should have left subscriber mode once it unsubscribed from everything")
+	}
+}
+
+func TestUnsubscribeWithoutSubscriptions(t *testing.T) {
+	listener := StartMockRedisServer(t, "/tmp/rmuxPubsubTest1.sock", pubsubHandler("pool1"))
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxPubsubTest.sock", "/tmp/rmuxPubsubTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	runClientCommand(t, server, client, "unsubscribe")
+	if expected := string(subscriptionReply(protocol.UNSUBSCRIBE_COMMAND, nil, 0)); output.String() != expected {
+		t.Errorf("Unexpected unsubscribe reply.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	if client.IsSubscribed() {
+		t.Errorf("The client should not be subscribed")
+	}
+}
+
+func TestSubscriptionsAreShared(t *testing.T) {
+	//Records every (un)subscribe that reaches redis, and announces each new subscription on the "news" channel
+	var lock sync.Mutex
+	var upstream []string
+	listener := StartMockRedisServer(t, "/tmp/rmuxPubsubTest1.sock", func(command protocol.Command) string {
+		kind := string(command.GetCommand())
+		response := ""
+		for i, arg := range command.GetArgs() {
+			lock.Lock()
+			upstream = append(upstream, kind+" "+string(arg))
+			lock.Unlock()
+
+			response += "*3\r\n" + bulkReply(kind) + bulkReply(string(arg)) + ":" + string('1'+byte(i)) + "\r\n"
+			if kind == "subscribe" {
+				response += "*3\r\n" + bulkReply("message") + bulkReply("news") + bulkReply(string(arg))
+			}
+		}
+		return response
+	})
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxPubsubTest.sock", "/tmp/rmuxPubsubTest1.sock")
+	defer server.Listener.Close()
+
+	client1, output1 := newTestClient(server)
+	defer client1.closeSubscriptions()
+	client2, output2 := newTestClient(server)
+	defer client2.closeSubscriptions()
+
+	runClientCommand(t, server, client1, "subscribe", "news")
+	receivePushes(t, client1, 1)
+
+	//The second subscriber to "news" shares the first one's upstream subscription
+	runClientCommand(t, server, client2, "subscribe", "news", "sports")
+	if expected := string(subscriptionReply(protocol.SUBSCRIBE_COMMAND, []byte("news"), 1)) +
+		string(subscriptionReply(protocol.SUBSCRIBE_COMMAND, []byte("sports"), 2)); output2.String() != expected {
+		t.Errorf("Unexpected subscribe confirmations.\r\nExpected %q\r\nGot      %q", expected, output2.String())
+	}
+
+	//Both subscribers get the message announcing "sports"
+	output1.Reset()
+	output2.Reset()
+	receivePushes(t, client1, 1)
+	receivePushes(t, client2, 1)
+	message := "*3\r\n" + bulkReply("message") + bulkReply("news") + bulkReply("sports")
+	if output1.String() != message || output2.String() != message {
+		t.Errorf("Expected both subscribers to get %q, got %q and %q", message, output1.String(), output2.String())
+	}
+
+	//Redis is only told to unsubscribe once the last subscriber leaves
+	runClientCommand(t, server, client1, "unsubscribe", "news")
+	runClientCommand(t, server, client2, "unsubscribe")
+
+	expected := []string{"subscribe news", "subscribe sports", "unsubscribe news", "unsubscribe sports"}
+	deadline := time.Now().Add(time.Second)
+	for {
+		lock.Lock()
+		got := append([]string(nil), upstream...)
+		lock.Unlock()
+
+		if len(got) >= len(expected) || time.Now().After(deadline) {
+			if len(got) != len(expected) || got[0] != expected[0] || got[1] != expected[1] ||
+				!(got[2] == expected[2] && got[3] == expected[3] || got[2] == expected[3] && got[3] == expected[2]) {
+				t.Errorf("Expected redis to see %v, got %v", expected, got)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSlowSubscribeDoesNotHoldUpOtherPools(t *testing.T) {
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxPubsubTest1.sock", pubsubHandler("pool1"))
+	defer listener1.Close()
+	//The second redis never gets past AUTH, until the test is over
+	release := make(chan struct{})
+	subscribe := pubsubHandler("pool2")
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxPubsubTest2.sock", func(command protocol.Command) string {
+		if string(command.GetCommand()) == "auth" {
+			<-release
+			return "+OK\r\n"
+		}
+		return subscribe(command)
+	})
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxPubsubTest.sock", "/tmp/rmuxPubsubTest1.sock", "/tmp/rmuxPubsubTest2.sock")
+	defer server.Listener.Close()
+	server.ConnectionCluster[1].Credentials.Password = "password"
+
+	var channels []string
+	for i := 0; len(channels) < 3 && i < 100; i++ {
+		channel := string('a' + byte(i))
+		pool, _ := server.HashRing.GetConnectionPoolForKey([]byte(channel))
+		if (pool == server.ConnectionCluster[0]) == (len(channels) != 1) {
+			channels = append(channels, channel)
+		}
+	}
+	if len(channels) < 3 {
+		t.Fatalf("Could not find channels on both pools")
+	}
+
+	healthy, output := newTestClient(server)
+	defer healthy.closeSubscriptions()
+	runClientCommand(t, server, healthy, "subscribe", channels[0])
+	receivePushes(t, healthy, 1)
+
+	//A subscribe that is stuck connecting to the second pool...
+	stuck, _ := newTestClient(server)
+	stuckDone := make(chan struct{})
+	go func() {
+		defer close(stuckDone)
+		runClientCommand(t, server, stuck, "subscribe", channels[1])
+	}()
+	defer func() {
+		close(release)
+		<-stuckDone
+		stuck.closeSubscriptions()
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	//...doesn't hold up subscriptions, or messages, on the first pool
+	output.Reset()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runClientCommand(t, server, healthy, "subscribe", channels[2])
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Subscribing on a healthy pool was held up by a pool that is still connecting")
+	}
+	receivePushes(t, healthy, 1)
+	if message := "*3\r\n" + bulkReply("message") + bulkReply(channels[2]) + bulkReply("pool1"); !strings.Contains(output.String(), message) {
+		t.Errorf("Expected a message from the healthy pool, got %q", output.String())
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"github.com/salesforce/rmux/protocol"
+	"strconv"
+)
+
+const (
+	//The low bits of a composite SCAN cursor hold the index of the connection pool being scanned
+	SCAN_POOL_BITS = 7
+	SCAN_POOL_MASK = 1<<SCAN_POOL_BITS - 1
+)
+
+var (
+	INVALID_CURSOR_RESPONSE = []byte("-ERR invalid cursor")
+	SCAN_COMMAND            = []byte("scan")
+)
+
+//Runs a SCAN over every connection pool, one pool after another
+//The cursor handed back to the client holds both the pool being scanned and that pool's own cursor, so that clients can
+//keep calling SCAN until they get a 0 cursor back, without knowing about the pools
+func splitScan(client *Client, command protocol.Command) *pendingReply {
+	args := command.GetArgs()
+	if len(args) == 0 {
+		return &pendingReply{err: protocol.ERR_BAD_ARGUMENTS}
+	}
+
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return immediateReply(INVALID_CURSOR_RESPONSE)
+	}
+
+	connectionPools := client.HashRing.GetConnectionPools()
+	poolIndex := int(cursor & SCAN_POOL_MASK)
+	if poolIndex >= len(connectionPools) {
+		return immediateReply(INVALID_CURSOR_RESPONSE)
+	}
+	// Pools in maintenance are still scanned, since they still hold their keys
+	connectionPool := connectionPools[poolIndex]
+	if !connectionPool.IsReachable() {
+		return &pendingReply{err: ERR_CONNECTION_DOWN}
+	}
+
+	// MATCH, COUNT and TYPE are passed through as-is
+	poolArgs := append([][]byte{[]byte(strconv.FormatUint(cursor>>SCAN_POOL_BITS, 10))}, args[1:]...)
+
+	pending := &pendingReply{}
+	pending.requests = []*poolRequest{{command: protocol.NewMultibulkCommand(SCAN_COMMAND, poolArgs...), pool: connectionPool}}
+	pending.merge = func(requests []*poolRequest) ([]byte, error) {
+		request := requests[0]
+		if request.err != nil {
+			return nil, request.err
+		} else if len(request.reply) > 0 && request.reply[0] == '-' {
+			return request.reply, nil
+		}
+
+		elements, err := protocol.SplitArrayResponse(request.reply)
+		if err != nil || len(elements) != 2 {
+			return nil, protocol.ERROR_BAD_BULK_FORMAT
+		}
+
+		poolCursor, err := parseBulkCursor(elements[0])
+		if err != nil {
+			return nil, err
+		}
+
+		nextCursor, err := nextScanCursor(poolCursor, poolIndex, len(connectionPools))
+		if err != nil {
+			return nil, err
+		}
+
+		cursorString := strconv.FormatUint(nextCursor, 10)
+		elements[0] = []byte("$" + strconv.Itoa(len(cursorString)) + "\r\n" + cursorString + "\r\n")
+		return protocol.JoinArrayResponse(elements), nil
+	}
+
+	return pending
+}
+
+//Works out the composite cursor that follows a pool's reply.  Once a pool is done, the scan moves on to the next pool,
+//and once every pool is done, the cursor is 0
+func nextScanCursor(poolCursor uint64, poolIndex, poolCount int) (uint64, error) {
+	if poolCursor == 0 {
+		if poolIndex+1 >= poolCount {
+			return 0, nil
+		}
+		return uint64(poolIndex + 1), nil
+	}
+
+	if poolCursor>>(64-SCAN_POOL_BITS) != 0 {
+		return 0, ERR_CURSOR_OVERFLOW
+	}
+	return poolCursor<<SCAN_POOL_BITS | uint64(poolIndex), nil
+}
+
+//Parses the cursor out of a bulk string, such as $2\r\n17\r\n
+func parseBulkCursor(bulk []byte) (uint64, error) {
+	newlinePos := bytes.Index(bulk, protocol.REDIS_NEWLINE)
+	if len(bulk) == 0 || bulk[0] != '$' || newlinePos < 0 {
+		return 0, protocol.ERROR_BAD_BULK_FORMAT
+	}
+
+	return strconv.ParseUint(string(bytes.TrimSuffix(bulk[newlinePos+2:], protocol.REDIS_NEWLINE)), 10, 64)
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"github.com/salesforce/rmux/protocol"
+	"strconv"
+	"testing"
+)
+
+//Answers SCAN with one key per call, where the cursor is the index of the next key
+func scanHandler(t *testing.T, keys ...string) func(protocol.Command) string {
+	return func(command protocol.Command) string {
+		args := command.GetArgs()
+		if len(args) != 3 || string(args[1]) != "MATCH" || string(args[2]) != "*" {
+			t.Errorf("Expected MATCH to be passed through, got %q", command.GetBuffer())
+		}
+
+		cursor, _ := strconv.Atoi(string(args[0]))
+		nextCursor := strconv.Itoa(cursor + 1)
+		if cursor+1 >= len(keys) {
+			nextCursor = "0"
+		}
+		return "*2\r\n" + bulkReply(nextCursor) + "*1\r\n" + bulkReply(keys[cursor])
+	}
+}
+
+func TestScanAcrossPools(t *testing.T) {
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxScanTest1.sock", scanHandler(t, "a1", "a2", "a3"))
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxScanTest2.sock", scanHandler(t, "b1", "b2"))
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxScanTest.sock", "/tmp/rmuxScanTest1.sock", "/tmp/rmuxScanTest2.sock")
+	defer server.Listener.Close()
+
+	seen := map[string]bool{}
+	cursor := "0"
+	for calls := 0; calls == 0 || cursor != "0"; calls++ {
+		if calls > 10 {
+			t.Fatalf("SCAN never finished")
+		}
+
+		client, output := newTestClient(server)
+		runTestCommand(t, client, "scan", cursor, "MATCH", "*")
+
+		elements, err := protocol.SplitArrayResponse(output.Bytes())
+		if err != nil || len(elements) != 2 {
+			t.Fatalf("Bad SCAN reply %q", output.String())
+		}
+		poolCursor, err := parseBulkCursor(elements[0])
+		if err != nil {
+			t.Fatalf("Bad SCAN cursor %q", elements[0])
+		}
+		cursor = strconv.FormatUint(poolCursor, 10)
+
+		keys, _ := protocol.SplitArrayResponse(elements[1])
+		for _, key := range keys {
+			seen[string(key)] = true
+		}
+	}
+
+	for _, key := range []string{"a1", "a2", "a3", "b1", "b2"} {
+		if !seen[bulkReply(key)] {
+			t.Errorf("SCAN never returned %s", key)
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("SCAN returned unexpected keys: %v", seen)
+	}
+
+	client, output := newTestClient(server)
+	runTestCommand(t, client, "scan", "5", "MATCH", "*")
+	if expected := string(INVALID_CURSOR_RESPONSE) + "\r\n"; output.String() != expected {
+		t.Errorf("Expected %q for a cursor naming a missing pool, got %q", expected, output.String())
+	}
+}
+
+func TestNextScanCursor(t *testing.T) {
+	var testData = []struct {
+		poolCursor uint64
+		poolIndex  int
+		poolCount  int
+		expected   uint64
+	}{
+		{0, 0, 2, 1},
+		{0, 1, 2, 0},
+		{5, 0, 2, 5 << SCAN_POOL_BITS},
+		{5, 1, 2, 5<<SCAN_POOL_BITS | 1},
+	}
+
+	for _, data := range testData {
+		cursor, err := nextScanCursor(data.poolCursor, data.poolIndex, data.poolCount)
+		if err != nil || cursor != data.expected {
+			t.Errorf("Expected cursor %d after pool %d's cursor %d, got %d (%v)", data.expected, data.poolIndex, data.poolCursor, cursor, err)
+		}
+	}
+
+	if _, err := nextScanCursor(1<<60, 0, 2); err != ERR_CURSOR_OVERFLOW {
+		t.Errorf("Expected an overflowing cursor to error, got %v", err)
+	}
+}
@@ -26,7 +26,6 @@
 package rmux
 
 import (
-	"fmt"
 	"github.com/salesforce/rmux/connection"
 	"github.com/salesforce/rmux/graphite"
 	. "github.com/salesforce/rmux/log"
@@ -36,11 +35,9 @@ import (
 	"os"
 	"os/signal"
 	"runtime"
-	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
-	"bytes"
 )
 
 var (
@@ -48,6 +45,8 @@ var (
 	MULTIPLEX_OPERATION_UNSUPPORTED_RESPONSE = []byte("This command is not supported for multiplexing servers")
 	//Response code for when a client can't connect to any target servers
 	CONNECTION_DOWN_RESPONSE = []byte("Connection down")
+	//Response for when a command's keys have to live on a single connection pool, but hash to more than one
+	CROSS_POOL_RESPONSE = []byte("-CROSSSLOT Keys in request don't hash to the same connection pool")
 )
 
 var version string = "dev"
@@ -56,6 +55,8 @@ var version string = "dev"
 //Listens on a specified socket or port, and assigns out queries to any number of connection pools
 //If more than one connection pool is given multi-key operations are blocked
 type RedisMultiplexer struct {
+	// The id of the last client to connect.  Kept first, so that it is aligned for atomic access
+	lastClientId uint64
 	HashRing *connection.HashRing
 	//hashmap of [connection endpoint] -> connectionPools
 	ConnectionCluster []*connection.ConnectionPool
@@ -85,12 +86,56 @@ type RedisMultiplexer struct {
 	connectionCount int32
 	//whether or not we are multiplexing
 	multiplexing bool
-	// Cached 'info' command response for multiplexing servers
-	infoResponse []byte
-	// Read/Write mutex for above infoResponse slice
-	infoMutex sync.RWMutex
+	// When the multiplexer was created, for INFO's uptime
+	started time.Time
+	// Call counts and latency for every command that clients have sent, for INFO commandstats
+	commandStats *commandStats
 	// Whether to failover to another connection pool if the target connection pool is down (in multiplexing mode)
 	Failover bool
+	// Whether keys on a down connection pool come back as nil from a split-up MGET, instead of failing the whole command
+	NilOnPoolDown bool
+	// Whether only the {...} hash tag of a key is hashed (in multiplexing mode)
+	HashTags bool
+	// Whether FLUSHDB and FLUSHALL are broadcast to every connection pool (in multiplexing mode)
+	AllowFlush bool
+	// How many clients can be blocked on each connection pool at once. Defaults to PoolSize
+	BlockingPoolSize int
+	// How long a client waits for a free connection in a pool before it's given an error. Waits forever if 0
+	PoolAcquireTimeout time.Duration
+	// How many clients can wait for a free connection in each pool at once. Unlimited if 0
+	PoolMaxWaiters int
+	// The most connections that each pool has open at once, blocking or not. Any number if 0
+	PoolMaxActive int
+	// The fewest idle connections that each pool keeps open
+	PoolMinIdle int
+	// How long an idle connection goes unused before it is closed. Never closed if 0
+	PoolIdleTimeout time.Duration
+	// How long a connection stays open before it is replaced. Forever if 0
+	PoolMaxLifetime time.Duration
+	// How long a pool waits to reconnect after its first failed dial. Doubles with every failure after it. Never waits if 0
+	ReconnectBackoff time.Duration
+	// The longest that a pool waits to reconnect
+	MaxReconnectBackoff time.Duration
+	// How many of a pool's connections can dial redis at once. Unlimited if 0
+	MaxConcurrentDials int
+	// The password that clients must AUTH with before running any other command. Empty if none is required
+	Password string
+	// The password that clients must send with RMUX AUTH before running the RMUX subcommands that change the server
+	AdminPassword string
+	// A file listing the endpoints to keep in maintenance.  Read on startup, and again on every SIGUSR1
+	MaintenanceFile string
+	// The endpoints that RMUX DRAIN and MaintenanceFile have put in maintenance
+	maintenance *maintenanceState
+	// Closed once the server starts shutting down
+	stopped chan struct{}
+	// The credentials that connections to the redis servers authenticate with
+	EndpointCredentials connection.Credentials
+	// Credentials for specific redis servers, keyed by endpoint, which take the place of EndpointCredentials
+	CredentialsByEndpoint map[string]connection.Credentials
+	// The upstream subscriptions shared by every subscribed client
+	subscriptionHub *subscriptionHub
+	// Every connected client, for CLIENT LIST and CLIENT KILL
+	clients *clientRegistry
 }
 
 //Sub-task that handles the cleanup when a server goes down
@@ -103,6 +148,7 @@ func (this *RedisMultiplexer) initializeCleanup() {
 	<-c
 	//Flag ourselves as cleaning up
 	this.active = false
+	close(this.stopped)
 	//And close our listener
 	this.Listener.Close()
 	//Give ourselves a bit to clean up
@@ -127,7 +173,14 @@ func NewRedisMultiplexer(listenProtocol, listenEndpoint string, poolSize int) (n
 	newRedisMultiplexer.EndpointWriteTimeout = connection.EXTERN_WRITE_TIMEOUT
 	newRedisMultiplexer.ClientReadTimeout = connection.EXTERN_READ_TIMEOUT
 	newRedisMultiplexer.ClientWriteTimeout = connection.EXTERN_WRITE_TIMEOUT
-	newRedisMultiplexer.infoMutex = sync.RWMutex{}
+	newRedisMultiplexer.ReconnectBackoff = connection.DEFAULT_RECONNECT_BACKOFF
+	newRedisMultiplexer.MaxReconnectBackoff = connection.DEFAULT_MAX_RECONNECT_BACKOFF
+	newRedisMultiplexer.started = time.Now()
+	newRedisMultiplexer.commandStats = newCommandStats()
+	newRedisMultiplexer.subscriptionHub = newSubscriptionHub()
+	newRedisMultiplexer.clients = newClientRegistry()
+	newRedisMultiplexer.maintenance = newMaintenanceState()
+	newRedisMultiplexer.stopped = make(chan struct{})
 //	Debug("Redis Multiplexer Initialized")
 	return
 }
@@ -136,6 +189,21 @@ func NewRedisMultiplexer(listenProtocol, listenEndpoint string, poolSize int) (n
 func (this *RedisMultiplexer) AddConnection(remoteProtocol, remoteEndpoint string) {
 	connectionCluster := connection.NewConnectionPool(remoteProtocol, remoteEndpoint, this.PoolSize,
 		this.EndpointConnectTimeout, this.EndpointReadTimeout, this.EndpointWriteTimeout)
+	if this.BlockingPoolSize > 0 {
+		connectionCluster.SetBlockingCapacity(this.BlockingPoolSize)
+	}
+	connectionCluster.SetReconnectBackoff(this.ReconnectBackoff, this.MaxReconnectBackoff, this.MaxConcurrentDials)
+	connectionCluster.AcquireTimeout = this.PoolAcquireTimeout
+	connectionCluster.MaxWaiters = int32(this.PoolMaxWaiters)
+	connectionCluster.SetMaxActive(this.PoolMaxActive)
+	connectionCluster.MinIdle = this.PoolMinIdle
+	connectionCluster.IdleTimeout = this.PoolIdleTimeout
+	connectionCluster.MaxLifetime = this.PoolMaxLifetime
+	if credentials, ok := this.CredentialsByEndpoint[remoteEndpoint]; ok {
+		connectionCluster.Credentials = credentials
+	} else {
+		connectionCluster.Credentials = this.EndpointCredentials
+	}
 	this.ConnectionCluster = append(this.ConnectionCluster, connectionCluster)
 	if len(this.ConnectionCluster) == 1 {
 		this.PrimaryConnectionPool = connectionCluster
@@ -163,17 +231,18 @@ func (this *RedisMultiplexer) maintainConnectionStates() {
 //		// Debug("We have %d connections", this.connectionCount)
 		runtime.ReadMemStats(&m)
 //		// Debug("Memory profile: InUse(%d) Idle (%d) Released(%d)", m.HeapInuse, m.HeapIdle, m.HeapReleased)
-		this.generateMultiplexInfo()
 		time.Sleep(100 * time.Millisecond)
 	}
 }
 
-//Generates the Info response for a multiplexed server
-func (this *RedisMultiplexer) generateMultiplexInfo() {
-	tmpSlice := fmt.Sprintf("rmux_version: %s\r\ngo_version: %s\r\nprocess_id: %d\r\nconnected_clients: %d\r\nactive_endpoints: %d\r\ntotal_endpoints: %d\r\nrole: master\r\n", version, runtime.Version(), os.Getpid(), this.connectionCount, this.activeConnectionCount, len(this.ConnectionCluster))
-	this.infoMutex.Lock()
-	this.infoResponse = []byte(fmt.Sprintf("$%d\r\n%s", len(tmpSlice), tmpSlice))
-	this.infoMutex.Unlock()
+//Closes the connections that have gone unused or have been open for too long, and opens the pools' idle connections
+func (this *RedisMultiplexer) maintainIdleConnections() {
+	for this.active {
+		for _, connectionPool := range this.ConnectionCluster {
+			connectionPool.ReapIdleConnections()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
 }
 
 //Called when a rmux server is ready to begin accepting connections
@@ -182,8 +251,19 @@ func (this *RedisMultiplexer) Start() (err error) {
 	if err != nil {
 		return err
 	}
+	this.HashRing.HashTags = this.HashTags
+
+	if this.MaintenanceFile != "" {
+		if err := this.ApplyMaintenanceFile(); err != nil {
+			return err
+		}
+		go this.handleMaintenanceSignals()
+	}
 
 	go this.maintainConnectionStates()
+	if this.PoolMinIdle > 0 || this.PoolIdleTimeout > 0 || this.PoolMaxLifetime > 0 {
+		go this.maintainIdleConnections()
+	}
 	go this.initializeCleanup()
 	//if graphite.Enabled() {
 	//	go this.GraphiteCheckin()
@@ -213,6 +293,14 @@ func (this *RedisMultiplexer) initializeClient(localConnection net.Conn) {
 	//Add the connection to our internal list
 	myClient := NewClient(localConnection, this.ClientReadTimeout, this.ClientWriteTimeout,
 		this.multiplexing, this.HashRing)
+	myClient.NilOnPoolDown = this.NilOnPoolDown
+	myClient.AllowFlush = this.AllowFlush
+	myClient.subscriptionHub = this.subscriptionHub
+	myClient.Password = this.Password
+	myClient.commandStats = this.commandStats
+	myClient.Id = atomic.AddUint64(&this.lastClientId, 1)
+	this.clients.add(myClient)
+	defer this.clients.remove(myClient)
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -230,14 +318,6 @@ func (this *RedisMultiplexer) initializeClient(localConnection net.Conn) {
 	this.HandleClientRequests(myClient)
 }
 
-//Sends the pre-generated Info response for a multiplexed server
-func (this *RedisMultiplexer) sendMultiplexInfo(myClient *Client) (err error) {
-	this.infoMutex.RLock()
-	err = protocol.WriteLine(this.infoResponse, myClient.Writer, true)
-	this.infoMutex.RUnlock()
-	return
-}
-
 func (this *RedisMultiplexer) GraphiteCheckin() {
 	for this.active {
 		time.Sleep(time.Millisecond * 100)
@@ -258,6 +338,8 @@ func (this *RedisMultiplexer) HandleClientRequests(client *Client) {
 //		Debug("Client command handling loop closing")
 		// If the multiplexer goes down, deactivate this client.
 		client.Active = false
+		client.closeSubscriptions()
+		client.releaseTransaction()
 	}()
 
 	for this.active && client.Active {
@@ -269,6 +351,14 @@ func (this *RedisMultiplexer) HandleClientRequests(client *Client) {
 			if item.err != nil {
 				this.HandleError(client, item.err)
 			}
+		case item := <-client.PushChannel:
+			if err := client.WritePush(item); err != nil {
+				// The client's subscriptions are gone, so close the connection rather than leave it waiting forever
+				client.FlushError(err)
+				client.Active = false
+			} else {
+				client.Writer.Flush()
+			}
 		case <-time.After(time.Second * 1):
 			// Allow heartbeat checks to happen once a second
 		}
@@ -301,20 +391,36 @@ ChunkLoop:
 }
 
 func (this *RedisMultiplexer) HandleCommand(client *Client, command protocol.Command) {
-	if this.multiplexing && bytes.Equal(command.GetCommand(), protocol.INFO_COMMAND) {
-		this.sendMultiplexInfo(client)
+	// Commands that are queued are recorded once their replies are written out
+	start := time.Now()
+	queued, rejected := false, false
+	defer func() {
+		// Clients that haven't authenticated can't add to the stats, since they could be anyone
+		if client.IsAuthenticated() {
+			if rejected {
+				client.commandStats.reject(command)
+			} else if !queued {
+				client.commandStats.record(command, time.Since(start))
+			}
+		}
+		client.recordInfo(command, start)
+	}()
+
+	// Commands that rmux answers itself are answered right away
+	if handle := this.localHandler(client, command); handle != nil {
+		client.respondToQueued()
+		handle(command)
 		return
 	}
 
 //	Debug("Writing out %q", command)
 	immediateResponse, err := client.ParseCommand(command)
 
-	if immediateResponse != nil {
-		// Respond with anything we have queued
-		if client.HasQueued() {
-			client.FlushRedisAndRespond()
-		}
+	if immediateResponse != nil || err != nil {
+		client.respondToQueued()
+	}
 
+	if immediateResponse != nil {
 		err = client.WriteLine(immediateResponse)
 		if err != nil {
 //			Debug("Error received when writing an immediate response: %s", err)
@@ -327,6 +433,7 @@ func (this *RedisMultiplexer) HandleCommand(client *Client, command protocol.Com
 			client.ReadChannel <- readItem{nil, err}
 			return
 		} else if recErr, ok := err.(*protocol.RecoverableError); ok {
+			rejected = true
 			client.WriteError(recErr, false)
 		} else {
 			panic("Not sure how to handle this error: " + err.Error())
@@ -335,13 +442,38 @@ func (this *RedisMultiplexer) HandleCommand(client *Client, command protocol.Com
 		return
 	}
 
+	// Blocking commands get a connection of their own, so they are run right away instead of being pipelined
+	if timeout, isBlocking := protocol.GetBlockingTimeout(command); isBlocking {
+		client.respondToQueued()
+		client.RunBlocking(command, timeout)
+		return
+	}
+
 	// Otherwise, the command is ready to buffer to the connection.
+	// When multiplexing, the queued pipeline is split up by connection pool when it gets flushed.
+	queued = true
 	client.Queue(command)
+}
 
-	// If we're multiplexing, just handle one command at a time
-	if this.multiplexing && client.HasQueued() {
-		client.FlushRedisAndRespond()
+//Gets the handler of a command that rmux answers itself, instead of sending it on to redis.  Nil for any other command
+//Checked in order, so that AUTH comes before everything else, and commands inside MULTI are queued by the transaction
+func (this *RedisMultiplexer) localHandler(client *Client, command protocol.Command) func(protocol.Command) error {
+	switch {
+	case client.IsAuthCommand(command):
+		return client.HandleAuthCommand
+	case this.IsAdminCommand(command):
+		return func(command protocol.Command) error { return this.HandleAdminCommand(client, command) }
+	case client.IsInTransaction() && !client.IsInMulti() && this.IsInfoCommand(command):
+		// Between WATCH and MULTI, INFO is still rmux's own.  Inside MULTI, it is rejected like rmux's other commands
+		return func(command protocol.Command) error { return this.HandleInfoCommand(client, command) }
+	case client.IsTransactionCommand(command):
+		return client.HandleTransactionCommand
+	case client.IsSubscriberCommand(command):
+		return client.HandleSubscriberCommand
+	case this.IsInfoCommand(command):
+		return func(command protocol.Command) error { return this.HandleInfoCommand(client, command) }
 	}
+	return nil
 }
 
 func (this *RedisMultiplexer) HandleError(client *Client, err error) {
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"github.com/salesforce/rmux/connection"
+	"github.com/salesforce/rmux/protocol"
+	"github.com/salesforce/rmux/writer"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Common functions and structs useful for tests in this package
+
+//Starts a fake redis server on the given socket, which answers every command with the given handler
+//PING and SELECT are answered automatically, so that the server looks healthy to rmux
+func StartMockRedisServer(t *testing.T, sock string, handler func(command protocol.Command) string) net.Listener {
+	listenSock, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("Cannot listen on %s: %s", sock, err)
+	}
+
+	go func() {
+		for {
+			c, err := listenSock.Accept()
+			if err != nil {
+				break
+			}
+
+			go func(c net.Conn) {
+				defer c.Close()
+				scanner := protocol.NewRespScanner(c)
+				for scanner.Scan() {
+					command, err := protocol.ParseCommand(scanner.Bytes())
+					if err != nil {
+						return
+					}
+
+					var response string
+					if bytes.Equal(command.GetCommand(), protocol.PING_COMMAND) {
+						response = "+PONG\r\n"
+					} else if bytes.Equal(command.GetCommand(), protocol.SELECT_COMMAND) {
+						response = "+OK\r\n"
+					} else {
+						response = handler(command)
+					}
+
+					if _, err := c.Write([]byte(response)); err != nil {
+						return
+					}
+				}
+			}(c)
+		}
+	}()
+
+	return listenSock
+}
+
+//Formats the given string as a redis bulk string reply
+func bulkReply(s string) string {
+	return "$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n"
+}
+
+//Creates a multiplexer over the given sockets, with every pool checked and a hash ring in place
+func newTestMultiplexer(t *testing.T, sock string, endpoints ...string) *RedisMultiplexer {
+	server, err := NewRedisMultiplexer("unix", sock, 2)
+	if err != nil {
+		t.Fatalf("Cannot listen on %s: %s", sock, err)
+	}
+
+	server.SetAllTimeouts(100 * time.Millisecond)
+	for _, endpoint := range endpoints {
+		server.AddConnection("unix", endpoint)
+	}
+
+	server.countActiveConnections()
+
+	server.HashRing, err = connection.NewHashRing(server.ConnectionCluster, server.Failover)
+	if err != nil {
+		t.Fatalf("Error creating hash ring: %s", err)
+	}
+	server.HashRing.HashTags = server.HashTags
+
+	return server
+}
+
+//Creates a client of the given multiplexer, whose responses are written to the returned buffer
+func newTestClient(server *RedisMultiplexer) (*Client, *bytes.Buffer) {
+	local, _ := net.Pipe()
+	client := NewClient(local, time.Second, time.Second, server.multiplexing, server.HashRing)
+	client.subscriptionHub = server.subscriptionHub
+	client.commandStats = server.commandStats
+	client.Id = atomic.AddUint64(&server.lastClientId, 1)
+	server.clients.add(client)
+	output := new(bytes.Buffer)
+	client.Writer = writer.NewFlexibleWriter(output)
+	return client, output
+}
+
+//Has the server handle a command from the client, the way it would if the client had sent it, and flushes the reply
+func runClientCommand(t *testing.T, server *RedisMultiplexer, client *Client, args ...string) {
+	command, err := protocol.ParseCommand([]byte(makeTestCommand(args...)))
+	if err != nil {
+		t.Fatalf("Error parsing command: %s", err)
+	}
+	server.HandleCommand(client, command)
+	client.FlushRedisAndRespond()
+}
+
+//Builds a multibulk command out of the given arguments
+func makeTestCommand(args ...string) string {
+	command := "*" + strconv.Itoa(len(args)) + "\r\n"
+	for _, arg := range args {
+		command += bulkReply(arg)
+	}
+	return command
+}
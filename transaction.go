@@ -0,0 +1,384 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"bytes"
+	"errors"
+	"github.com/salesforce/rmux/connection"
+	"github.com/salesforce/rmux/protocol"
+	"time"
+)
+
+//A client's transaction, and the redis connection that it is pinned to
+//Redis keeps watched keys and queued commands per connection, so a client is pinned to a single connection from WATCH
+//or MULTI until EXEC, DISCARD or UNWATCH
+type transaction struct {
+	//The connection pool that the transaction's keys hash to, once any of them have been seen
+	pool *connection.ConnectionPool
+	//The pinned connection, which is only taken from the pool once something needs to be sent to redis
+	redisConn *connection.Connection
+	//Set between MULTI and EXEC (or DISCARD)
+	inMulti bool
+	//Set while redis is watching keys on the pinned connection
+	watching bool
+	//The commands queued since MULTI, which are only sent to redis once the client calls EXEC
+	commands []protocol.Command
+	//Set once a command has been rejected inside MULTI, so that EXEC fails the same way that it does in redis
+	aborted bool
+}
+
+var (
+	MULTI_COMMAND   = []byte("multi")
+	EXEC_COMMAND    = []byte("exec")
+	DISCARD_COMMAND = []byte("discard")
+	WATCH_COMMAND   = []byte("watch")
+	UNWATCH_COMMAND = []byte("unwatch")
+
+	QUEUED_RESPONSE    = []byte("+QUEUED")
+	EXECABORT_RESPONSE = []byte("-EXECABORT Transaction discarded because of previous errors.")
+
+	ERR_NESTED_MULTI          = errors.New("MULTI calls can not be nested")
+	ERR_EXEC_WITHOUT_MULTI    = errors.New("EXEC without MULTI")
+	ERR_DISCARD_WITHOUT_MULTI = errors.New("DISCARD without MULTI")
+	ERR_WATCH_INSIDE_MULTI    = errors.New("WATCH inside MULTI is not allowed")
+	ERR_NOT_IN_TRANSACTION    = errors.New("Command not allowed inside a transaction")
+
+	//Commands that rmux answers itself, which can't be queued up for redis
+	localCommands = map[string]bool{
+		"select": true,
+		"hello":  true,
+		"client": true,
+		"info":   true,
+	}
+
+	//Commands that start, or end, a transaction
+	transactionCommands = map[string]bool{
+		"multi":   true,
+		"exec":    true,
+		"discard": true,
+		"watch":   true,
+		"unwatch": true,
+	}
+)
+
+//Whether or not the client is pinned to a connection for a transaction
+func (this *Client) IsInTransaction() bool {
+	return this.transaction != nil
+}
+
+//Whether or not the client is between MULTI and EXEC
+func (this *Client) IsInMulti() bool {
+	return this.transaction != nil && this.transaction.inMulti
+}
+
+//Whether or not the command needs to be handled by HandleTransactionCommand, instead of being pipelined to redis
+func (this *Client) IsTransactionCommand(command protocol.Command) bool {
+	if this.IsSubscribed() {
+		return false
+	} else if this.IsInTransaction() {
+		// Quitting works the same way whether or not the client is in a transaction
+		return !bytes.Equal(command.GetCommand(), protocol.QUIT_COMMAND)
+	}
+	return transactionCommands[string(command.GetCommand())]
+}
+
+//Handles a command from a client that is in a transaction, or is about to start one
+//Replies are buffered on the client's writer, and get flushed along with the rest of the client's pipeline
+func (this *Client) HandleTransactionCommand(command protocol.Command) error {
+	if err := protocol.CheckCommand(command, this.Multiplexing); err != nil {
+		return this.rejectInTransaction(err)
+	}
+
+	name := command.GetCommand()
+	switch {
+	case bytes.Equal(name, MULTI_COMMAND):
+		return this.multi()
+	case bytes.Equal(name, EXEC_COMMAND):
+		return this.exec()
+	case bytes.Equal(name, DISCARD_COMMAND):
+		return this.discard()
+	case bytes.Equal(name, WATCH_COMMAND):
+		return this.watch(command)
+	case bytes.Equal(name, UNWATCH_COMMAND) && (this.transaction == nil || !this.transaction.inMulti):
+		// UNWATCH inside MULTI gets queued like anything else
+		this.releaseTransaction()
+		return this.WriteLine(protocol.OK_RESPONSE)
+	}
+
+	if subscriptionCommands[string(name)] || (localCommands[string(name)] && this.transaction.inMulti) {
+		return this.rejectInTransaction(ERR_NOT_IN_TRANSACTION)
+	} else if err := this.pinTransactionPool(command); err != nil {
+		return this.rejectInTransaction(err)
+	}
+
+	if this.transaction.inMulti {
+		this.transaction.commands = append(this.transaction.commands, command)
+		return this.WriteLine(QUEUED_RESPONSE)
+	}
+
+	//Between WATCH and MULTI, commands are run right away on the pinned connection
+	if immediateResponse, err := this.ParseCommand(command); immediateResponse != nil {
+		return this.WriteLine(immediateResponse)
+	} else if err != nil {
+		return this.WriteError(err, false)
+	}
+
+	var replies [][]byte
+	var err error
+	if timeout, isBlocking := protocol.GetBlockingTimeout(command); isBlocking {
+		replies, err = this.runPinnedBlocking(command, timeout)
+	} else {
+		replies, err = this.runPinned([]protocol.Command{command})
+	}
+	if err != nil {
+		return this.WriteError(err, false)
+	}
+	_, err = this.Writer.Write(this.convertReply(command, replies[0]))
+	return err
+}
+
+func (this *Client) multi() error {
+	if this.transaction == nil {
+		this.transaction = &transaction{}
+	} else if this.transaction.inMulti {
+		return this.rejectInTransaction(ERR_NESTED_MULTI)
+	}
+
+	this.transaction.inMulti = true
+	return this.WriteLine(protocol.OK_RESPONSE)
+}
+
+//Sends the queued commands to redis, wrapped in MULTI and EXEC, and replies with EXEC's reply
+func (this *Client) exec() error {
+	tx := this.transaction
+	if tx == nil || !tx.inMulti {
+		return this.WriteError(ERR_EXEC_WITHOUT_MULTI, false)
+	}
+	defer this.releaseTransaction()
+
+	if tx.aborted {
+		return this.WriteLine(EXECABORT_RESPONSE)
+	}
+
+	commands := make([]protocol.Command, 0, len(tx.commands)+2)
+	commands = append(commands, protocol.NewMultibulkCommand(MULTI_COMMAND))
+	commands = append(commands, tx.commands...)
+	commands = append(commands, protocol.NewMultibulkCommand(EXEC_COMMAND))
+
+	replies, err := this.runPinned(commands)
+	if err != nil {
+		return this.WriteError(err, false)
+	}
+	// Redis drops its watched keys once EXEC runs
+	tx.watching = false
+
+	_, err = this.Writer.Write(this.convertExecReply(tx.commands, replies[len(replies)-1]))
+	return err
+}
+
+//Converts each of EXEC's replies by the command that it belongs to
+func (this *Client) convertExecReply(commands []protocol.Command, reply []byte) []byte {
+	if this.Protocol != 3 {
+		return reply
+	}
+
+	elements, err := protocol.SplitArrayResponse(reply)
+	if err != nil || len(elements) != len(commands) {
+		// A nil reply (from a WATCHed key changing) or an error
+		return protocol.ConvertToResp3(nil, reply)
+	}
+	for i, element := range elements {
+		elements[i] = this.convertReply(commands[i], element)
+	}
+	return protocol.JoinArrayResponse(elements)
+}
+
+func (this *Client) discard() error {
+	if this.transaction == nil || !this.transaction.inMulti {
+		return this.WriteError(ERR_DISCARD_WITHOUT_MULTI, false)
+	}
+
+	// Nothing has been sent to redis since MULTI, so there is nothing to discard there
+	this.releaseTransaction()
+	return this.WriteLine(protocol.OK_RESPONSE)
+}
+
+func (this *Client) watch(command protocol.Command) error {
+	if this.transaction == nil {
+		this.transaction = &transaction{}
+	} else if this.transaction.inMulti {
+		return this.rejectInTransaction(ERR_WATCH_INSIDE_MULTI)
+	}
+
+	if err := this.pinTransactionPool(command); err != nil {
+		if !this.transaction.watching {
+			this.releaseTransaction()
+		}
+		return this.writeTransactionError(err)
+	}
+
+	replies, err := this.runPinned([]protocol.Command{command})
+	if err != nil {
+		return this.WriteError(err, false)
+	}
+	this.transaction.watching = true
+
+	_, err = this.Writer.Write(this.convertReply(command, replies[0]))
+	return err
+}
+
+//Replies with the error, and makes sure that EXEC fails if the client is inside MULTI
+func (this *Client) rejectInTransaction(err error) error {
+	if this.transaction != nil && this.transaction.inMulti {
+		this.transaction.aborted = true
+	}
+	return this.writeTransactionError(err)
+}
+
+func (this *Client) writeTransactionError(err error) error {
+	if err == ERR_CROSS_POOL {
+		return this.WriteLine(CROSS_POOL_RESPONSE)
+	}
+	return this.WriteError(err, false)
+}
+
+//Makes sure that the command's keys hash to the transaction's connection pool, settling on that pool if need be
+//Commands that are broadcast to every pool can't be part of a transaction when multiplexing
+func (this *Client) pinTransactionPool(command protocol.Command) error {
+	tx := this.transaction
+	if !this.Multiplexing {
+		tx.pool = this.HashRing.DefaultConnectionPool
+		return nil
+	}
+
+	keys := protocol.GetKeys(command)
+	if len(keys) == 0 {
+		if _, ok := fanoutCommands[string(command.GetCommand())]; ok {
+			return ERR_NOT_IN_TRANSACTION
+		}
+		return nil
+	}
+
+	pools, _, downKeys := groupKeysByPool(this.HashRing, keys)
+	if len(downKeys) > 0 {
+		return ERR_CONNECTION_DOWN
+	} else if len(pools) > 1 || tx.pool != nil && tx.pool != pools[0] {
+		return ERR_CROSS_POOL
+	}
+
+	tx.pool = pools[0]
+	return nil
+}
+
+//Takes the transaction's connection from its pool, if it hasn't been yet
+func (this *Client) pinConnection() error {
+	tx := this.transaction
+	if tx.redisConn != nil {
+		return nil
+	} else if tx.pool == nil {
+		tx.pool = this.HashRing.DefaultConnectionPool
+	}
+
+	redisConn, err := tx.pool.GetConnection()
+	if err != nil {
+		this.releaseTransaction()
+		return acquireError(err)
+	}
+	tx.redisConn = redisConn
+	return nil
+}
+
+//Runs a blocking command on the pinned connection, whose reads wait for up to the command's own timeout plus the pool's
+//read timeout, the same way that a blocking connection's do.  A timeout of 0 waits forever
+func (this *Client) runPinnedBlocking(command protocol.Command, timeout time.Duration) ([][]byte, error) {
+	if err := this.pinConnection(); err != nil {
+		return nil, err
+	}
+
+	tx := this.transaction
+	if timeout > 0 {
+		timeout += tx.pool.ReadTimeout
+	}
+	tx.redisConn.SetReadTimeout(timeout)
+
+	replies, err := this.runPinned([]protocol.Command{command})
+	if err == nil {
+		// Otherwise the transaction was released, which has already put the read timeout back
+		tx.redisConn.SetReadTimeout(tx.pool.ReadTimeout)
+	}
+	return replies, err
+}
+
+//Sends the commands over the transaction's pinned connection, and reads back their replies
+//If the connection fails, the transaction is over, since redis has dropped its watched keys along with it
+func (this *Client) runPinned(commands []protocol.Command) ([][]byte, error) {
+	if err := this.pinConnection(); err != nil {
+		return nil, err
+	}
+
+	tx := this.transaction
+	if err := sendCommands(tx.redisConn, this.DatabaseId, commands); err != nil {
+		tx.watching = false
+		this.releaseTransaction()
+		return nil, ERR_CONNECTION_DOWN
+	}
+
+	replies, err := protocol.ReadServerResponses(tx.redisConn.Reader, len(commands))
+	if err != nil {
+		tx.redisConn.Disconnect()
+		tx.watching = false
+		this.releaseTransaction()
+		return nil, ERR_CONNECTION_DOWN
+	}
+	return replies, nil
+}
+
+//Ends the client's transaction, and resets its pinned connection before it goes back into its pool
+func (this *Client) releaseTransaction() {
+	tx := this.transaction
+	if tx == nil {
+		return
+	}
+	this.transaction = nil
+
+	if tx.redisConn == nil {
+		return
+	}
+
+	if tx.watching && tx.redisConn.IsConnected() {
+		unwatch := []protocol.Command{protocol.NewMultibulkCommand(UNWATCH_COMMAND)}
+		if err := sendCommands(tx.redisConn, tx.redisConn.DatabaseId, unwatch); err == nil {
+			if _, err := protocol.ReadServerResponses(tx.redisConn.Reader, 1); err != nil {
+				// Don't hand out a connection that may still be watching keys
+				tx.redisConn.Disconnect()
+			}
+		}
+	}
+
+	tx.redisConn.SetReadTimeout(tx.pool.ReadTimeout)
+	tx.pool.RecycleRemoteConnection(tx.redisConn)
+}
@@ -0,0 +1,245 @@
+/*
+ * Copyright (c) 2015, Salesforce.com, Inc.
+ * All rights reserved.
+ *
+ * Redistribution and use in source and binary forms, with or without modification, are permitted provided that the
+ * following conditions are met:
+ *
+ * * Redistributions of source code must retain the above copyright notice, this list of conditions and the following
+ *   disclaimer.
+ *
+ * * Redistributions in binary form must reproduce the above copyright notice, this list of conditions and the following
+ *   disclaimer in the documentation and/or other materials provided with the distribution.
+ *
+ * * Neither the name of Salesforce.com nor the names of its contributors may be used to endorse or promote products
+ *   derived from this software without specific prior written permission.
+ *
+ * THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS" AND ANY EXPRESS OR IMPLIED WARRANTIES,
+ * INCLUDING, BUT NOT LIMITED TO, THE IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+ * DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL,
+ * SPECIAL, EXEMPLARY, OR CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+ * SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY,
+ * WHETHER IN CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+ * OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+ */
+
+package rmux
+
+import (
+	"fmt"
+	"github.com/salesforce/rmux/protocol"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//Records the commands that reach a fake redis server, and answers them the way redis would inside a transaction
+type transactionRecorder struct {
+	lock     sync.Mutex
+	commands []string
+}
+
+func (this *transactionRecorder) handle(command protocol.Command) string {
+	line := string(command.GetCommand())
+	for _, arg := range command.GetArgs() {
+		line += " " + string(arg)
+	}
+	this.lock.Lock()
+	this.commands = append(this.commands, line)
+	this.lock.Unlock()
+
+	switch string(command.GetCommand()) {
+	case "get":
+		return bulkReply("value")
+	case "set":
+		return "+QUEUED\r\n"
+	case "exec":
+		return "*1\r\n+OK\r\n"
+	}
+	return "+OK\r\n"
+}
+
+func (this *transactionRecorder) recorded() string {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return strings.Join(this.commands, ", ")
+}
+
+//Finds two keys that hash to different connection pools, the first of which hashes to the first pool
+func keysOnDifferentPools(t *testing.T, server *RedisMultiplexer) (string, string) {
+	var first, second string
+	for i := 0; i < 100 && (first == "" || second == ""); i++ {
+		key := fmt.Sprintf("key%d", i)
+		pool, _ := server.HashRing.GetConnectionPoolForKey([]byte(key))
+		if pool == server.ConnectionCluster[0] && first == "" {
+			first = key
+		} else if pool != server.ConnectionCluster[0] && second == "" {
+			second = key
+		}
+	}
+	if first == "" || second == "" {
+		t.Fatalf("Could not find keys that hash to different pools")
+	}
+	return first, second
+}
+
+func TestTransactionPinnedToPool(t *testing.T) {
+	recorder1, recorder2 := &transactionRecorder{}, &transactionRecorder{}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxTransactionTest1.sock", recorder1.handle)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxTransactionTest2.sock", recorder2.handle)
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxTransactionTest.sock", "/tmp/rmuxTransactionTest1.sock", "/tmp/rmuxTransactionTest2.sock")
+	defer server.Listener.Close()
+	key, _ := keysOnDifferentPools(t, server)
+
+	client, output := newTestClient(server)
+	defer client.releaseTransaction()
+
+	testData := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"watch", key}, "+OK\r\n"},
+		{[]string{"get", key}, bulkReply("value")},
+		{[]string{"multi"}, "+OK\r\n"},
+		{[]string{"set", key, "1"}, "+QUEUED\r\n"},
+		{[]string{"echo", "hi"}, "+QUEUED\r\n"},
+		{[]string{"exec"}, "*1\r\n+OK\r\n"},
+	}
+	for _, data := range testData {
+		output.Reset()
+		runClientCommand(t, server, client, data.args...)
+		if output.String() != data.expected {
+			t.Errorf("Unexpected reply to %v.\r\nExpected %q\r\nGot      %q", data.args, data.expected, output.String())
+		}
+	}
+
+	if client.IsInTransaction() {
+		t.Errorf("The client should have been unpinned by EXEC")
+	}
+	expected := "watch " + key + ", get " + key + ", multi, set " + key + " 1, echo hi, exec"
+	if recorded := recorder1.recorded(); recorded != expected {
+		t.Errorf("Expected the transaction to reach redis as %q, got %q", expected, recorded)
+	}
+	if recorded := recorder2.recorded(); recorded != "" {
+		t.Errorf("Nothing should have reached the other pool, got %q", recorded)
+	}
+}
+
+func TestTransactionRejectsCrossPoolKeys(t *testing.T) {
+	recorder1, recorder2 := &transactionRecorder{}, &transactionRecorder{}
+	listener1 := StartMockRedisServer(t, "/tmp/rmuxTransactionTest1.sock", recorder1.handle)
+	defer listener1.Close()
+	listener2 := StartMockRedisServer(t, "/tmp/rmuxTransactionTest2.sock", recorder2.handle)
+	defer listener2.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxTransactionTest.sock", "/tmp/rmuxTransactionTest1.sock", "/tmp/rmuxTransactionTest2.sock")
+	defer server.Listener.Close()
+	key1, key2 := keysOnDifferentPools(t, server)
+
+	client, output := newTestClient(server)
+	defer client.releaseTransaction()
+
+	runClientCommand(t, server, client, "multi")
+	runClientCommand(t, server, client, "set", key1, "1")
+	output.Reset()
+	runClientCommand(t, server, client, "set", key2, "1")
+	if expected := string(CROSS_POOL_RESPONSE) + "\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply to a key on another pool.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	output.Reset()
+	runClientCommand(t, server, client, "keys", "*")
+	if !strings.HasPrefix(output.String(), "-ERR ") {
+		t.Errorf("Commands that are broadcast to every pool should be rejected in a transaction, got %q", output.String())
+	}
+
+	output.Reset()
+	runClientCommand(t, server, client, "exec")
+	if expected := string(EXECABORT_RESPONSE) + "\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply to EXEC.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	if recorded := recorder1.recorded() + recorder2.recorded(); recorded != "" {
+		t.Errorf("Nothing should have reached redis, got %q", recorded)
+	}
+
+	//A client is free to use every pool again once its transaction is over
+	output.Reset()
+	runClientCommand(t, server, client, "get", key2)
+	if output.String() != bulkReply("value") {
+		t.Errorf("Unexpected reply after the transaction.\r\nExpected %q\r\nGot      %q", bulkReply("value"), output.String())
+	}
+}
+
+func TestTransactionConnectionIsReset(t *testing.T) {
+	recorder := &transactionRecorder{}
+	listener := StartMockRedisServer(t, "/tmp/rmuxTransactionTest1.sock", recorder.handle)
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxTransactionTest.sock", "/tmp/rmuxTransactionTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	runClientCommand(t, server, client, "exec")
+	runClientCommand(t, server, client, "discard")
+	if expected := "-ERR EXEC without MULTI\r\n-ERR DISCARD without MULTI\r\n"; output.String() != expected {
+		t.Errorf("Unexpected replies outside of a transaction.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+
+	//A client that goes away while watching keys leaves a clean connection behind
+	runClientCommand(t, server, client, "watch", "a")
+	runClientCommand(t, server, client, "multi")
+	runClientCommand(t, server, client, "set", "a", "1")
+	client.releaseTransaction()
+
+	if expected := "watch a, unwatch"; recorder.recorded() != expected {
+		t.Errorf("Expected redis to see %q, got %q", expected, recorder.recorded())
+	}
+}
+
+func TestTransactionBlockingCommandAfterWatch(t *testing.T) {
+	//Blocking commands take longer than the pool's 100ms read timeout to be answered
+	recorder := &transactionRecorder{}
+	listener := StartMockRedisServer(t, "/tmp/rmuxTransactionTest1.sock", func(command protocol.Command) string {
+		if string(command.GetCommand()) == "blpop" {
+			recorder.handle(command)
+			time.Sleep(200 * time.Millisecond)
+			return "*2\r\n" + bulkReply("a") + bulkReply("1")
+		}
+		return recorder.handle(command)
+	})
+	defer listener.Close()
+
+	server := newTestMultiplexer(t, "/tmp/rmuxTransactionTest.sock", "/tmp/rmuxTransactionTest1.sock")
+	defer server.Listener.Close()
+
+	client, output := newTestClient(server)
+	defer client.releaseTransaction()
+	runClientCommand(t, server, client, "watch", "a")
+	output.Reset()
+	runClientCommand(t, server, client, "blpop", "a", "0")
+	if expected := "*2\r\n" + bulkReply("a") + bulkReply("1"); output.String() != expected {
+		t.Errorf("Unexpected reply to a blocking command after WATCH.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	if !client.IsInTransaction() || !client.transaction.watching {
+		t.Fatalf("The blocking command should have left the keys watched")
+	}
+
+	//INFO is rmux's own before MULTI, and is rejected inside it
+	output.Reset()
+	runClientCommand(t, server, client, "info", "rmux")
+	if !strings.Contains(output.String(), "# Rmux\r\n") {
+		t.Errorf("Expected rmux to answer INFO between WATCH and MULTI, got %q", output.String())
+	}
+	runClientCommand(t, server, client, "multi")
+	output.Reset()
+	runClientCommand(t, server, client, "info")
+	if expected := "-ERR " + ERR_NOT_IN_TRANSACTION.Error() + "\r\n"; output.String() != expected {
+		t.Errorf("Unexpected reply to INFO inside MULTI.\r\nExpected %q\r\nGot      %q", expected, output.String())
+	}
+	if expected := "watch a, blpop a 0"; recorder.recorded() != expected {
+		t.Errorf("Expected redis to see %q, got %q", expected, recorder.recorded())
+	}
+}